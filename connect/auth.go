@@ -12,7 +12,9 @@ package connect
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/binary"
 	"fmt"
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
@@ -20,9 +22,13 @@ import (
 	jww "github.com/spf13/jwalterweatherman"
 	"gitlab.com/xx_network/comms/connect/token"
 	pb "gitlab.com/xx_network/comms/messages"
+	"gitlab.com/xx_network/crypto/signature/ec"
 	"gitlab.com/xx_network/crypto/signature/rsa"
 	"gitlab.com/xx_network/primitives/id"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"time"
 )
 
 // Auth represents an authorization state for a message or host
@@ -37,27 +43,53 @@ type Auth struct {
 	IpAddress string
 }
 
-// Perform the client handshake to establish reverse-authentication
+// Perform the client handshake to establish reverse-authentication, giving up
+// once the context is done
 // no lock is taken because this is assumed to be done exclusively under the
 // send lock taken in ProtoComms.transmit()
-func (c *ProtoComms) clientHandshake(host *Host) (err error) {
-	ctx, cancel := host.GetMessagingContext()
+func (c *ProtoComms) clientHandshake(parent context.Context,
+	host *Host) (err error) {
+	ctx, cancel := host.GetMessagingContextFrom(parent)
 	defer cancel()
-	var result *pb.AssignToken
+	result := &pb.AssignToken{}
+	var binding []byte
 	if host.connection.IsWeb() {
+		// Send the token request message, recording the TLS session the
+		// HTTP client sends it on
 		wc := host.connection.GetWebConn()
-		err = wc.Invoke(ctx, "/messages.Generic/RequestToken", &pb.Ping{}, result)
+		traceCtx, getBinding := channelBindingTrace(ctx)
+		err = wc.Invoke(traceCtx, "/messages.Generic/RequestToken",
+			&pb.Ping{}, result)
 		if err != nil {
-			return err
+			return errors.WithStack(FromGrpcStatus(err))
+		}
+
+		// Bind the token to that TLS session. The HTTP client reuses the
+		// session for later requests while it stays open.
+		binding, err = getBinding()
+		if err != nil {
+			jww.DEBUG.Printf("Not binding token for host %s to the TLS "+
+				"session: %s", host.id, err)
+			binding = nil
 		}
 	} else {
 		client := pb.NewGenericClient(host.connection.GetGrpcConn())
 
 		// Send the token request message
+		var p peer.Peer
 		result, err = client.RequestToken(ctx,
-			&pb.Ping{})
+			&pb.Ping{}, grpc.Peer(&p))
 		if err != nil {
-			return errors.New(err.Error())
+			return errors.WithStack(FromGrpcStatus(err))
+		}
+
+		// Bind the token to the TLS session it was requested on so that it
+		// cannot be used on any other connection
+		binding, err = channelBindingFromPeer(&p)
+		if err != nil {
+			jww.DEBUG.Printf("Not binding token for host %s to the TLS "+
+				"session: %s", host.id, err)
+			binding = nil
 		}
 	}
 
@@ -67,29 +99,31 @@ func (c *ProtoComms) clientHandshake(host *Host) (err error) {
 	}
 
 	// Pack the authenticated message with signature enabled
-	msg, err := c.PackAuthenticatedMessage(&pb.AssignToken{
+	msg, err := c.packAuthenticatedMessage(&pb.AssignToken{
 		Token: result.Token,
-	}, host, true)
+	}, host, true, binding)
 	if err != nil {
 		return errors.New(err.Error())
 	}
 
 	// Add special client-specific info to the
 	// newly generated authenticated message if needed
-	if c.pubKeyPem != nil && c.salt != nil {
+	if pubKeyPem := c.getPubKeyPem(); pubKeyPem != nil && c.salt != nil {
 		msg.Client.Salt = c.salt
-		msg.Client.PublicKey = string(c.pubKeyPem)
+		msg.Client.PublicKey = string(pubKeyPem)
 	}
 
 	// Set up the context
-	ctx, cancel = host.GetMessagingContext()
+	ctx, cancel = host.GetMessagingContextFrom(parent)
 	defer cancel()
 
 	if host.connection.IsWeb() {
+		// Send the authenticate token message
 		wc := host.connection.GetWebConn()
-		err = wc.Invoke(ctx, "/messages.Generic/AuthenticateToken", &pb.Ping{}, result)
+		err = wc.Invoke(ctx, "/messages.Generic/AuthenticateToken", msg,
+			&pb.Ack{})
 		if err != nil {
-			return err
+			return errors.WithStack(FromGrpcStatus(err))
 		}
 	} else {
 		client := pb.NewGenericClient(host.connection.GetGrpcConn())
@@ -97,13 +131,18 @@ func (c *ProtoComms) clientHandshake(host *Host) (err error) {
 		// Send the authenticate token message
 		_, err = client.AuthenticateToken(ctx, msg)
 		if err != nil {
-			return errors.New(err.Error())
+			return errors.WithStack(FromGrpcStatus(err))
 		}
 	}
 
 	jww.TRACE.Printf("Negotiated Remote token: %v", remoteToken)
-	// Assign the host token
-	host.transmissionToken.Set(remoteToken)
+	// Assign the host token, expiring it before the remote does so that the
+	// handshake is run again before a send fails
+	var expiry time.Time
+	if host.params.TransmissionTokenTTL > 0 {
+		expiry = time.Now().Add(host.params.TransmissionTokenTTL)
+	}
+	host.transmissionToken.SetWithExpiry(remoteToken, expiry)
 
 	return
 }
@@ -111,6 +150,14 @@ func (c *ProtoComms) clientHandshake(host *Host) (err error) {
 // Convert any message type into a authenticated message
 func (c *ProtoComms) PackAuthenticatedMessage(msg proto.Message, host *Host,
 	enableSignature bool) (*pb.AuthenticatedMessage, error) {
+	return c.packAuthenticatedMessage(msg, host, enableSignature, nil)
+}
+
+// packAuthenticatedMessage converts the message into an authenticated message
+// carrying the TLS session binding, which is covered by the signature. A nil
+// binding leaves the message unbound.
+func (c *ProtoComms) packAuthenticatedMessage(msg proto.Message, host *Host,
+	enableSignature bool, binding []byte) (*pb.AuthenticatedMessage, error) {
 
 	// Marshall the provided message into an Any type
 	anyMsg, err := ptypes.MarshalAny(msg)
@@ -121,17 +168,31 @@ func (c *ProtoComms) PackAuthenticatedMessage(msg proto.Message, host *Host,
 	// Build the authenticated message
 	authMsg := &pb.AuthenticatedMessage{
 		ID:      c.networkId.Marshal(),
-		Token:   host.transmissionToken.GetBytes(),
 		Message: anyMsg,
 		Client: &pb.ClientID{
 			Salt:      make([]byte, 0),
 			PublicKey: "",
 		},
+		ChannelBinding: binding,
+	}
+
+	// Requests MACed with the token do not carry it
+	if !host.usesRequestMAC() {
+		authMsg.Token = host.transmissionToken.GetBytes()
 	}
 
 	// If signature is enabled, sign the message and add to payload
 	if enableSignature && !c.disableAuth {
-		authMsg.Signature, err = c.signMessage(msg, host.GetId())
+		authMsg.Scheme = host.params.SignatureScheme
+		if host.params.EnableReplayProtection {
+			authMsg.Timestamp = time.Now().UnixNano()
+			authMsg.Nonce = make([]byte, replayNonceLen)
+			if _, err = rand.Read(authMsg.Nonce); err != nil {
+				return nil, errors.Errorf("Failed to generate nonce: %+v",
+					err)
+			}
+		}
+		authMsg.Signature, err = c.signMessage(msg, host.GetId(), authMsg)
 		if err != nil {
 			return nil, err
 		}
@@ -145,8 +206,10 @@ func (c *ProtoComms) PackAuthenticatedContext(host *Host,
 	ctx context.Context) context.Context {
 
 	ctx = metadata.AppendToOutgoingContext(ctx, "ID", c.networkId.String())
-	ctx = metadata.AppendToOutgoingContext(ctx, "TOKEN",
-		base64.StdEncoding.EncodeToString(host.transmissionToken.GetBytes()))
+	if !host.usesRequestMAC() {
+		ctx = metadata.AppendToOutgoingContext(ctx, "TOKEN",
+			base64.StdEncoding.EncodeToString(host.transmissionToken.GetBytes()))
+	}
 	return ctx
 }
 
@@ -160,14 +223,24 @@ func UnpackAuthenticatedContext(ctx context.Context) (*pb.AuthenticatedMessage,
 		return nil, errors.New("unable to retrieve meta data / header")
 	}
 
-	idStr := md.Get("ID")[0]
-	auth.ID, err = base64.StdEncoding.DecodeString(idStr)
+	idStr := md.Get("ID")
+	if len(idStr) == 0 {
+		return nil, errors.New("authentication ID missing from header")
+	}
+	auth.ID, err = base64.StdEncoding.DecodeString(idStr[0])
 	if err != nil {
 		return nil, errors.WithMessage(err, "could not decode authentication ID")
 	}
 
-	tokenStr := md.Get("TOKEN")[0]
-	auth.Token, err = base64.StdEncoding.DecodeString(tokenStr)
+	tokenStr := md.Get("TOKEN")
+	if len(tokenStr) == 0 {
+		// Requests authenticated by a MAC do not carry the token
+		if len(md.Get(requestMACHeader)) != 0 {
+			return auth, nil
+		}
+		return nil, errors.New("authentication token missing from header")
+	}
+	auth.Token, err = base64.StdEncoding.DecodeString(tokenStr[0])
 	if err != nil {
 		return nil, errors.WithMessage(err, "could not decode authentication Live")
 	}
@@ -181,7 +254,20 @@ func (c *ProtoComms) GenerateToken() ([]byte, error) {
 }
 
 // Validates a signed token using internal state
+//
+// Deprecated: Use ValidateTokenWithContext with the context of the request.
+// ValidateToken cannot determine the TLS session of the request, so it
+// rejects tokens which are bound to one.
 func (c *ProtoComms) ValidateToken(msg *pb.AuthenticatedMessage) (err error) {
+	return c.ValidateTokenWithContext(context.Background(), msg)
+}
+
+// ValidateTokenWithContext validates a signed token using internal state and
+// binds it to the TLS session of the request in the context, so that the
+// token is rejected when presented on any other session. Handlers of
+// AuthenticateToken should call it with their request context.
+func (c *ProtoComms) ValidateTokenWithContext(ctx context.Context,
+	msg *pb.AuthenticatedMessage) (err error) {
 	// Convert EntityID to ID
 	senderId, err := id.Unmarshal(msg.ID)
 	if err != nil {
@@ -208,25 +294,102 @@ func (c *ProtoComms) ValidateToken(msg *pb.AuthenticatedMessage) (err error) {
 
 	// Verify the token signature unless disableAuth has been set for testing
 	if !c.disableAuth {
-		if err := c.verifyMessage(tokenMsg, msg.Signature, host); err != nil {
+		err = c.verifyMessage(tokenMsg, msg, host)
+		if err != nil {
 			return errors.Errorf("Invalid token signature: %+v", err)
 		}
 	}
 
+	// Reject replays of the signed token
+	if err = c.checkReplay(senderId, msg); err != nil {
+		return err
+	}
+
+	// Check that the token was signed for the TLS session it is presented
+	// on. Bound tokens are rejected if the session cannot be determined, as
+	// the binding could not be enforced.
+	var binding []byte
+	if sessionBinding, err := channelBindingFromContext(ctx); err == nil {
+		if msg.ChannelBinding == nil && c.serverOpts.RequireChannelBinding {
+			return errors.Errorf("Token from %s is not bound to the TLS "+
+				"session", host)
+		} else if msg.ChannelBinding != nil &&
+			subtle.ConstantTimeCompare(msg.ChannelBinding, sessionBinding) != 1 {
+			return errors.Errorf("Token from %s is bound to a different TLS "+
+				"session", host)
+		}
+		binding = msg.ChannelBinding
+	} else if c.serverOpts.RequireChannelBinding {
+		return errors.Errorf("Cannot bind token from %s to the TLS "+
+			"session: %s", host, err)
+	} else if msg.ChannelBinding != nil {
+		return errors.Errorf("Cannot check the TLS session binding of the "+
+			"token from %s: %s", host, err)
+	}
+
 	ok = c.tokens.Validate(remoteToken)
 	if !ok {
 		jww.ERROR.Printf("Failed to validate token %v from %s", remoteToken, host)
 		return errors.Errorf("Failed to validate token: %v", remoteToken)
 	}
 	// Token has been validated and can be safely stored
-	host.receptionToken.Set(remoteToken)
+	var expiry time.Time
+	if c.serverOpts.ReceptionTokenTTL > 0 {
+		expiry = time.Now().Add(c.serverOpts.ReceptionTokenTTL)
+	}
+	host.receptionToken.SetBound(remoteToken, expiry, binding)
+	host.requestCounters.reset()
 	jww.DEBUG.Printf("Live validated: %v", tokenMsg.Token)
 	return
 }
 
+// VerifyAuthenticatedMessage verifies the signature of the message from its
+// sender and unmarshals the signed payload into the given message. Messages
+// carrying a timestamp and nonce are rejected if they fall outside the replay
+// window or were already verified, so signed operations which must only be
+// applied once should be checked with it.
+func (c *ProtoComms) VerifyAuthenticatedMessage(msg *pb.AuthenticatedMessage,
+	payload proto.Message) error {
+	senderId, err := id.Unmarshal(msg.ID)
+	if err != nil {
+		return err
+	}
+
+	host, ok := c.GetHost(senderId)
+	if !ok {
+		return errors.Errorf("No host set up with %s, refusing contact", senderId)
+	}
+
+	if err = ptypes.UnmarshalAny(msg.Message, payload); err != nil {
+		return errors.Errorf("Unable to unmarshal message: %+v", err)
+	}
+
+	if !c.disableAuth {
+		if err = c.verifyMessage(payload, msg, host); err != nil {
+			return errors.WithMessagef(AuthError(senderId),
+				"Invalid signature: %+v", err)
+		}
+	}
+
+	return c.checkReplay(senderId, msg)
+}
+
 // AuthenticatedReceiver handles reception of an AuthenticatedMessage,
 // checking if the host is authenticated & returning an Auth state
 func (c *ProtoComms) AuthenticatedReceiver(msg *pb.AuthenticatedMessage, ctx context.Context) (*Auth, error) {
+	// Authentication unpacked from the context of a stream carries no
+	// message, and the MAC of a stream covers no body
+	var req interface{} = msg
+	if msg.Message == nil {
+		req = nil
+	}
+	return c.authenticatedReceiver(msg, ctx, req)
+}
+
+// authenticatedReceiver authenticates a request whose authentication is msg
+// and whose body, covered by a request MAC, is req.
+func (c *ProtoComms) authenticatedReceiver(msg *pb.AuthenticatedMessage,
+	ctx context.Context, req interface{}) (*Auth, error) {
 
 	// Retrieve the IP address
 	ipAddr, _, err := GetAddressFromContext(ctx)
@@ -257,38 +420,101 @@ func (c *ProtoComms) AuthenticatedReceiver(msg *pb.AuthenticatedMessage, ctx con
 		}, nil
 	}
 
-	remoteToken, err := token.Unmarshal(msg.Token)
+	// Requests authenticated by the server interceptors are not checked
+	// again, as the counter of a request MAC may only be used once
+	if auth, ok := AuthFromContext(ctx); ok && auth.Sender == host {
+		return auth, nil
+	}
+
+	mac, counter, hasMAC, err := unpackRequestMAC(ctx)
 	if err != nil {
 		return &Auth{
 			IsAuthenticated: false,
 			Sender:          host,
 			IpAddress:       ipAddr,
-			Reason:          fmt.Sprintf("Token {%v} cannot be unmarshaled", msg.Token),
+			Reason: fmt.Sprintf("failed to authenticate request from "+
+				"%s: %s", host.id, err),
 		}, nil
 	}
 
-	// get the hosts reception token
-	receptionToken, ok := host.receptionToken.Get()
-	if !ok {
+	if hasMAC {
+		// The MAC is keyed from the reception token, so the token itself is
+		// not sent
+		receptionToken, ok := host.receptionToken.Get()
+		if !ok {
+			return &Auth{
+				IsAuthenticated: false,
+				Sender:          host,
+				IpAddress:       ipAddr,
+				Reason: fmt.Sprintf("failed to authenticate request MAC, "+
+					"no reception token for %s", host.id),
+			}, nil
+		}
+		err = host.verifyRequestMAC(ctx, receptionToken, req, mac, counter)
+		if err != nil {
+			return &Auth{
+				IsAuthenticated: false,
+				Sender:          host,
+				IpAddress:       ipAddr,
+				Reason: fmt.Sprintf("failed to authenticate request from "+
+					"%s: %s", host.id, err),
+			}, nil
+		}
+	} else if c.serverOpts.RequireRequestMAC {
 		return &Auth{
 			IsAuthenticated: false,
 			Sender:          host,
 			IpAddress:       ipAddr,
-			Reason: fmt.Sprintf("failed to authenticate token %v, "+
-				"no reception token for %s", remoteToken, host.id),
+			Reason: fmt.Sprintf("failed to authenticate request from %s, "+
+				"request MAC is required", host.id),
 		}, nil
+	} else {
+		remoteToken, err := token.Unmarshal(msg.Token)
+		if err != nil {
+			return &Auth{
+				IsAuthenticated: false,
+				Sender:          host,
+				IpAddress:       ipAddr,
+				Reason:          fmt.Sprintf("Token {%v} cannot be unmarshaled", msg.Token),
+			}, nil
+		}
+
+		// get the hosts reception token
+		receptionToken, ok := host.receptionToken.Get()
+		if !ok {
+			return &Auth{
+				IsAuthenticated: false,
+				Sender:          host,
+				IpAddress:       ipAddr,
+				Reason: fmt.Sprintf("failed to authenticate token %v, "+
+					"no reception token for %s", remoteToken, host.id),
+			}, nil
+		}
+
+		// check if the tokens are the same
+		if !receptionToken.Equals(remoteToken) {
+			return &Auth{
+				IsAuthenticated: false,
+				Sender:          host,
+				IpAddress:       ipAddr,
+				Reason: fmt.Sprintf("failed to authenticate token %v, "+
+					"does not match reception token %v for %s", remoteToken,
+					receptionToken, host.id),
+			}, nil
+		}
 	}
 
-	// check if the tokens are the same
-	if !receptionToken.Equals(remoteToken) {
-		return &Auth{
-			IsAuthenticated: false,
-			Sender:          host,
-			IpAddress:       ipAddr,
-			Reason: fmt.Sprintf("failed to authenticate token %v, "+
-				"does not match reception token %v for %s", remoteToken,
-				receptionToken, host.id),
-		}, nil
+	// check that the token is presented on the TLS session it is bound to
+	if binding := host.receptionToken.GetBinding(); binding != nil {
+		if err = checkChannelBinding(ctx, binding); err != nil {
+			return &Auth{
+				IsAuthenticated: false,
+				Sender:          host,
+				IpAddress:       ipAddr,
+				Reason: fmt.Sprintf("failed to authenticate request from "+
+					"%s: %s", host.id, err),
+			}, nil
+		}
 	}
 
 	// Assemble the Auth object
@@ -312,41 +538,54 @@ func (c *ProtoComms) DisableAuth() {
 }
 
 // Takes a message and returns its signature
-// The message is signed with the ProtoComms RSA PrivateKey
-func (c *ProtoComms) signMessage(msg proto.Message, recipientID *id.ID) ([]byte, error) {
-	// Hash the message data
-	msgBytes, err := proto.Marshal(msg)
+// The message is signed with the ProtoComms private key of the scheme of the
+// AuthenticatedMessage, covering its TLS session binding, timestamp and nonce
+func (c *ProtoComms) signMessage(msg proto.Message, recipientID *id.ID,
+	authMsg *pb.AuthenticatedMessage) ([]byte, error) {
+	hashed, err := hashMessage(msg, recipientID, authMsg)
 	if err != nil {
-		return nil, errors.New(err.Error())
+		return nil, err
 	}
-	options := rsa.NewDefaultOptions()
-	hash := options.Hash.New()
-	hash.Write(msgBytes)
-	// Hash in the ID of the intended recipient. This prevents potential
-	// replay attacks
-	hash.Write(recipientID.Bytes())
-	hashed := hash.Sum(nil)
 
 	jww.TRACE.Printf("SignMessage: Signing for host ID [%v]", recipientID)
 	jww.TRACE.Printf("SignMessage: hash data: %v", hashed)
 	jww.TRACE.Printf("SignMessage: Hashed with ID: %v", recipientID)
 
-	// Obtain the private key
-	key := c.GetPrivateKey()
-	if key == nil {
-		return nil, errors.Errorf("Cannot sign message: No private key")
-	}
-	// Sign the message and return the signature
-	signature, err := rsa.Sign(rand.Reader, key, options.Hash, hashed, nil)
-	if err != nil {
-		return nil, errors.New(err.Error())
+	switch scheme := authMsg.GetScheme(); scheme {
+	case pb.SignatureScheme_RSA:
+		// Obtain the private key
+		key := c.GetPrivateKey()
+		if key == nil {
+			return nil, errors.Errorf("Cannot sign message: No private key")
+		}
+		// Sign the message and return the signature
+		signature, err := rsa.Sign(rand.Reader, key,
+			rsa.NewDefaultOptions().Hash, hashed, nil)
+		if err != nil {
+			return nil, errors.New(err.Error())
+		}
+		return signature, nil
+	case pb.SignatureScheme_ED25519:
+		key := c.GetEccPrivateKey()
+		if key == nil {
+			return nil, errors.Errorf("Cannot sign message: No ECC private key")
+		}
+		return ec.Sign(key, hashed), nil
+	default:
+		return nil, errors.Errorf("Cannot sign message: unknown signature "+
+			"scheme %s", scheme)
 	}
-	return signature, nil
 }
 
-// Takes a message and a Host, verifies the signature
-// using Host public key, returning an error if invalid
-func (c *ProtoComms) verifyMessage(msg proto.Message, signature []byte, host *Host) error {
+// Takes a message and a Host, verifies the signature of the
+// AuthenticatedMessage using Host public key, returning an error if invalid
+func (c *ProtoComms) verifyMessage(msg proto.Message,
+	authMsg *pb.AuthenticatedMessage, host *Host) error {
+	scheme, signature := authMsg.GetScheme(), authMsg.GetSignature()
+
+	if !c.IsSignatureSchemeAllowed(scheme) {
+		return errors.Errorf("Signature scheme %s is not allowed", scheme)
+	}
 
 	// Deal with edge case in which gateways and servers
 	// haven't added each other as hosts yet, and dealing with
@@ -367,27 +606,61 @@ func (c *ProtoComms) verifyMessage(msg proto.Message, signature []byte, host *Ho
 	}
 
 	// Get hashed data of the message
-	msgBytes, err := proto.Marshal(msg)
+	hashed, err := hashMessage(msg, idToHash, authMsg)
 	if err != nil {
-		return errors.New(err.Error())
+		return err
 	}
-	options := rsa.NewDefaultOptions()
-	hash := options.Hash.New()
-	hash.Write(msgBytes)
-	// Hash in the ID of the intended recipient. This prevents potential
-	// replay attacks
-	hash.Write(idToHash.Bytes())
-	hashed := hash.Sum(nil)
 
 	jww.TRACE.Printf("VerifyMessage: Verifying host ID [%v]", host.GetId())
 	jww.TRACE.Printf("VerifyMessage: hash data: %v", hashed)
 	jww.TRACE.Printf("VerifyMessage: Hashed with ID: %v", idToHash)
 
 	// Verify signature of message using host public key
-	err = rsa.Verify(host.rsaPublicKey, options.Hash, hashed, signature, nil)
-	if err != nil {
-		return errors.New(err.Error())
+	switch scheme {
+	case pb.SignatureScheme_RSA:
+		err = rsa.Verify(host.GetPubKey(), rsa.NewDefaultOptions().Hash,
+			hashed, signature, nil)
+		if err != nil {
+			return errors.New(err.Error())
+		}
+	case pb.SignatureScheme_ED25519:
+		key := host.GetEccPublicKey()
+		if key == nil {
+			return errors.Errorf("Host %s has no ECC public key", host.id)
+		}
+		if !ec.Verify(key, hashed, signature) {
+			return errors.New("failed to verify EDDSA signature")
+		}
+	default:
+		return errors.Errorf("Unknown signature scheme %s", scheme)
 	}
 
 	return nil
 }
+
+// hashMessage hashes the message together with the ID of the intended
+// recipient and the TLS session binding, timestamp and nonce of the
+// AuthenticatedMessage, if any, producing the data which is signed.
+func hashMessage(msg proto.Message, recipientID *id.ID,
+	authMsg *pb.AuthenticatedMessage) ([]byte, error) {
+	msgBytes, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, errors.New(err.Error())
+	}
+	hash := rsa.NewDefaultOptions().Hash.New()
+	hash.Write(msgBytes)
+	// Hash in the ID of the intended recipient. This prevents potential
+	// replay attacks
+	hash.Write(recipientID.Bytes())
+	// Hash in the keying material of the TLS session so that the signature
+	// cannot be used on another connection
+	hash.Write(authMsg.GetChannelBinding())
+	// Hash in the timestamp and nonce so that the message cannot be replayed
+	if authMsg.GetTimestamp() != 0 || len(authMsg.GetNonce()) != 0 {
+		timestamp := make([]byte, 8)
+		binary.BigEndian.PutUint64(timestamp, uint64(authMsg.GetTimestamp()))
+		hash.Write(timestamp)
+		hash.Write(authMsg.GetNonce())
+	}
+	return hash.Sum(nil), nil
+}
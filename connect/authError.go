@@ -15,15 +15,39 @@ import (
 
 const baseAuthErr = "Failed to authenticate"
 
+// ErrAuthFailed is the class of errors returned when a Host fails to
+// authenticate, whether locally or by the remote end of a connection.
+var ErrAuthFailed = errors.New(baseAuthErr)
+
+// AuthFailedError is returned when a Host fails to authenticate. It matches
+// ErrAuthFailed with errors.Is.
+type AuthFailedError struct {
+	// ID of the Host which failed to authenticate, nil if unknown
+	HostId *id.ID
+}
+
+// Error returns the message of the AuthFailedError.
+func (e *AuthFailedError) Error() string {
+	if e.HostId == nil {
+		return baseAuthErr + " due to nil id"
+	}
+	return baseAuthErr + " id: " + e.HostId.String()
+}
+
+// Is returns true for ErrAuthFailed.
+func (e *AuthFailedError) Is(target error) bool {
+	return target == ErrAuthFailed
+}
+
 // AuthError returns a valid authorization error on the given id
 func AuthError(id *id.ID) error {
-	if id == nil {
-		return errors.New(baseAuthErr + " due to nil id")
-	}
-	return errors.New(baseAuthErr + " id: " + id.String())
+	return &AuthFailedError{HostId: id}
 }
 
-// IsAuthError returns true if the passed error is a valid auth error
+// IsAuthError returns true if the passed error is a valid auth error. Errors
+// which lost their type, such as those flattened to text, are matched by
+// their message.
 func IsAuthError(err error) bool {
-	return strings.Contains(err.Error(), baseAuthErr)
+	return errors.Is(err, ErrAuthFailed) ||
+		(err != nil && strings.Contains(err.Error(), baseAuthErr))
 }
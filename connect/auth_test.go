@@ -11,11 +11,13 @@ import (
 	"bytes"
 	"context"
 	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
 	token "gitlab.com/xx_network/comms/connect/token"
 	pb "gitlab.com/xx_network/comms/messages"
 	"gitlab.com/xx_network/comms/testkeys"
 	"gitlab.com/xx_network/crypto/signature/rsa"
 	"gitlab.com/xx_network/primitives/id"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 	"net"
 	"testing"
@@ -47,7 +49,7 @@ func TestSignVerify(t *testing.T) {
 		t.Errorf("Error converting to Any type: %+v", err)
 	}
 
-	signature, err := c.signMessage(wrappedMessage, testId)
+	signature, err := c.signMessage(wrappedMessage, testId, &pb.AuthenticatedMessage{})
 	if err != nil {
 		t.Errorf("Error signing message: %+v", err)
 	}
@@ -57,7 +59,8 @@ func TestSignVerify(t *testing.T) {
 		rsaPublicKey: pub,
 	}
 
-	err = c.verifyMessage(wrappedMessage, signature, host)
+	err = c.verifyMessage(wrappedMessage,
+		&pb.AuthenticatedMessage{Signature: signature}, host)
 	if err != nil {
 		t.Errorf("Error verifying signature")
 	}
@@ -367,3 +370,164 @@ func newContextTesting(t *testing.T) (context.Context, context.CancelFunc) {
 
 	return peer.NewContext(protoCtx, p), cancel
 }
+
+// Tests that UnpackAuthenticatedContext returns an error rather than
+// panicking when the authentication headers are missing.
+func TestUnpackAuthenticatedContext_MissingHeader(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.MD{})
+	_, err := UnpackAuthenticatedContext(ctx)
+	if err == nil {
+		t.Errorf("Expected an error for a missing ID header")
+	}
+
+	ctx = metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs("ID", id.NewIdFromString("test", id.Node, t).String()))
+	_, err = UnpackAuthenticatedContext(ctx)
+	if err == nil {
+		t.Errorf("Expected an error for a missing TOKEN header")
+	}
+}
+
+// Tests that ValidateToken expires the reception token after the
+// ReceptionTokenTTL and that an expired transmission token requires a new
+// handshake.
+func TestProtoComms_ValidateToken_TTL(t *testing.T) {
+	testId := id.NewIdFromString("test", id.Node, t)
+	comm := ProtoComms{
+		networkId:  testId,
+		tokens:     token.NewMap(),
+		Manager:    newManager(),
+		serverOpts: GetDefaultServerOptions(),
+	}
+	comm.serverOpts.ReceptionTokenTTL = time.Hour
+	err := comm.setPrivateKey(testkeys.LoadFromPath(testkeys.GetNodeKeyPath()))
+	if err != nil {
+		t.Fatalf("Expected to set private key: %+v", err)
+	}
+
+	tokenBytes, _ := comm.GenerateToken()
+	pub := testkeys.LoadFromPath(testkeys.GetNodeCertPath())
+	host, err := comm.AddHost(testId, "test", pub, GetDefaultHostParams())
+	if err != nil {
+		t.Fatalf("Unable to create host: %+v", err)
+	}
+
+	msg, err := comm.PackAuthenticatedMessage(
+		&pb.AssignToken{Token: tokenBytes}, host, true)
+	if err != nil {
+		t.Fatalf("Expected no error packing authenticated message: %+v", err)
+	}
+	if err = comm.ValidateToken(msg); err != nil {
+		t.Fatalf("Expected to validate token: %+v", err)
+	}
+
+	expiry, ok := host.receptionToken.GetExpiry()
+	if !ok || time.Until(expiry) > time.Hour ||
+		time.Until(expiry) < 59*time.Minute {
+		t.Errorf("Unexpected reception token expiry: %s", expiry)
+	}
+
+	// An expired transmission token requires a new handshake
+	tkn, _ := token.Unmarshal(tokenBytes)
+	host.transmissionToken.SetWithExpiry(tkn, time.Now().Add(time.Hour))
+	if host.authenticationRequired() {
+		t.Errorf("Authentication required with a valid token")
+	}
+	host.transmissionToken.SetWithExpiry(tkn, time.Now().Add(-time.Second))
+	if !host.authenticationRequired() {
+		t.Errorf("Authentication not required with an expired token")
+	}
+}
+
+// startWebHandshakeServer starts a server on addr, serving gRPC and grpc-web,
+// which runs the handshake and knows the client by the given certificate.
+// Returns the server and a client with a web Host for it.
+func startWebHandshakeServer(t *testing.T, addr string,
+	clientCert []byte) (*ProtoComms, *Host) {
+	keyBytes := testkeys.LoadFromPath(testkeys.GetNodeKeyPath())
+	serverId := id.NewIdFromString("webServer", id.Node, t)
+	pc, err := StartCommServer(serverId, addr, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	t.Cleanup(func() { _ = pc.Shutdown(context.Background()) })
+	pb.RegisterGenericServer(pc.GetServer(), &bindingTestServer{comms: pc})
+	pc.ServeWithWeb()
+
+	clientId := id.NewIdFromString("webClient", id.Node, t)
+	client, err := CreateCommClient(clientId, nil, keyBytes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pc.AddHost(clientId, "", clientCert,
+		GetDefaultHostParams()); err != nil {
+		t.Fatal(err)
+	}
+
+	params := GetDefaultHostParams()
+	params.ConnectionType = Web
+	params.MaxRetries = 1
+	params.MaxSendRetries = 1
+	serverHost, err := client.AddHost(serverId, addr, nil, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(serverHost.Disconnect)
+	return client, serverHost
+}
+
+// Tests that the handshake over grpc-web negotiates a token that
+// authenticates later messages, as it does over gRPC.
+func TestProtoComms_clientHandshake_Web(t *testing.T) {
+	client, serverHost := startWebHandshakeServer(t, "127.0.0.1:11439",
+		testkeys.LoadFromPath(testkeys.GetNodeCertPath()))
+
+	ret, err := client.Send(serverHost, func(conn Connection) (*any.Any, error) {
+		ctx, cancel := serverHost.GetMessagingContext()
+		defer cancel()
+		msg, err := client.PackAuthenticatedMessage(&pb.Ping{}, serverHost,
+			false)
+		if err != nil {
+			return nil, err
+		}
+		ack := &pb.Ack{}
+		err = conn.GetWebConn().Invoke(ctx,
+			"/messages.Generic/AuthenticateToken", msg, ack)
+		if err != nil {
+			return nil, err
+		}
+		return ptypes.MarshalAny(ack)
+	})
+	if err != nil {
+		t.Fatalf("Failed to send over grpc-web: %+v", err)
+	}
+	if !serverHost.transmissionToken.Has() {
+		t.Errorf("No token was negotiated over grpc-web")
+	}
+
+	ack := &pb.Ack{}
+	if err = ptypes.UnmarshalAny(ret, ack); err != nil {
+		t.Fatal(err)
+	}
+	if ack.Error != "authenticated" {
+		t.Errorf("Message was not authenticated with the negotiated "+
+			"token: %s", ack.Error)
+	}
+}
+
+// Tests that a handshake over grpc-web whose signature the server rejects
+// fails with an error and leaves no token.
+func TestProtoComms_clientHandshake_WebRejected(t *testing.T) {
+	client, serverHost := startWebHandshakeServer(t, "127.0.0.1:11440",
+		testkeys.LoadFromPath(testkeys.GetGatewayCertPath()))
+
+	if err := serverHost.connect(); err != nil {
+		t.Fatalf("Failed to connect: %+v", err)
+	}
+	if err := client.clientHandshake(context.Background(), serverHost); err == nil {
+		t.Errorf("Handshake with an invalid signature succeeded")
+	}
+	if serverHost.transmissionToken.Has() {
+		t.Errorf("Token was set after a failed handshake")
+	}
+}
@@ -0,0 +1,112 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// Contains functionality for binding authentication tokens to the TLS session
+// they were negotiated on
+
+package connect
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"net"
+	"net/http/httptrace"
+	"sync"
+)
+
+const (
+	// Label of the keying material exported from the TLS session (RFC 5705)
+	channelBindingLabel = "EXPORTER-xx-network-token-binding"
+
+	// Length of the exported keying material, in bytes
+	channelBindingLen = 32
+)
+
+// channelBindingFromContext returns the keying material exported from the TLS
+// session of an incoming communication. Works for both gRPC and grpc-web
+// requests, as the grpc-web handler passes the TLS state of the HTTP request
+// to the peer.
+func channelBindingFromContext(ctx context.Context) ([]byte, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, errors.New("could not retrieve peer information " +
+			"from context")
+	}
+	return channelBindingFromPeer(p)
+}
+
+// channelBindingFromPeer returns the keying material exported from the TLS
+// session with the peer. Returns an error if the peer is not connected over
+// TLS or the session cannot export keying material.
+func channelBindingFromPeer(p *peer.Peer) ([]byte, error) {
+	var state tls.ConnectionState
+	switch info := p.AuthInfo.(type) {
+	case credentials.TLSInfo:
+		state = info.State
+	case *credentials.TLSInfo:
+		state = info.State
+	default:
+		return nil, errors.New("connection is not secured by TLS")
+	}
+	return exportChannelBinding(state)
+}
+
+// channelBindingTrace returns a context which records the connection the
+// HTTP client of a grpc-web call sends its request on, and a function
+// returning the keying material exported from the TLS session of that
+// connection once the call has been made.
+func channelBindingTrace(ctx context.Context) (context.Context,
+	func() ([]byte, error)) {
+	var conn net.Conn
+	var mux sync.Mutex
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			mux.Lock()
+			conn = info.Conn
+			mux.Unlock()
+		},
+	})
+
+	return ctx, func() ([]byte, error) {
+		mux.Lock()
+		defer mux.Unlock()
+		tlsConn, ok := conn.(*tls.Conn)
+		if !ok {
+			return nil, errors.New("connection is not secured by TLS")
+		}
+		return exportChannelBinding(tlsConn.ConnectionState())
+	}
+}
+
+// exportChannelBinding returns the keying material exported from the TLS
+// session.
+func exportChannelBinding(state tls.ConnectionState) ([]byte, error) {
+	binding, err := state.ExportKeyingMaterial(
+		channelBindingLabel, nil, channelBindingLen)
+	if err != nil {
+		return nil, errors.WithMessage(err,
+			"failed to export TLS keying material")
+	}
+	return binding, nil
+}
+
+// checkChannelBinding returns an error unless the token binding matches the
+// TLS session of the incoming communication.
+func checkChannelBinding(ctx context.Context, binding []byte) error {
+	sessionBinding, err := channelBindingFromContext(ctx)
+	if err != nil {
+		return errors.WithMessage(err, "token is bound to a TLS session")
+	}
+	if subtle.ConstantTimeCompare(binding, sessionBinding) != 1 {
+		return errors.New("token is bound to a different TLS session")
+	}
+	return nil
+}
@@ -0,0 +1,378 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+	"gitlab.com/xx_network/comms/connect/token"
+	pb "gitlab.com/xx_network/comms/messages"
+	"gitlab.com/xx_network/comms/testkeys"
+	"gitlab.com/xx_network/primitives/id"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"net"
+	"testing"
+)
+
+// newTlsContext performs a TLS handshake over an in-memory connection and
+// returns an incoming context whose peer is the server side of the session.
+func newTlsContext(t *testing.T) context.Context {
+	cert, err := tls.X509KeyPair(
+		testkeys.LoadFromPath(testkeys.GetNodeCertPath()),
+		testkeys.LoadFromPath(testkeys.GetNodeKeyPath()))
+	if err != nil {
+		t.Fatalf("Failed to load key pair: %+v", err)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	server := tls.Server(serverConn, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+	errCh := make(chan error, 1)
+	go func() { errCh <- client.Handshake() }()
+	if err = server.Handshake(); err != nil {
+		t.Fatalf("Server handshake failed: %+v", err)
+	}
+	if err = <-errCh; err != nil {
+		t.Fatalf("Client handshake failed: %+v", err)
+	}
+	t.Cleanup(func() {
+		_ = clientConn.Close()
+		_ = serverConn.Close()
+	})
+
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr:     &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 11437},
+		AuthInfo: credentials.TLSInfo{State: server.ConnectionState()},
+	})
+}
+
+// newBindingTestComms returns a ProtoComms and a Host for itself, with the
+// given server options.
+func newBindingTestComms(t *testing.T, opts ServerOptions) (*ProtoComms,
+	*Host) {
+	testId := id.NewIdFromString("binding", id.Node, t)
+	comm := &ProtoComms{
+		networkId:  testId,
+		tokens:     token.NewMap(),
+		Manager:    newManager(),
+		serverOpts: opts,
+	}
+	err := comm.setPrivateKey(testkeys.LoadFromPath(testkeys.GetNodeKeyPath()))
+	if err != nil {
+		t.Fatalf("Failed to set private key: %+v", err)
+	}
+	host, err := comm.AddHost(testId, "test",
+		testkeys.LoadFromPath(testkeys.GetNodeCertPath()),
+		GetDefaultHostParams())
+	if err != nil {
+		t.Fatalf("Failed to add host: %+v", err)
+	}
+	return comm, host
+}
+
+// packBoundToken generates a token and packs it signed for the Host and
+// bound to the TLS session of the context.
+func packBoundToken(t *testing.T, comm *ProtoComms, host *Host,
+	ctx context.Context) *pb.AuthenticatedMessage {
+	binding, err := channelBindingFromContext(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get channel binding: %+v", err)
+	}
+	tokenBytes, _ := comm.GenerateToken()
+	msg, err := comm.packAuthenticatedMessage(
+		&pb.AssignToken{Token: tokenBytes}, host, true, binding)
+	if err != nil {
+		t.Fatalf("Failed to pack message: %+v", err)
+	}
+	return msg
+}
+
+// Tests that a token validated on a TLS session is only accepted by
+// AuthenticatedReceiver on that session.
+func TestProtoComms_ValidateTokenWithContext(t *testing.T) {
+	comm, host := newBindingTestComms(t, GetDefaultServerOptions())
+	ctx, otherCtx := newTlsContext(t), newTlsContext(t)
+
+	msg := packBoundToken(t, comm, host, ctx)
+	if err := comm.ValidateTokenWithContext(ctx, msg); err != nil {
+		t.Fatalf("Failed to validate bound token: %+v", err)
+	}
+	if !bytes.Equal(host.receptionToken.GetBinding(), msg.ChannelBinding) {
+		t.Errorf("Reception token is not bound to the session")
+	}
+
+	tokenMsg := &pb.AssignToken{}
+	if err := ptypes.UnmarshalAny(msg.Message, tokenMsg); err != nil {
+		t.Fatal(err)
+	}
+	authMsg := &pb.AuthenticatedMessage{ID: msg.ID, Token: tokenMsg.Token}
+
+	auth, err := comm.AuthenticatedReceiver(authMsg, ctx)
+	if err != nil || !auth.IsAuthenticated {
+		t.Errorf("Token rejected on its own session: %v %+v", auth, err)
+	}
+
+	auth, err = comm.AuthenticatedReceiver(authMsg, otherCtx)
+	if err != nil || auth.IsAuthenticated {
+		t.Errorf("Token accepted on another session: %v %+v", auth, err)
+	}
+
+	noTlsCtx := peer.NewContext(context.Background(),
+		&peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}})
+	auth, err = comm.AuthenticatedReceiver(authMsg, noTlsCtx)
+	if err != nil || auth.IsAuthenticated {
+		t.Errorf("Token accepted without TLS: %v %+v", auth, err)
+	}
+}
+
+// Tests that tokens bound to another session or with a tampered binding are
+// not validated.
+func TestProtoComms_ValidateTokenWithContext_WrongSession(t *testing.T) {
+	comm, host := newBindingTestComms(t, GetDefaultServerOptions())
+	ctx, otherCtx := newTlsContext(t), newTlsContext(t)
+
+	msg := packBoundToken(t, comm, host, otherCtx)
+	if err := comm.ValidateTokenWithContext(ctx, msg); err == nil {
+		t.Errorf("Validated a token bound to another session")
+	}
+
+	msg = packBoundToken(t, comm, host, otherCtx)
+	msg.ChannelBinding, _ = channelBindingFromContext(ctx)
+	if err := comm.ValidateTokenWithContext(ctx, msg); err == nil {
+		t.Errorf("Validated a token with a binding not covered by the " +
+			"signature")
+	}
+}
+
+// Tests that unbound tokens are accepted on any session unless
+// RequireChannelBinding is set.
+func TestProtoComms_ValidateTokenWithContext_Unbound(t *testing.T) {
+	comm, host := newBindingTestComms(t, GetDefaultServerOptions())
+	ctx := newTlsContext(t)
+
+	if err := comm.ValidateTokenWithContext(ctx, packToken(t, comm, host)); err != nil {
+		t.Fatalf("Failed to validate unbound token: %+v", err)
+	}
+	if host.receptionToken.GetBinding() != nil {
+		t.Errorf("Unbound token was bound to the session")
+	}
+
+	opts := GetDefaultServerOptions()
+	opts.RequireChannelBinding = true
+	comm, host = newBindingTestComms(t, opts)
+	if err := comm.ValidateTokenWithContext(ctx, packToken(t, comm, host)); err == nil {
+		t.Errorf("Validated an unbound token with RequireChannelBinding set")
+	}
+	if err := comm.ValidateToken(packBoundToken(t, comm, host, ctx)); err == nil {
+		t.Errorf("Validated a token without a session with " +
+			"RequireChannelBinding set")
+	}
+}
+
+// Tests that bound tokens are rejected when the TLS session of the request
+// cannot be determined, as the binding could not be enforced.
+func TestProtoComms_ValidateToken_Bound(t *testing.T) {
+	comm, host := newBindingTestComms(t, GetDefaultServerOptions())
+	msg := packBoundToken(t, comm, host, newTlsContext(t))
+
+	if err := comm.ValidateToken(msg); err == nil {
+		t.Errorf("Validated a bound token without a session")
+	}
+	if _, ok := host.receptionToken.Get(); ok {
+		t.Errorf("Stored a bound token which was not checked")
+	}
+}
+
+// bindingTestServer is a Generic server which runs the handshake and reports
+// whether other AuthenticatedMessages are authenticated.
+type bindingTestServer struct {
+	pb.UnimplementedGenericServer
+	comms *ProtoComms
+}
+
+func (s *bindingTestServer) RequestToken(context.Context, *pb.Ping) (*pb.AssignToken, error) {
+	tokenBytes, err := s.comms.GenerateToken()
+	return &pb.AssignToken{Token: tokenBytes}, err
+}
+
+func (s *bindingTestServer) AuthenticateToken(ctx context.Context, msg *pb.AuthenticatedMessage) (*pb.Ack, error) {
+	if ptypes.Is(msg.Message, &pb.AssignToken{}) {
+		return &pb.Ack{}, s.comms.ValidateTokenWithContext(ctx, msg)
+	}
+	auth, err := s.comms.AuthenticatedReceiver(msg, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Ack{Error: auth.Reason}, nil
+}
+
+// Tests that the handshake over gRPC binds the token to the TLS session of
+// the connection and that the token is rejected on another connection.
+func TestProtoComms_clientHandshake_ChannelBinding(t *testing.T) {
+	TestingOnlyDisableTLS = false
+	defer func() { TestingOnlyDisableTLS = true }()
+	certBytes := testkeys.LoadFromPath(testkeys.GetNodeCertPath())
+	keyBytes := testkeys.LoadFromPath(testkeys.GetNodeKeyPath())
+	addr := "127.0.0.1:11437"
+
+	serverId := id.NewIdFromString("bindingServer", id.Node, t)
+	pc, err := StartCommServer(serverId, addr, certBytes, keyBytes, nil)
+	if err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	defer pc.Shutdown(context.Background())
+	pb.RegisterGenericServer(pc.GetServer(), &bindingTestServer{comms: pc})
+	pc.Serve()
+
+	clientId := id.NewIdFromString("bindingClient", id.Node, t)
+	client, err := CreateCommClient(clientId, nil, keyBytes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pc.AddHost(clientId, "", certBytes,
+		GetDefaultHostParams()); err != nil {
+		t.Fatal(err)
+	}
+	serverHost, err := client.AddHost(serverId, addr, certBytes,
+		GetDefaultHostParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherHost, err := client.AddHost(
+		id.NewIdFromString("bindingOther", id.Node, t), addr, certBytes,
+		GetDefaultHostParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otherHost.Disconnect()
+
+	// The handshake on the connection of serverHost binds its token
+	if reason := sendBound(t, client, serverHost, serverHost); reason != "authenticated" {
+		t.Errorf("Token rejected on its own connection: %s", reason)
+	}
+
+	// Presenting the token of serverHost on the connection of otherHost,
+	// which is a different TLS session, is rejected
+	otherHost.params.AuthEnabled = false
+	if reason := sendBound(t, client, otherHost, serverHost); reason == "authenticated" {
+		t.Errorf("Token accepted on another connection")
+	}
+	serverHost.Disconnect()
+}
+
+// Tests that the handshake over grpc-web binds the token to the TLS session
+// the HTTP client sent it on and that the token is rejected when replayed on
+// another session.
+func TestProtoComms_clientHandshake_ChannelBindingWeb(t *testing.T) {
+	TestingOnlyDisableTLS = false
+	TestingOnlyInsecureTLSVerify = true
+	defer func() {
+		TestingOnlyDisableTLS = true
+		TestingOnlyInsecureTLSVerify = false
+	}()
+	certBytes := testkeys.LoadFromPath(testkeys.GetNodeCertPath())
+	keyBytes := testkeys.LoadFromPath(testkeys.GetNodeKeyPath())
+	httpsCertBytes := testkeys.LoadFromPath(testkeys.GetGatewayCertPath())
+	httpsKeyBytes := testkeys.LoadFromPath(testkeys.GetGatewayKeyPath())
+	addr := "127.0.0.1:11446"
+
+	serverId := id.NewIdFromString("bindingWebServer", id.Node, t)
+	pc, err := StartCommServer(serverId, addr, certBytes, keyBytes, nil)
+	if err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	defer pc.Shutdown(context.Background())
+	pb.RegisterGenericServer(pc.GetServer(), &bindingTestServer{comms: pc})
+	pc.ServeWithWeb()
+	keyPair, err := tls.X509KeyPair(httpsCertBytes, httpsKeyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pc.ServeHttps(keyPair); err != nil {
+		t.Fatalf("Failed to serve https: %+v", err)
+	}
+
+	clientId := id.NewIdFromString("bindingWebClient", id.User, t)
+	client, err := CreateCommClient(clientId, nil, keyBytes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientHost, err := pc.AddHost(clientId, "", certBytes,
+		GetDefaultHostParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := GetDefaultHostParams()
+	params.ConnectionType = Web
+	serverHost, err := client.AddHost(serverId, addr, httpsCertBytes, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherHost, err := client.AddHost(
+		id.NewIdFromString("bindingWebOther", id.Node, t), addr,
+		httpsCertBytes, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otherHost.Disconnect()
+
+	if reason := sendBound(t, client, serverHost, serverHost); reason != "authenticated" {
+		t.Errorf("Token rejected on its own session: %s", reason)
+	}
+	if clientHost.receptionToken.GetBinding() == nil {
+		t.Fatalf("Token of the web handshake is not bound")
+	}
+
+	// otherHost has its own HTTP client, so it sends on another TLS session
+	otherHost.params.AuthEnabled = false
+	if reason := sendBound(t, client, otherHost, serverHost); reason == "authenticated" {
+		t.Errorf("Token replayed on another TLS session was accepted")
+	}
+	serverHost.Disconnect()
+}
+
+// sendBound sends the token of tokenHost to the AuthenticateToken handler of
+// a bindingTestServer over the connection of host and returns the reason the
+// server gave for its authentication.
+func sendBound(t *testing.T, client *ProtoComms, host, tokenHost *Host) string {
+	ret, err := client.Send(host, func(conn Connection) (*any.Any, error) {
+		ctx, cancel := host.GetMessagingContext()
+		defer cancel()
+		msg, err := client.PackAuthenticatedMessage(&pb.Ping{}, tokenHost,
+			false)
+		if err != nil {
+			return nil, err
+		}
+		ack := &pb.Ack{}
+		if conn.IsWeb() {
+			err = conn.GetWebConn().Invoke(ctx,
+				"/messages.Generic/AuthenticateToken", msg, ack)
+		} else {
+			ack, err = pb.NewGenericClient(conn.GetGrpcConn()).
+				AuthenticateToken(ctx, msg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return ptypes.MarshalAny(ack)
+	})
+	if err != nil {
+		t.Fatalf("Failed to send: %+v", err)
+	}
+	ack := &pb.Ack{}
+	if err = ptypes.UnmarshalAny(ret, ack); err != nil {
+		t.Fatal(err)
+	}
+	return ack.Error
+}
@@ -0,0 +1,396 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// Contains the circuit breaker which stops sends to failing hosts
+
+package connect
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Number of buckets the failure rate window of a circuit breaker is split
+// into
+const breakerWindowBuckets = 10
+
+// CircuitState is the state of the circuit breaker of a Host.
+type CircuitState uint8
+
+const (
+	// CircuitClosed lets every send through.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen rejects every send with ErrCircuitOpen without dialing.
+	CircuitOpen
+
+	// CircuitHalfOpen lets a limited number of probe sends through. The
+	// breaker closes if they succeed and opens again if one fails.
+	CircuitHalfOpen
+)
+
+// String returns a human-readable name for the CircuitState.
+func (cs CircuitState) String() string {
+	switch cs {
+	case CircuitClosed:
+		return "Closed"
+	case CircuitOpen:
+		return "Open"
+	case CircuitHalfOpen:
+		return "HalfOpen"
+	default:
+		return "Unknown CircuitState " + strconv.Itoa(int(cs))
+	}
+}
+
+// CircuitBreakerParams configures the circuit breaker of a Host.
+type CircuitBreakerParams struct {
+	// Toggles the circuit breaker
+	Enabled bool
+
+	// Number of consecutive failed sends which opens the breaker. Zero
+	// disables the limit.
+	ConsecutiveFailures uint32
+
+	// Fraction of failed sends within Window which opens the breaker,
+	// between 0 and 1. Zero disables the limit.
+	FailureRate float64
+
+	// Time the failure rate is measured over
+	Window time.Duration
+
+	// Minimum number of sends within Window before FailureRate applies
+	MinSends uint32
+
+	// Time the breaker stays open before letting probes through
+	OpenTimeout time.Duration
+
+	// Number of probe sends let through at once while half open. The
+	// breaker closes after as many probes succeed.
+	HalfOpenProbes uint32
+
+	// Returns true for errors which count as failures. Sends failing with
+	// other errors reached the Host, so they count as successes. Nil counts
+	// connection, disconnection and handshake failures.
+	IsFailure func(err error) bool
+}
+
+// GetDefaultCircuitBreakerParams returns the default CircuitBreakerParams,
+// with the breaker disabled.
+func GetDefaultCircuitBreakerParams() CircuitBreakerParams {
+	return CircuitBreakerParams{
+		Enabled:             false,
+		ConsecutiveFailures: 5,
+		FailureRate:         0.5,
+		Window:              time.Minute,
+		MinSends:            20,
+		OpenTimeout:         30 * time.Second,
+		HalfOpenProbes:      1,
+	}
+}
+
+// circuitBreakerParams returns the CircuitBreakerParams of the HostParams. If
+// the breaker is disabled but the deprecated cool off is enabled, it returns
+// a breaker opening for CoolOffTimeout after NumSendsBeforeCoolOff
+// consecutive failures.
+func (params HostParams) circuitBreakerParams() CircuitBreakerParams {
+	if params.CircuitBreaker.Enabled || !params.EnableCoolOff {
+		return params.CircuitBreaker
+	}
+	return CircuitBreakerParams{
+		Enabled:             true,
+		ConsecutiveFailures: params.NumSendsBeforeCoolOff,
+		OpenTimeout:         params.CoolOffTimeout,
+		HalfOpenProbes:      1,
+	}
+}
+
+// isBreakerFailure returns true if the send failed because the Host could not
+// be reached or authenticated with.
+func isBreakerFailure(err error) bool {
+	return isConnError(err) || errors.Is(err, ErrConnectFailed) ||
+		errors.Is(err, ErrHandshakeFailed)
+}
+
+// breakerBucket counts the sends in a slice of the failure rate window.
+type breakerBucket struct {
+	start    int64
+	sends    uint32
+	failures uint32
+}
+
+// circuitBreaker tracks the outcomes of sends to a Host and stops sends while
+// too many fail.
+type circuitBreaker struct {
+	params CircuitBreakerParams
+	state  CircuitState
+
+	// Number of failures since the last success
+	consecutive uint32
+	// Outcomes within the failure rate window
+	buckets [breakerWindowBuckets]breakerBucket
+
+	// Time the breaker last opened
+	openedAt time.Time
+	// Probes in flight and probes which succeeded while half open
+	probes         uint32
+	probeSuccesses uint32
+
+	mux sync.Mutex
+}
+
+// newCircuitBreaker creates a closed circuitBreaker.
+func newCircuitBreaker(params CircuitBreakerParams) *circuitBreaker {
+	if params.HalfOpenProbes == 0 {
+		params.HalfOpenProbes = 1
+	}
+	if params.IsFailure == nil {
+		params.IsFailure = isBreakerFailure
+	}
+	return &circuitBreaker{params: params}
+}
+
+// allow returns ErrCircuitOpen if a send may not be made. Otherwise it returns
+// whether the send is a probe, which must be passed to record along with the
+// outcome of the send. Also returns the new state if the breaker half opened.
+func (cb *circuitBreaker) allow() (probe bool, changed *CircuitState,
+	err error) {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	if cb.state == CircuitOpen {
+		if time.Since(cb.openedAt) < cb.params.OpenTimeout {
+			return false, nil, ErrCircuitOpen
+		}
+		changed = cb.setState(CircuitHalfOpen)
+	}
+
+	if cb.state == CircuitHalfOpen {
+		if cb.probes >= cb.params.HalfOpenProbes {
+			return false, changed, ErrCircuitOpen
+		}
+		cb.probes++
+		return true, changed, nil
+	}
+	return false, changed, nil
+}
+
+// record counts the outcome of a send let through by allow. Returns the new
+// state if the breaker opened or closed.
+func (cb *circuitBreaker) record(probe bool, err error) *CircuitState {
+	// Sends abandoned by the caller say nothing about the Host
+	canceled := errors.Is(err, context.Canceled)
+	failed := err != nil && !canceled && cb.params.IsFailure(err)
+
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	cb.releaseProbe(probe)
+	if canceled {
+		return nil
+	}
+
+	bucket := cb.bucket(time.Now())
+	bucket.sends++
+	if !failed {
+		cb.consecutive = 0
+		if probe && cb.state == CircuitHalfOpen {
+			cb.probeSuccesses++
+			if cb.probeSuccesses >= cb.params.HalfOpenProbes {
+				return cb.setState(CircuitClosed)
+			}
+		}
+		return nil
+	}
+
+	bucket.failures++
+	cb.consecutive++
+	switch {
+	case cb.state == CircuitHalfOpen && probe:
+		return cb.setState(CircuitOpen)
+	case cb.state != CircuitClosed:
+		return nil
+	case cb.params.ConsecutiveFailures > 0 &&
+		cb.consecutive >= cb.params.ConsecutiveFailures:
+		return cb.setState(CircuitOpen)
+	case cb.overFailureRate():
+		return cb.setState(CircuitOpen)
+	}
+	return nil
+}
+
+// abandon releases a send let through by allow which was not made.
+func (cb *circuitBreaker) abandon(probe bool) {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+	cb.releaseProbe(probe)
+}
+
+// releaseProbe frees the place of the send if it is a probe.
+// undefined behavior if the caller has not taken the lock
+func (cb *circuitBreaker) releaseProbe(probe bool) {
+	if probe && cb.probes > 0 {
+		cb.probes--
+	}
+}
+
+// getState returns the state of the breaker. An open breaker whose
+// OpenTimeout has passed is reported as half open, as the next send will be
+// let through as a probe.
+func (cb *circuitBreaker) getState() CircuitState {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+	if cb.state == CircuitOpen &&
+		time.Since(cb.openedAt) >= cb.params.OpenTimeout {
+		return CircuitHalfOpen
+	}
+	return cb.state
+}
+
+// reset closes the breaker and forgets the outcomes of past sends.
+func (cb *circuitBreaker) reset() *CircuitState {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+	if cb.state == CircuitClosed {
+		cb.clear()
+		return nil
+	}
+	return cb.setState(CircuitClosed)
+}
+
+// setState moves the breaker to the state and returns it.
+// undefined behavior if the caller has not taken the lock
+func (cb *circuitBreaker) setState(state CircuitState) *CircuitState {
+	cb.state = state
+	cb.probeSuccesses = 0
+	switch state {
+	case CircuitOpen:
+		cb.openedAt = time.Now()
+	case CircuitClosed:
+		cb.clear()
+	}
+	return &state
+}
+
+// clear forgets the outcomes of past sends.
+// undefined behavior if the caller has not taken the lock
+func (cb *circuitBreaker) clear() {
+	cb.consecutive = 0
+	cb.buckets = [breakerWindowBuckets]breakerBucket{}
+}
+
+// bucket returns the bucket of the failure rate window the time falls in,
+// emptying it if it held the counts of an earlier window.
+// undefined behavior if the caller has not taken the lock
+func (cb *circuitBreaker) bucket(now time.Time) *breakerBucket {
+	width := int64(cb.params.Window / breakerWindowBuckets)
+	if width <= 0 {
+		width = 1
+	}
+	start := now.UnixNano() / width
+	bucket := &cb.buckets[start%breakerWindowBuckets]
+	if bucket.start != start {
+		*bucket = breakerBucket{start: start}
+	}
+	return bucket
+}
+
+// overFailureRate returns true if enough sends were made within the window
+// and the fraction which failed reached the FailureRate.
+// undefined behavior if the caller has not taken the lock
+func (cb *circuitBreaker) overFailureRate() bool {
+	if cb.params.FailureRate <= 0 || cb.params.Window <= 0 {
+		return false
+	}
+
+	current := cb.bucket(time.Now()).start
+	var sends, failures uint32
+	for _, bucket := range cb.buckets {
+		if current-bucket.start < breakerWindowBuckets {
+			sends += bucket.sends
+			failures += bucket.failures
+		}
+	}
+	return sends > 0 && sends >= cb.params.MinSends &&
+		float64(failures)/float64(sends) >= cb.params.FailureRate
+}
+
+// GetCircuitState returns the state of the circuit breaker of the Host. Hosts
+// without a circuit breaker are always CircuitClosed.
+func (h *Host) GetCircuitState() CircuitState {
+	if h.breaker == nil {
+		return CircuitClosed
+	}
+	return h.breaker.getState()
+}
+
+// allowSend checks the circuit breaker before a send attempt. Returns
+// ErrCircuitOpen if the attempt may not be made, otherwise whether it is a
+// probe, to be passed to recordSend.
+func (h *Host) allowSend() (bool, error) {
+	if h.breaker == nil {
+		return false, nil
+	}
+	probe, changed, err := h.breaker.allow()
+	if changed != nil {
+		h.connectionMux.RLock()
+		h.notifyCircuitState(changed)
+		h.connectionMux.RUnlock()
+	}
+	if err != nil {
+		return false, errors.WithMessagef(err, "Cannot send to host %s", h.id)
+	}
+	return probe, nil
+}
+
+// recordSend passes the outcome of a send attempt to the circuit breaker.
+func (h *Host) recordSend(probe bool, err error) {
+	if h.breaker == nil {
+		return
+	}
+	if changed := h.breaker.record(probe, err); changed != nil {
+		h.connectionMux.RLock()
+		h.notifyCircuitState(changed)
+		h.connectionMux.RUnlock()
+	}
+}
+
+// abandonSend tells the circuit breaker that a send attempt allowed by
+// allowSend was not made.
+func (h *Host) abandonSend(probe bool) {
+	if h.breaker != nil {
+		h.breaker.abandon(probe)
+	}
+}
+
+// resetCircuit closes the circuit breaker of the Host.
+// undefined behavior if the caller has not taken the connectionMux
+func (h *Host) resetCircuit() {
+	if h.breaker != nil {
+		h.notifyCircuitState(h.breaker.reset())
+	}
+}
+
+// notifyCircuitState notifies observers that the circuit breaker changed to
+// the state, if it changed.
+// undefined behavior if the caller has not taken the connectionMux
+func (h *Host) notifyCircuitState(state *CircuitState) {
+	if state == nil {
+		return
+	}
+	switch *state {
+	case CircuitOpen:
+		h.notify(HostCircuitOpened, ErrCircuitOpen)
+	case CircuitHalfOpen:
+		h.notify(HostCircuitHalfOpened, nil)
+	case CircuitClosed:
+		h.notify(HostCircuitClosed, nil)
+	}
+}
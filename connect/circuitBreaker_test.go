@@ -0,0 +1,201 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"gitlab.com/xx_network/primitives/id"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Tests that the circuit breaker opens after consecutive failures, lets a
+// single probe through once the OpenTimeout passed and closes when it
+// succeeds.
+func TestCircuitBreaker_ConsecutiveFailures(t *testing.T) {
+	params := GetDefaultCircuitBreakerParams()
+	params.Enabled = true
+	params.ConsecutiveFailures = 3
+	params.FailureRate = 0
+	params.OpenTimeout = 50 * time.Millisecond
+	cb := newCircuitBreaker(params)
+
+	send := func(err error) *CircuitState {
+		probe, _, allowErr := cb.allow()
+		if allowErr != nil {
+			t.Fatalf("Send was not allowed: %+v", allowErr)
+		}
+		return cb.record(probe, err)
+	}
+
+	send(ErrHostDisconnected)
+	send(ErrHostDisconnected)
+	send(nil)
+	send(ErrHostDisconnected)
+	send(ErrHostDisconnected)
+	if cb.getState() != CircuitClosed {
+		t.Fatalf("Breaker opened after a success reset the failures")
+	}
+	if changed := send(ErrHostDisconnected); changed == nil ||
+		*changed != CircuitOpen {
+		t.Fatalf("Breaker did not open after %d consecutive failures",
+			params.ConsecutiveFailures)
+	}
+
+	_, _, err := cb.allow()
+	if !errors.Is(err, ErrCircuitOpen) || !errors.Is(err, ErrCoolOff) {
+		t.Errorf("Open breaker did not return %q: %+v", ErrCircuitOpen, err)
+	}
+
+	time.Sleep(params.OpenTimeout)
+	if cb.getState() != CircuitHalfOpen {
+		t.Errorf("Breaker is not half open after the OpenTimeout: %s",
+			cb.getState())
+	}
+	probe, changed, err := cb.allow()
+	if err != nil || !probe || changed == nil || *changed != CircuitHalfOpen {
+		t.Fatalf("Probe was not allowed: %t %v %+v", probe, changed, err)
+	}
+	if _, _, err = cb.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Allowed a second probe: %+v", err)
+	}
+	if changed = cb.record(probe, ErrConnectFailed); changed == nil ||
+		*changed != CircuitOpen {
+		t.Fatalf("Breaker did not open again after the probe failed")
+	}
+
+	time.Sleep(params.OpenTimeout)
+	probe, _, err = cb.allow()
+	if err != nil || !probe {
+		t.Fatalf("Probe was not allowed: %t %+v", probe, err)
+	}
+	if changed = cb.record(probe, nil); changed == nil ||
+		*changed != CircuitClosed {
+		t.Errorf("Breaker did not close after the probe succeeded")
+	}
+}
+
+// Tests that the circuit breaker opens once the failure rate within the
+// window reaches its limit, and only counts failures of the right classes.
+func TestCircuitBreaker_FailureRate(t *testing.T) {
+	params := GetDefaultCircuitBreakerParams()
+	params.Enabled = true
+	params.ConsecutiveFailures = 0
+	params.FailureRate = 0.5
+	params.MinSends = 4
+	cb := newCircuitBreaker(params)
+
+	outcomes := []error{
+		ErrHostDisconnected,
+		status.Error(codes.NotFound, "not found"),
+		errors.WithStack(context.Canceled),
+		&handshakeError{ErrAuthFailed},
+		nil,
+	}
+	for i, err := range outcomes {
+		if changed := cb.record(false, err); changed != nil {
+			t.Fatalf("Breaker changed to %s after outcome %d", *changed, i)
+		}
+	}
+
+	if changed := cb.record(false, ErrConnectFailed); changed == nil ||
+		*changed != CircuitOpen {
+		t.Errorf("Breaker did not open at a failure rate of %.2f",
+			params.FailureRate)
+	}
+
+	cb.reset()
+	if cb.getState() != CircuitClosed || cb.overFailureRate() {
+		t.Errorf("Reset did not close the breaker and clear the window")
+	}
+}
+
+// Tests that the deprecated cool off parameters configure a circuit breaker.
+func TestHostParams_circuitBreakerParams(t *testing.T) {
+	params := GetDefaultHostParams()
+	if params.circuitBreakerParams().Enabled {
+		t.Errorf("Circuit breaker is enabled by default")
+	}
+
+	params.EnableCoolOff = true
+	received := params.circuitBreakerParams()
+	if !received.Enabled ||
+		received.ConsecutiveFailures != params.NumSendsBeforeCoolOff ||
+		received.OpenTimeout != params.CoolOffTimeout {
+		t.Errorf("Cool off was not mapped to a circuit breaker: %+v",
+			received)
+	}
+
+	params.CircuitBreaker.Enabled = true
+	received = params.circuitBreakerParams()
+	received.IsFailure, params.CircuitBreaker.IsFailure = nil, nil
+	if !reflect.DeepEqual(received, params.CircuitBreaker) {
+		t.Errorf("Cool off overrode the circuit breaker.\nexpected: %+v"+
+			"\nreceived: %+v", params.CircuitBreaker, received)
+	}
+}
+
+// Tests that sends to a Host whose circuit breaker opened are rejected
+// without calling the send function, and that the Manager reports the Host
+// as unavailable.
+func TestProtoComms_transmit_CircuitBreaker(t *testing.T) {
+	c := &ProtoComms{Manager: newManager()}
+	events := make(chan HostEvent, 10)
+	c.AddObserver(func(event HostEvent) {
+		if event.Type == HostCircuitOpened {
+			events <- event
+		}
+	})
+
+	params := GetDefaultHostParams()
+	params.AuthEnabled = false
+	params.MaxRetries = 1
+	params.CircuitBreaker.Enabled = true
+	params.CircuitBreaker.ConsecutiveFailures = 2
+	hostId := id.NewIdFromString("breaker", id.Node, t)
+	host, err := c.AddHost(hostId, ServerAddress, nil, params)
+	if err != nil {
+		t.Fatalf("Failed to add host: %+v", err)
+	}
+	defer host.Disconnect()
+
+	var tries int
+	send := func(Connection) (interface{}, error) {
+		tries++
+		return nil, status.Error(codes.Unavailable, "transport is closing")
+	}
+	for i := 0; i < 3; i++ {
+		_, err = c.transmit(host, send)
+	}
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected %q, received %+v", ErrCircuitOpen, err)
+	}
+	if tries != 2 {
+		t.Errorf("Send was made with the breaker open; tries: %d", tries)
+	}
+
+	select {
+	case event := <-events:
+		if !event.HostId.Cmp(hostId) {
+			t.Errorf("Event for the wrong host: %s", event.HostId)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Timed out waiting for %s", HostCircuitOpened)
+	}
+
+	if host.GetCircuitState() != CircuitOpen {
+		t.Errorf("Unexpected state: %s", host.GetCircuitState())
+	}
+	if len(c.GetAvailableHosts()) != 0 {
+		t.Errorf("Manager returned a host whose breaker is open")
+	}
+}
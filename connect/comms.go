@@ -21,18 +21,24 @@ import (
 	"github.com/soheilhy/cmux"
 	jww "github.com/spf13/jwalterweatherman"
 	"gitlab.com/xx_network/comms/connect/token"
+	pb "gitlab.com/xx_network/comms/messages"
+	"gitlab.com/xx_network/crypto/signature/ec"
 	"gitlab.com/xx_network/crypto/signature/rsa"
 	"gitlab.com/xx_network/primitives/id"
 	"golang.org/x/crypto/cryptobyte"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/keepalive"
 	"io"
 	"math"
 	"net"
 	"net/http"
+	"sort"
 	"src.agwa.name/tlshacks"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -42,12 +48,15 @@ const tlsHandshakePrefixLen = 5
 
 // TestingOnlyDisableTLS is the variable set for testing
 // which allows for the disabled TLS code-path. Production
-// code-path will only function with TLS enabled.
+// code-path will only function with TLS enabled. Servers only read it as the
+// default of ServerOptions.DisableTLS.
 var TestingOnlyDisableTLS = false
 var TestingOnlyInsecureTLSVerify = false
 
 // KaOpts are Keepalive options for servers
-// TODO: Set these via config
+//
+// Deprecated: Set ServerOptions.KaOpts instead. KaOpts is only read as the
+// default of GetDefaultServerOptions.
 var KaOpts = keepalive.ServerParameters{
 	// Idle for at most 60s
 	MaxConnectionIdle: 60 * time.Second,
@@ -62,6 +71,9 @@ var KaOpts = keepalive.ServerParameters{
 }
 
 // KaEnforcement are keepalive enforcement options for servers
+//
+// Deprecated: Set ServerOptions.KaEnforcement instead. KaEnforcement is only
+// read as the default of GetDefaultServerOptions.
 var KaEnforcement = keepalive.EnforcementPolicy{
 	// Send keepAlive every Time interval
 	MinTime: 3 * time.Second,
@@ -70,6 +82,10 @@ var KaEnforcement = keepalive.EnforcementPolicy{
 }
 
 // MaxConcurrentStreams is the number of server-side streams to allow open
+//
+// Deprecated: Set ServerOptions.MaxConcurrentStreams instead.
+// MaxConcurrentStreams is only read as the default of
+// GetDefaultServerOptions.
 var MaxConcurrentStreams = uint32(250000)
 
 // ProtoComms is a proto object containing a gRPC server logic.
@@ -83,6 +99,17 @@ type ProtoComms struct {
 	// Private key of the local comms instance
 	privateKey *rsa.PrivateKey
 
+	// Optional Ed25519 identity key of the local comms instance
+	eccPrivateKey *ec.PrivateKey
+
+	// Guards the private keys and the TLS certificates so that they can be
+	// replaced while the server is running
+	certMux sync.RWMutex
+
+	// Signature schemes accepted from hosts, nil if all are accepted
+	allowedSchemes map[pb.SignatureScheme]struct{}
+	schemesMux     sync.RWMutex
+
 	// Disables the checking of authentication signatures for testing setups
 	disableAuth bool
 
@@ -92,6 +119,9 @@ type ProtoComms struct {
 
 	// A map of reverse-authentication tokens
 	tokens *token.Map
+	// Closed to stop the background cleanup of expired tokens
+	tokenCleanupStop chan struct{}
+	tokenCleanupMux  sync.Mutex
 
 	// Low-level net.Listener object that listens at listeningAddress
 	netListener net.Listener
@@ -104,6 +134,30 @@ type ProtoComms struct {
 	grpcCreds tls.Certificate
 	// Parsed grpc x509 certificate for checking incoming tls request servernames
 	grpcX509 *x509.Certificate
+	// Options the server was started with, reused on restart
+	serverOpts ServerOptions
+	// Set of full method names which require authentication and the
+	// policies authorizing their callers, enforced by the server interceptors
+	authMethods    map[string]struct{}
+	methodPolicies map[string]MethodPolicy
+	authMethodsMux sync.RWMutex
+	// Origin policy applied to grpc-web requests, created on first use
+	origins     *originFilter
+	originsOnce sync.Once
+	// Nonces of signed messages received within the replay window, created
+	// on first use
+	replay     *replayCache
+	replayOnce sync.Once
+
+	// Registry of exported metrics, created on first use
+	metricsRegistry *MetricsRegistry
+	metricsOnce     sync.Once
+	// Tracks the duration of client authentication handshakes
+	handshakeLatency latencySummary
+	// Serving status reported by the grpc.health.v1 service, created on
+	// first use
+	healthServer *health.Server
+	healthOnce   sync.Once
 
 	// CLIENT-ONLY FIELDS ------------------------------------------------------
 
@@ -161,26 +215,58 @@ func CreateCommClient(id *id.ID, pubKeyPem, privKeyPem,
 // Opens a net.Listener the local address specified by listeningAddr.
 func StartCommServer(id *id.ID, listeningAddr string,
 	certPEMblock, keyPEMblock []byte, preloadedHosts []*Host) (*ProtoComms, error) {
+	return StartCommServerWithOptions(id, listeningAddr, certPEMblock,
+		keyPEMblock, preloadedHosts, GetDefaultServerOptions())
+}
 
-listen:
-	// Listen on the given address
-	lis, err := net.Listen("tcp", listeningAddr)
+// StartCommServerWithOptions creates a ProtoComms server-type object
+// configured by the given ServerOptions. Opens a net.Listener the local
+// address specified by listeningAddr, retrying according to opts.BindRetry.
+func StartCommServerWithOptions(id *id.ID, listeningAddr string,
+	certPEMblock, keyPEMblock []byte, preloadedHosts []*Host,
+	opts ServerOptions) (*ProtoComms, error) {
+	return StartCommServerWithContext(context.Background(), id, listeningAddr,
+		certPEMblock, keyPEMblock, preloadedHosts, opts)
+}
+
+// StartCommServerWithContext is the same as StartCommServerWithOptions, but
+// gives up retrying to bind the listening address once the context is done.
+func StartCommServerWithContext(ctx context.Context, id *id.ID,
+	listeningAddr string, certPEMblock, keyPEMblock []byte,
+	preloadedHosts []*Host, opts ServerOptions) (*ProtoComms, error) {
+	lis, err := Listen(ctx, listeningAddr, opts)
 	if err != nil {
-		if strings.Contains(err.Error(), "bind: address already in use") {
-			jww.WARN.Printf("Could not listen on %s, is port in use? waiting 30s: %s", listeningAddr, err.Error())
-			time.Sleep(30 * time.Second)
-			goto listen
-		}
-		return nil, errors.New(err.Error())
+		return nil, err
+	}
+
+	pc, err := StartCommServerWithListener(id, lis, certPEMblock, keyPEMblock,
+		preloadedHosts, opts)
+	if err != nil {
+		_ = lis.Close()
+		return nil, err
+	}
+	return pc, nil
+}
+
+// StartCommServerWithListener creates a ProtoComms server-type object which
+// serves on the given ready-made net.Listener. This allows for socket
+// activation, unix domain sockets and ephemeral ports; the address the
+// listener is bound to is reported by GetListeningAddress.
+func StartCommServerWithListener(id *id.ID, lis net.Listener,
+	certPEMblock, keyPEMblock []byte, preloadedHosts []*Host,
+	opts ServerOptions) (*ProtoComms, error) {
+	if lis.Addr().Network() == "unix" {
+		opts.Network = "unix"
 	}
 
 	// Build the comms object
 	pc := &ProtoComms{
 		networkId:        id,
 		netListener:      lis,
-		tokens:           token.NewMap(),
+		tokens:           token.NewMapWithParams(opts.TokenParams),
 		Manager:          newManager(),
-		listeningAddress: listeningAddr,
+		listeningAddress: lis.Addr().String(),
+		serverOpts:       opts,
 	}
 
 	for _, h := range preloadedHosts {
@@ -191,9 +277,9 @@ listen:
 	if certPEMblock != nil && keyPEMblock != nil {
 
 		// Create the TLS certificate
-		x509cert, err := tls.X509KeyPair(certPEMblock, keyPEMblock)
+		x509cert, err := loadCertificate(certPEMblock, keyPEMblock)
 		if err != nil {
-			return nil, errors.Errorf("Could not load TLS keys: %+v", err)
+			return nil, err
 		}
 
 		// Set the private key
@@ -207,86 +293,235 @@ listen:
 
 		// Create the gRPC server with TLS
 		jww.INFO.Printf("Starting server with TLS...")
-		if x509cert.Leaf == nil {
-			x509cert.Leaf, err = x509.ParseCertificate(x509cert.Certificate[0])
-			if err != nil {
-				return nil, errors.WithMessage(err, "Could not parse x509 certificate")
-			}
-		}
 		pc.grpcX509 = x509cert.Leaf
-		creds := credentials.NewServerTLSFromCert(&x509cert)
 		pc.grpcCreds = x509cert
-		pc.grpcServer = grpc.NewServer(grpc.Creds(creds),
-			grpc.MaxConcurrentStreams(MaxConcurrentStreams),
-			grpc.MaxRecvMsgSize(math.MaxInt32),
-			grpc.KeepaliveParams(KaOpts),
-			grpc.KeepaliveEnforcementPolicy(KaEnforcement))
-	} else if TestingOnlyDisableTLS {
+		pc.serverOpts.DisableTLS = false
+		pc.grpcServer = pc.newGrpcServer(pc.newServerCredentials())
+	} else if opts.tlsDisabled() {
 		// Create the gRPC server without TLS
 		jww.WARN.Printf("Starting server with TLS disabled...")
-		pc.grpcServer = grpc.NewServer(
-			grpc.MaxConcurrentStreams(MaxConcurrentStreams),
-			grpc.MaxRecvMsgSize(math.MaxInt32),
-			grpc.KeepaliveParams(KaOpts),
-			grpc.KeepaliveEnforcementPolicy(KaEnforcement))
+		pc.grpcServer = pc.newGrpcServer(nil)
 	} else {
 		jww.FATAL.Panicf("TLS cannot be disabled in production, only for testing suites!")
 	}
 
+	pc.startTokenCleanup()
 	return pc, nil
 }
 
+// GetServerOptions returns the ServerOptions the server was started with.
+func (c *ProtoComms) GetServerOptions() ServerOptions {
+	return c.serverOpts
+}
+
 // Restart is a public accessor meant to allow for reuse of a host after
 // Shutdown is called.  The intended use is for replacing certificates.
 func (c *ProtoComms) Restart() error {
-	if TestingOnlyDisableTLS {
-		c.grpcServer = grpc.NewServer(
-			grpc.MaxConcurrentStreams(MaxConcurrentStreams),
-			grpc.MaxRecvMsgSize(math.MaxInt32),
-			grpc.KeepaliveParams(KaOpts),
-			grpc.KeepaliveEnforcementPolicy(KaEnforcement))
-	} else {
-		creds := credentials.NewServerTLSFromCert(&c.grpcCreds)
-		if c.grpcCreds.Leaf == nil {
-			var err error
-			c.grpcCreds.Leaf, err = x509.ParseCertificate(c.grpcCreds.Certificate[0])
-			if err != nil {
-				return errors.WithMessage(err, "Could not parse x509 certificate")
-			}
-		}
-		c.grpcX509 = c.grpcCreds.Leaf
-		c.grpcServer = grpc.NewServer(grpc.Creds(creds),
-			grpc.MaxConcurrentStreams(MaxConcurrentStreams),
-			grpc.MaxRecvMsgSize(math.MaxInt32),
-			grpc.KeepaliveParams(KaOpts),
-			grpc.KeepaliveEnforcementPolicy(KaEnforcement))
-	}
+	return c.RestartWithContext(context.Background())
+}
 
+// RestartWithContext is the same as Restart, but gives up retrying to bind
+// the listening address once the context is done.
+func (c *ProtoComms) RestartWithContext(ctx context.Context) error {
 	if c.netListener != nil {
 		return errors.New("ProtoComms is already listening")
 	}
-listen:
+
+	err := c.newServer()
+	if err != nil {
+		return err
+	}
+
 	// Listen on the given address
-	lis, err := net.Listen("tcp", c.listeningAddress)
+	lis, err := Listen(ctx, c.listeningAddress, c.serverOpts)
 	if err != nil {
-		if strings.Contains(err.Error(), "bind: address already in use") {
-			jww.WARN.Printf("Could not listen on %s, is port in use? waiting 30s: %s", c.listeningAddress, err.Error())
-			time.Sleep(30 * time.Second)
-			goto listen
-		}
-		return errors.New(err.Error())
+		return err
 	}
 
 	c.netListener = lis
+	c.resume()
+	return nil
+}
+
+// RestartWithListener is the same as Restart, but serves on the given
+// ready-made net.Listener instead of re-opening the listening address.
+func (c *ProtoComms) RestartWithListener(lis net.Listener) error {
+	if c.netListener != nil {
+		return errors.New("ProtoComms is already listening")
+	}
+
+	err := c.newServer()
+	if err != nil {
+		return err
+	}
+
+	c.netListener = lis
+	c.listeningAddress = lis.Addr().String()
+	c.resume()
+	return nil
+}
+
+// resume sets every service reported by the health service back to SERVING
+// and restarts the token cleanup. It is called once a restarted server has
+// its listener.
+func (c *ProtoComms) resume() {
+	c.getHealthServer().Resume()
+	c.startTokenCleanup()
+}
+
+// newServer rebuilds the grpc.Server from the stored credentials and
+// ServerOptions.
+func (c *ProtoComms) newServer() error {
+	if c.serverOpts.tlsDisabled() {
+		c.grpcServer = c.newGrpcServer(nil)
+		return nil
+	}
+
+	c.certMux.Lock()
+	if c.grpcCreds.Leaf == nil {
+		var err error
+		c.grpcCreds.Leaf, err = x509.ParseCertificate(c.grpcCreds.Certificate[0])
+		if err != nil {
+			c.certMux.Unlock()
+			return errors.WithMessage(err, "Could not parse x509 certificate")
+		}
+	}
+	c.grpcX509 = c.grpcCreds.Leaf
+	c.certMux.Unlock()
+
+	c.grpcServer = c.newGrpcServer(c.newServerCredentials())
+	return nil
+}
+
+// newGrpcServer builds a grpc.Server using the stored ServerOptions and the
+// interceptors of this ProtoComms.
+func (c *ProtoComms) newGrpcServer(
+	creds credentials.TransportCredentials) *grpc.Server {
+	server := c.serverOpts.newGrpcServer(creds,
+		grpc.ChainUnaryInterceptor(c.authUnaryInterceptor),
+		grpc.ChainStreamInterceptor(c.authStreamInterceptor))
+	healthpb.RegisterHealthServer(server, c.getHealthServer())
+	return server
+}
+
+// newServerCredentials returns the gRPC TransportCredentials for the server.
+// The certificate is looked up on every handshake so that it can be replaced
+// by UpdateCertificate without rebuilding the server.
+func (c *ProtoComms) newServerCredentials() credentials.TransportCredentials {
+	return credentials.NewTLS(&tls.Config{
+		GetCertificate: c.getGrpcCertificate,
+	})
+}
+
+// getGrpcCertificate returns the current gRPC TLS certificate under the read
+// lock. It is used as the tls.Config GetCertificate callback.
+func (c *ProtoComms) getGrpcCertificate(
+	*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.certMux.RLock()
+	defer c.certMux.RUnlock()
+	if len(c.grpcCreds.Certificate) == 0 {
+		return nil, errors.New("No TLS certificate loaded")
+	}
+	cert := c.grpcCreds
+	return &cert, nil
+}
+
+// getHttpsCertificate returns the current HTTPS TLS certificate under the
+// read lock. It is used as the tls.Config GetCertificate callback.
+func (c *ProtoComms) getHttpsCertificate(
+	*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.certMux.RLock()
+	defer c.certMux.RUnlock()
+	if c.httpsCertificate == nil {
+		return nil, errors.New("No HTTPS certificate loaded")
+	}
+	return c.httpsCertificate, nil
+}
+
+// UpdateCertificate replaces the gRPC TLS certificate, the certificate sent
+// in handshakes and the private key used for signing messages without
+// restarting the server. New TLS
+// handshakes use the new certificate while existing connections and
+// registered services are unaffected.
+func (c *ProtoComms) UpdateCertificate(certPEMblock, keyPEMblock []byte) error {
+	if c.serverOpts.tlsDisabled() {
+		return errors.New("Cannot update the certificate of a server " +
+			"running without TLS")
+	}
+
+	x509cert, err := loadCertificate(certPEMblock, keyPEMblock)
+	if err != nil {
+		return err
+	}
+
+	key, err := rsa.LoadPrivateKeyFromPem(keyPEMblock)
+	if err != nil {
+		return errors.Errorf("Could not load private key: %+v", err)
+	}
+
+	c.certMux.Lock()
+	c.grpcCreds = x509cert
+	c.grpcX509 = x509cert.Leaf
+	c.privateKey = key
+	c.pubKeyPem = certPEMblock
+	c.certMux.Unlock()
+
+	jww.INFO.Printf("Updated gRPC certificate for %s", c.networkId)
 	return nil
 }
 
+// UpdateHttpsCertificate replaces the certificate served by ServeHttps
+// without restarting the server. New TLS handshakes use the new certificate
+// while existing connections are unaffected.
+func (c *ProtoComms) UpdateHttpsCertificate(keyPair tls.Certificate) error {
+	if keyPair.Leaf == nil {
+		var err error
+		keyPair.Leaf, err = x509.ParseCertificate(keyPair.Certificate[0])
+		if err != nil {
+			return errors.WithMessage(err, "Could not parse x509 certificate")
+		}
+	}
+
+	c.certMux.Lock()
+	c.httpsCertificate = &keyPair
+	c.httpsX509 = keyPair.Leaf
+	c.certMux.Unlock()
+
+	jww.INFO.Printf("Updated HTTPS certificate for %s", c.networkId)
+	return nil
+}
+
+// loadCertificate parses a PEM-encoded TLS certificate and key pair,
+// including the leaf certificate.
+func loadCertificate(certPEMblock, keyPEMblock []byte) (tls.Certificate, error) {
+	x509cert, err := tls.X509KeyPair(certPEMblock, keyPEMblock)
+	if err != nil {
+		return tls.Certificate{}, errors.Errorf("Could not load TLS keys: %+v", err)
+	}
+
+	if x509cert.Leaf == nil {
+		x509cert.Leaf, err = x509.ParseCertificate(x509cert.Certificate[0])
+		if err != nil {
+			return tls.Certificate{}, errors.WithMessage(err,
+				"Could not parse x509 certificate")
+		}
+	}
+	return x509cert, nil
+}
+
+// GetListeningAddress returns the address the server is bound to. For
+// servers started on an ephemeral port, this contains the assigned port.
+func (c *ProtoComms) GetListeningAddress() string {
+	return c.listeningAddress
+}
+
 // Serve is a non-blocking call that begins serving content
 // for GRPC. GRPC endpoints must be registered before making this call.
 func (c *ProtoComms) Serve() {
 	listenGRPC := func(l net.Listener) {
 		// This blocks for the lifetime of the listener.
-		if err := c.GetServer().Serve(l); err != nil {
+		if err := c.GetServer().Serve(l); err != nil &&
+			!errors.Is(err, grpc.ErrServerStopped) {
 			jww.FATAL.Panicf("Failed to serve GRPC: %+v", err)
 		}
 		jww.INFO.Printf("Shutting down GRPC server listener")
@@ -304,23 +539,23 @@ func (c *ProtoComms) ServeWithWeb() {
 	mux := cmux.New(c.netListener)
 	grpcMatcher := c.matchGrpcTls
 
-	if TestingOnlyDisableTLS {
+	if c.serverOpts.tlsDisabled() {
 		grpcMatcher = cmux.HTTP2()
 	}
 	httpL := mux.Match(cmux.HTTP1())
 	grpcL := mux.Match(grpcMatcher)
 	c.mux = mux
 
+	httpServer := &http.Server{
+		Handler: c.newWebHandler(grpcServer),
+	}
+	c.httpServer = httpServer
+
 	listenHTTP := func(l net.Listener) {
-		httpServer := grpcweb.WrapServer(grpcServer,
-			grpcweb.WithOriginFunc(func(origin string) bool { return true }))
 		jww.WARN.Printf("Starting HTTP server!")
 
-		c.httpServer = &http.Server{
-			Handler: httpServer,
-		}
-
-		if err := c.httpServer.Serve(l); err != nil {
+		if err := httpServer.Serve(l); err != nil &&
+			!errors.Is(err, http.ErrServerClosed) {
 			// Cannot panic here due to shared net.Listener
 			jww.ERROR.Printf("Failed to serve HTTP: %+v", err)
 		}
@@ -334,7 +569,7 @@ func (c *ProtoComms) ServeWithWeb() {
 		jww.INFO.Printf("Shutting down GRPC server listener")
 	}
 	listenPort := func() {
-		if err := mux.Serve(); err != nil {
+		if err := mux.Serve(); err != nil && !errors.Is(err, net.ErrClosed) {
 			// Cannot panic here due to shared net.Listener
 			jww.ERROR.Printf("Failed to serve port: %+v", err)
 		}
@@ -356,7 +591,9 @@ func (c *ProtoComms) matchGrpcTls(r io.Reader) bool {
 	}
 
 	if hello.Info.ServerName != nil {
+		c.certMux.RLock()
 		err := c.grpcX509.VerifyHostname(*hello.Info.ServerName)
+		c.certMux.RUnlock()
 		if err == nil {
 			return true
 		} else {
@@ -390,7 +627,9 @@ func (c *ProtoComms) matchWebTls(r io.Reader) bool {
 	}
 
 	if hello.Info.ServerName != nil {
+		c.certMux.RLock()
 		err := c.httpsX509.VerifyHostname(*hello.Info.ServerName)
+		c.certMux.RUnlock()
 		if err == nil {
 			return true
 		} else {
@@ -460,11 +699,11 @@ func parseTlsPacket(r io.Reader) (*tlshacks.ClientHelloInfo, bool) {
 	return hello, true
 }
 
-// ProvisionHttps provides a tls cert and key to the thread which serves the
+// ServeHttps provides a tls cert and key to the thread which serves the
 // grpcweb endpoints, allowing it to serve with https.  Note that https will
 // not be usable until this has been called at least once, unblocking the
-// listenHTTP func in ServeWithWeb.  Future calls will be handled by the
-// startUpdateCertificate thread.
+// listenHTTP func in ServeWithWeb.  The certificate can later be replaced
+// without downtime using UpdateHttpsCertificate.
 func (c *ProtoComms) ServeHttps(keyPair tls.Certificate) error {
 	if c.mux == nil {
 		return errors.New("mux does not exist; is https enabled?")
@@ -477,24 +716,28 @@ func (c *ProtoComms) ServeHttps(keyPair tls.Certificate) error {
 	httpL := c.mux.Match(c.matchWebTls)
 
 	grpcServer := c.grpcServer
-	var parsedLeafCert *x509.Certificate
-	var err error
 	if keyPair.Leaf == nil {
-		parsedLeafCert, err = x509.ParseCertificate(keyPair.Certificate[0])
+		var err error
+		keyPair.Leaf, err = x509.ParseCertificate(keyPair.Certificate[0])
 		if err != nil {
 			jww.FATAL.Panicf("Failed to load TLS certificate: %+v", err)
 		}
-	} else {
-		parsedLeafCert = keyPair.Leaf
 	}
+	parsedLeafCert := keyPair.Leaf
 
+	c.certMux.Lock()
+	c.httpsCertificate = &keyPair
 	c.httpsX509 = parsedLeafCert
+	c.certMux.Unlock()
+
+	httpsServer := &http.Server{
+		Handler: c.newWebHandler(grpcServer),
+	}
+	c.httpsServer = httpsServer
 
 	listenHTTPS := func(l net.Listener) {
 		jww.INFO.Printf("Starting HTTP listener on GRPC endpoints: %+v",
 			grpcweb.ListGRPCResources(grpcServer))
-		httpsServer := grpcweb.WrapServer(grpcServer,
-			grpcweb.WithOriginFunc(func(origin string) bool { return true }))
 
 		// Configure TLS for this listener, using the config from
 		// http.ServeTLS
@@ -504,8 +747,7 @@ func (c *ProtoComms) ServeHttps(keyPair tls.Certificate) error {
 		// We use the GetCertificate field and a function which returns
 		// c.httpsCertificate wrapped by a ReadLock to allow for future
 		// changes to the certificate without downtime
-		c.httpsCertificate = &keyPair
-		tlsConf.Certificates = []tls.Certificate{keyPair}
+		tlsConf.GetCertificate = c.getHttpsCertificate
 
 		var serverName string
 		serverName = parsedLeafCert.DNSNames[0]
@@ -514,11 +756,7 @@ func (c *ProtoComms) ServeHttps(keyPair tls.Certificate) error {
 		tlsLis := tls.NewListener(l, tlsConf)
 		jww.WARN.Printf("Starting HTTPS server!")
 
-		c.httpsServer = &http.Server{
-			Handler: httpsServer,
-		}
-
-		if err := c.httpsServer.Serve(tlsLis); err != nil {
+		if err := httpsServer.Serve(tlsLis); err != nil {
 			// Cannot panic here due to shared net.Listener
 			jww.WARN.Printf("HTTPS listener shutting down: %+v", err)
 		}
@@ -530,33 +768,157 @@ func (c *ProtoComms) ServeHttps(keyPair tls.Certificate) error {
 	return nil
 }
 
-// Shutdown performs a graceful shutdown of the local server.
-func (c *ProtoComms) Shutdown() {
-	// Also handles closing of net.Listener
-	if c.httpsServer != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		err := c.httpsServer.Shutdown(ctx)
-		if err != nil {
-			jww.WARN.Printf("Failed to shutdown http server: %+v", err)
+// ForcedShutdownError is returned by Shutdown when servers had to be closed
+// before their in-flight requests finished.
+type ForcedShutdownError struct {
+	// Names of the forcibly closed servers ("grpc", "http" or "https")
+	Servers []string
+}
+
+// Error lists the servers which were forcibly closed.
+func (e *ForcedShutdownError) Error() string {
+	return fmt.Sprintf("Forcibly closed %s before in-flight requests "+
+		"finished", strings.Join(e.Servers, ", "))
+}
+
+// Shutdown performs a graceful shutdown of the local server. The gRPC, HTTP
+// and HTTPS servers and the port multiplexer stop accepting connections,
+// then in-flight requests are drained until ctx is done. Servers still
+// running at that point are forcibly closed and reported in a
+// *ForcedShutdownError. Returns nil if everything drained gracefully.
+func (c *ProtoComms) Shutdown(ctx context.Context) error {
+	// Tell health checking clients to go elsewhere while draining
+	c.getHealthServer().Shutdown()
+
+	// Stop accepting connections on the shared port. Without a mux, the gRPC
+	// server closes the listener itself when it is stopped.
+	if c.mux != nil {
+		c.mux.Close()
+		if err := c.netListener.Close(); err != nil {
+			jww.WARN.Printf("Failed to close listener: %+v", err)
 		}
-		cancel()
-	} else if c.httpServer != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		err := c.httpServer.Shutdown(ctx)
-		if err != nil {
-			jww.WARN.Printf("Failed to shutdown http server: %+v", err)
+	}
+
+	var forced []string
+	var forcedMux sync.Mutex
+	var wg sync.WaitGroup
+
+	// drain runs the graceful stop of a server and forcibly closes it if it
+	// does not complete before ctx is done
+	drain := func(name string, graceful func() error, force func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done := make(chan error, 1)
+			go func() { done <- graceful() }()
+
+			select {
+			case err := <-done:
+				if err == nil {
+					return
+				}
+				jww.WARN.Printf("Failed to gracefully shut down %s server: "+
+					"%+v", name, err)
+			case <-ctx.Done():
+			}
+
+			if err := force(); err != nil {
+				jww.WARN.Printf("Failed to close %s server: %+v", name, err)
+			}
+			forcedMux.Lock()
+			forced = append(forced, name)
+			forcedMux.Unlock()
+		}()
+	}
+
+	if c.grpcServer != nil {
+		grpcServer := c.grpcServer
+		drain("grpc",
+			func() error { grpcServer.GracefulStop(); return nil },
+			func() error { grpcServer.Stop(); return nil })
+	}
+	// httpShutdown only fails when ctx is done; errors from closing the
+	// already closed mux listeners are ignored
+	httpShutdown := func(srv *http.Server) func() error {
+		return func() error {
+			err := srv.Shutdown(ctx)
+			if err != nil && ctx.Err() == nil {
+				jww.DEBUG.Printf("Error closing HTTP listener: %+v", err)
+				return nil
+			}
+			return err
 		}
-		cancel()
-	} else {
-		c.grpcServer.GracefulStop()
+	}
+	if c.httpServer != nil {
+		drain("http", httpShutdown(c.httpServer), c.httpServer.Close)
+	}
+	if c.httpsServer != nil {
+		drain("https", httpShutdown(c.httpsServer), c.httpsServer.Close)
+	}
+	wg.Wait()
+
+	// Close the listener in case it was never served
+	if c.netListener != nil && c.mux == nil {
+		_ = c.netListener.Close()
 	}
 
 	// Close all Manager connections
 	c.DisconnectAll()
+	c.stopTokenCleanup()
 	c.grpcServer = nil
+	c.httpServer = nil
+	c.httpsServer = nil
 	c.netListener = nil
 	c.mux = nil
+
+	if len(forced) > 0 {
+		sort.Strings(forced)
+		jww.WARN.Printf("Comms server shut down, forcibly closed %v", forced)
+		return &ForcedShutdownError{Servers: forced}
+	}
 	jww.INFO.Printf("Comms server successfully shut down")
+	return nil
+}
+
+// startTokenCleanup removes expired tokens from the token map every TTL in
+// the background until Shutdown is called, so that tokens of abandoned
+// handshakes do not wait for the next handshake to be removed. Replaces any
+// cleanup already running.
+func (c *ProtoComms) startTokenCleanup() {
+	c.tokenCleanupMux.Lock()
+	defer c.tokenCleanupMux.Unlock()
+	if c.tokenCleanupStop != nil {
+		close(c.tokenCleanupStop)
+	}
+
+	interval := c.serverOpts.TokenParams.TTL
+	if interval <= 0 {
+		interval = token.GetDefaultMapParams().TTL
+	}
+	stop := make(chan struct{})
+	c.tokenCleanupStop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				c.tokens.Cleanup()
+			}
+		}
+	}()
+}
+
+// stopTokenCleanup stops the cleanup started by startTokenCleanup, if any.
+func (c *ProtoComms) stopTokenCleanup() {
+	c.tokenCleanupMux.Lock()
+	defer c.tokenCleanupMux.Unlock()
+	if c.tokenCleanupStop != nil {
+		close(c.tokenCleanupStop)
+		c.tokenCleanupStop = nil
+	}
 }
 
 // Stringer method
@@ -571,15 +933,27 @@ func (c *ProtoComms) setPrivateKey(data []byte) error {
 		return errors.Errorf("Failed to form private key file from data at %s: %+v", data, err)
 	}
 
+	c.certMux.Lock()
 	c.privateKey = key
+	c.certMux.Unlock()
 	return nil
 }
 
 // GetPrivateKey is the getter for local server's private key.
 func (c *ProtoComms) GetPrivateKey() *rsa.PrivateKey {
+	c.certMux.RLock()
+	defer c.certMux.RUnlock()
 	return c.privateKey
 }
 
+// getPubKeyPem returns the PEM encoded certificate sent to hosts during the
+// handshake.
+func (c *ProtoComms) getPubKeyPem() []byte {
+	c.certMux.RLock()
+	defer c.certMux.RUnlock()
+	return c.pubKeyPem
+}
+
 // Send sets up or recovers the Host's connection,
 // then runs the given transmit function.
 func (c *ProtoComms) Send(host *Host, f func(conn Connection) (*any.Any,
@@ -608,10 +982,37 @@ func (c *ProtoComms) Stream(host *Host, f func(conn Connection) (
 	return c.transmit(host, f)
 }
 
-// returns true if the connection error is one of the connection errors which
-// should be retried
-func isConnError(err error) bool {
-	return strings.Contains(err.Error(), "context deadline exceeded") ||
-		strings.Contains(err.Error(), "connection refused") ||
-		strings.Contains(err.Error(), "host disconnected")
+// SendWithContext sets up or recovers the Host's connection, then runs the
+// given transmit function with the context. Connecting, authenticating and
+// retrying stop once the context is canceled or its deadline passes, in which
+// case the returned error wraps the context error. The transmit function
+// should derive its messaging context from the given one, e.g. using
+// Host.GetMessagingContextFrom.
+func (c *ProtoComms) SendWithContext(ctx context.Context, host *Host,
+	f func(ctx context.Context, conn Connection) (*any.Any, error)) (
+	result *any.Any, err error) {
+
+	jww.TRACE.Printf("Attempting to send to host: %s", host)
+	fSh := func(ctx context.Context, conn Connection) (interface{}, error) {
+		return f(ctx, conn)
+	}
+
+	anyFace, err := c.transmitWithContext(ctx, host, fSh)
+	if err != nil {
+		return nil, err
+	}
+
+	return anyFace.(*any.Any), err
+}
+
+// StreamWithContext sets up or recovers the Host's connection, then runs the
+// given Stream function with the context, giving up as SendWithContext does.
+// Streams opened with the context are closed when it ends.
+func (c *ProtoComms) StreamWithContext(ctx context.Context, host *Host,
+	f func(ctx context.Context, conn Connection) (interface{}, error)) (
+	client interface{}, err error) {
+
+	// Ensure the connection is running
+	jww.TRACE.Printf("Attempting to stream to host: %s", host)
+	return c.transmitWithContext(ctx, host, f)
 }
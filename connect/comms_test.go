@@ -8,10 +8,18 @@
 package connect
 
 import (
+	"bytes"
+	"context"
 	"github.com/golang/protobuf/ptypes/any"
 	"github.com/pkg/errors"
+	pb "gitlab.com/xx_network/comms/messages"
+	"gitlab.com/xx_network/comms/testkeys"
 	"gitlab.com/xx_network/primitives/id"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"net"
 	"testing"
+	"time"
 )
 
 // Test that trying to send to a host with no address fails
@@ -34,3 +42,298 @@ func TestSendNoAddressFails(t *testing.T) {
 		t.Errorf("Send function should have errored with address error.")
 	}
 }
+
+// Tests that UpdateCertificate replaces the certificate used for new TLS
+// handshakes and the signing key without dropping existing connections or
+// registered services.
+func TestProtoComms_UpdateCertificate(t *testing.T) {
+	addr := "0.0.0.0:11432"
+	nodeCert := testkeys.LoadFromPath(testkeys.GetNodeCertPath())
+	nodeKey := testkeys.LoadFromPath(testkeys.GetNodeKeyPath())
+	gwCert := testkeys.LoadFromPath(testkeys.GetGatewayCertPath())
+	gwKey := testkeys.LoadFromPath(testkeys.GetGatewayKeyPath())
+
+	pc, err := StartCommServer(id.NewIdFromString("rotate", id.Node, t),
+		addr, nodeCert, nodeKey, nil)
+	if err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	defer pc.Shutdown(context.Background())
+	pb.RegisterGenericServer(pc.GetServer(), &TestGenericServer{resp: "rotated"})
+	pc.Serve()
+
+	params := GetDefaultHostParams()
+	params.MaxRetries = 1
+	oldHost, err := NewHost(id.NewIdFromString("old", id.User, t), addr,
+		nodeCert, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = oldHost.Connect(); err != nil {
+		t.Fatalf("Failed to connect with original certificate: %+v", err)
+	}
+	defer oldHost.Disconnect()
+
+	oldKey := pc.GetPrivateKey()
+	err = pc.UpdateCertificate(gwCert, gwKey)
+	if err != nil {
+		t.Fatalf("UpdateCertificate returned an error: %+v", err)
+	}
+	if pc.GetPrivateKey().Equal(oldKey) {
+		t.Errorf("UpdateCertificate did not replace the private key")
+	}
+	if !bytes.Equal(pc.getPubKeyPem(), gwCert) {
+		t.Errorf("UpdateCertificate did not replace the handshake " +
+			"certificate")
+	}
+
+	// The existing connection continues to work
+	ctx, cancel := oldHost.GetMessagingContext()
+	defer cancel()
+	resp, err := pb.NewGenericClient(oldHost.connection.GetGrpcConn()).
+		RequestToken(ctx, &pb.Ping{})
+	if err != nil {
+		t.Fatalf("Existing connection failed after rotation: %+v", err)
+	}
+	if string(resp.Token) != "rotated" {
+		t.Errorf("Unexpected response: %s", resp.Token)
+	}
+
+	// New handshakes use the new certificate
+	newHost, err := NewHost(id.NewIdFromString("new", id.User, t), addr,
+		gwCert, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = newHost.Connect(); err != nil {
+		t.Fatalf("Failed to connect with rotated certificate: %+v", err)
+	}
+	defer newHost.Disconnect()
+
+	staleHost, err := NewHost(id.NewIdFromString("stale", id.User, t), addr,
+		nodeCert, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = staleHost.Connect(); err == nil {
+		staleHost.Disconnect()
+		t.Errorf("Connected to server with a certificate that was rotated out")
+	}
+}
+
+// slowGenericServer is a Generic server whose RequestToken blocks for delay
+// after signalling that it has started.
+type slowGenericServer struct {
+	delay   time.Duration
+	started chan struct{}
+	pb.UnimplementedGenericServer
+}
+
+func (s *slowGenericServer) RequestToken(context.Context, *pb.Ping) (*pb.AssignToken, error) {
+	s.started <- struct{}{}
+	time.Sleep(s.delay)
+	return &pb.AssignToken{Token: []byte("slow")}, nil
+}
+
+// startSlowServer starts a server with a slowGenericServer and sends a
+// RequestToken to it, returning once the request is in flight. The result of
+// the request is sent on the returned channel.
+func startSlowServer(t *testing.T, addr string, delay time.Duration,
+	withWeb bool) (*ProtoComms, chan error) {
+	pc, err := StartCommServer(id.NewIdFromString("slow", id.Node, t), addr,
+		nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	srv := &slowGenericServer{delay: delay, started: make(chan struct{}, 1)}
+	pb.RegisterGenericServer(pc.GetServer(), srv)
+	if withWeb {
+		pc.ServeWithWeb()
+	} else {
+		pc.Serve()
+	}
+
+	conn, err := grpc.Dial(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := pb.NewGenericClient(conn).RequestToken(
+			context.Background(), &pb.Ping{})
+		result <- err
+	}()
+
+	select {
+	case <-srv.started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Request never reached the server")
+	}
+	return pc, result
+}
+
+// Tests that Shutdown waits for in-flight requests to finish before the
+// context deadline.
+func TestProtoComms_Shutdown_Drains(t *testing.T) {
+	for _, withWeb := range []bool{false, true} {
+		addr := "127.0.0.1:11434"
+		pc, result := startSlowServer(t, addr, 200*time.Millisecond, withWeb)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := pc.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			t.Errorf("Shutdown did not drain gracefully (web: %t): %+v",
+				withWeb, err)
+		}
+		if err = <-result; err != nil {
+			t.Errorf("In-flight request failed (web: %t): %+v", withWeb, err)
+		}
+
+		// The port is released
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Fatalf("Listener was not closed (web: %t): %+v", withWeb, err)
+		}
+		_ = lis.Close()
+	}
+}
+
+// Tests that Shutdown forcibly closes servers with requests still running
+// when the context deadline is reached and reports them.
+func TestProtoComms_Shutdown_Forced(t *testing.T) {
+	pc, result := startSlowServer(t, "127.0.0.1:11435", 5*time.Second, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := pc.Shutdown(ctx)
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("Shutdown did not stop at the deadline: %s", time.Since(start))
+	}
+
+	var forced *ForcedShutdownError
+	if !errors.As(err, &forced) {
+		t.Fatalf("Expected a ForcedShutdownError, received: %+v", err)
+	}
+	if len(forced.Servers) != 1 || forced.Servers[0] != "grpc" {
+		t.Errorf("Unexpected forcibly closed servers: %v", forced.Servers)
+	}
+	if err = <-result; err == nil {
+		t.Errorf("In-flight request succeeded after a forced shutdown")
+	}
+}
+
+// Tests that a server removes expired tokens in the background, without a
+// new handshake, and stops doing so once shut down.
+func TestProtoComms_TokenCleanup(t *testing.T) {
+	opts := GetDefaultServerOptions()
+	opts.TokenParams.TTL = time.Second
+	pc, err := StartCommServerWithOptions(
+		id.NewIdFromString("cleanup", id.Node, t), "127.0.0.1:11447", nil,
+		nil, nil, opts)
+	if err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+
+	if _, err = pc.GenerateToken(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for pc.tokens.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expired token was not cleaned up")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if err = pc.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if pc.tokenCleanupStop != nil {
+		t.Errorf("Token cleanup was not stopped by Shutdown")
+	}
+	if err = pc.Restart(); err != nil {
+		t.Fatalf("Failed to restart: %+v", err)
+	}
+	defer pc.Shutdown(context.Background())
+	if pc.tokenCleanupStop == nil {
+		t.Errorf("Token cleanup was not started by Restart")
+	}
+}
+
+// sendContextKey is a context key used to check that the context of
+// SendWithContext reaches the transmit function.
+type sendContextKey struct{}
+
+// Tests that SendWithContext passes its context to the transmit function.
+func TestProtoComms_SendWithContext(t *testing.T) {
+	c := &ProtoComms{Manager: newManager()}
+	params := GetDefaultHostParams()
+	params.AuthEnabled = false
+	host, err := c.AddHost(id.NewIdFromString("ctxHost", id.Node, t),
+		ServerAddress, nil, params)
+	if err != nil {
+		t.Fatalf("Failed to add host: %+v", err)
+	}
+	defer host.Disconnect()
+
+	ctx := context.WithValue(context.Background(), sendContextKey{}, "value")
+	ret, err := c.SendWithContext(ctx, host,
+		func(ctx context.Context, conn Connection) (*any.Any, error) {
+			if ctx.Value(sendContextKey{}) != "value" {
+				t.Errorf("Context of the send did not reach the transmit " +
+					"function")
+			}
+			return &any.Any{TypeUrl: "sent"}, nil
+		})
+	if err != nil || ret.TypeUrl != "sent" {
+		t.Errorf("Failed to send: %v %+v", ret, err)
+	}
+}
+
+// Tests that SendWithContext and StreamWithContext give up connecting and
+// retrying once the context ends, instead of retrying up to MaxRetries.
+func TestProtoComms_SendWithContext_Deadline(t *testing.T) {
+	c := &ProtoComms{Manager: newManager()}
+	params := GetDefaultHostParams()
+	params.AuthEnabled = false
+	params.MaxRetries = 0
+	// Nothing listens on this address, so connecting is retried indefinitely
+	host, err := c.AddHost(id.NewIdFromString("unreachable", id.Node, t),
+		"127.0.0.1:11442", nil, params)
+	if err != nil {
+		t.Fatalf("Failed to add host: %+v", err)
+	}
+	defer host.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = c.SendWithContext(ctx, host,
+		func(context.Context, Connection) (*any.Any, error) {
+			t.Errorf("Transmit function called without a connection")
+			return nil, nil
+		})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected %v, received %+v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Send took %s to give up after the deadline", elapsed)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = c.StreamWithContext(ctx, host,
+		func(context.Context, Connection) (interface{}, error) {
+			t.Errorf("Stream function called after cancellation")
+			return nil, nil
+		})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected %v, received %+v", context.Canceled, err)
+	}
+}
@@ -1,6 +1,7 @@
 package connect
 
 import (
+	"context"
 	"crypto/x509"
 	"git.xx.network/elixxir/grpc-web-go-client/grpcweb"
 	jww "github.com/spf13/jwalterweatherman"
@@ -59,6 +60,9 @@ type Connection interface {
 
 // clientConnHelpers holds private helper methods exposed on the connection object
 type clientConnHelpers interface {
+	// connectWithContext connects as Connect does, giving up once the
+	// context is done
+	connectWithContext(ctx context.Context) error
 	isAlive() bool
 	disconnect()
 }
@@ -0,0 +1,187 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// Contains functionality for bounding the connections open in a Manager
+
+package connect
+
+import (
+	"github.com/pkg/errors"
+	jww "github.com/spf13/jwalterweatherman"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Causes reported to observers when the Manager closes a connection
+var (
+	// ErrHostRemoved is the cause of disconnecting a Host removed from the
+	// Manager.
+	ErrHostRemoved = errors.New("host removed from manager")
+
+	// ErrCertificateUpdated is the cause of disconnecting a Host whose
+	// certificate was replaced, so that it reconnects with the new one.
+	ErrCertificateUpdated = errors.New("host certificate updated")
+
+	// ErrConnectionEvicted is the cause of disconnecting the least recently
+	// used Host when the connection limit is exceeded.
+	ErrConnectionEvicted = errors.New("connection evicted by connection limit")
+
+	// ErrConnectionIdle is the cause of disconnecting a Host which has not
+	// been used within the idle timeout.
+	ErrConnectionIdle = errors.New("connection closed after idle timeout")
+)
+
+// ConnectionLimits bounds the connections kept open by a Manager. Hosts
+// whose connections are closed stay in the Manager and reconnect on their
+// next send.
+type ConnectionLimits struct {
+	// Maximum number of simultaneously open connections. When exceeded, the
+	// least recently used connections are closed. Hosts in the middle of a
+	// send are skipped, so the limit may be exceeded briefly. Zero leaves
+	// the number unbounded.
+	MaxOpenConnections int
+
+	// Connections without a send for this long are closed. Zero disables the
+	// idle timeout.
+	IdleTimeout time.Duration
+}
+
+// SetConnectionLimits replaces the connection limits of the Manager and
+// applies them immediately.
+func (m *Manager) SetConnectionLimits(limits ConnectionLimits) {
+	m.limitsMux.Lock()
+	m.limits = limits
+	if m.idleStop != nil {
+		close(m.idleStop)
+		m.idleStop = nil
+	}
+	if limits.IdleTimeout > 0 {
+		m.idleStop = make(chan struct{})
+		go m.idleMonitor(limits.IdleTimeout, m.idleStop)
+	}
+	m.limitsMux.Unlock()
+
+	m.enforceConnectionLimit()
+}
+
+// GetConnectionLimits returns the connection limits of the Manager.
+func (m *Manager) GetConnectionLimits() ConnectionLimits {
+	m.limitsMux.RLock()
+	defer m.limitsMux.RUnlock()
+	return m.limits
+}
+
+// idleMonitor periodically closes idle connections until stop is closed.
+func (m *Manager) idleMonitor(idleTimeout time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.closeConnections(time.Now().Add(-idleTimeout).UnixNano(), 0)
+		}
+	}
+}
+
+// enforceConnectionLimit closes the least recently used connections if the
+// limit is exceeded. It is called while a Host is locked, so the connections
+// are closed in a separate goroutine, of which at most one runs at a time.
+// Calls made while it runs are recorded and handled by another pass.
+func (m *Manager) enforceConnectionLimit() {
+	if m.GetConnectionLimits().MaxOpenConnections <= 0 {
+		return
+	}
+
+	atomic.StoreUint32(&m.evictPending, 1)
+	if atomic.CompareAndSwapUint32(&m.evicting, 0, 1) {
+		go m.evictConnections()
+	}
+}
+
+// evictConnections enforces the connection limit until no passes are
+// pending. It must only be called after setting evicting.
+func (m *Manager) evictConnections() {
+	for {
+		for atomic.SwapUint32(&m.evictPending, 0) == 1 {
+			maxOpen := m.GetConnectionLimits().MaxOpenConnections
+			if maxOpen > 0 {
+				m.closeConnections(0, maxOpen)
+			}
+		}
+		atomic.StoreUint32(&m.evicting, 0)
+
+		// A pass may have been requested after the last check but before
+		// evicting was cleared, in which case it did not start a goroutine
+		if atomic.LoadUint32(&m.evictPending) == 0 ||
+			!atomic.CompareAndSwapUint32(&m.evicting, 0, 1) {
+			return
+		}
+	}
+}
+
+// closeConnections closes the connections last used before idleBefore, in
+// Unix nanoseconds, and then, if maxOpen is non-zero, the least recently used
+// connections until at most maxOpen remain open. Hosts in the middle of a
+// connection are skipped.
+func (m *Manager) closeConnections(idleBefore int64, maxOpen int) {
+	m.mux.RLock()
+	hosts := make([]*Host, 0, len(m.connections))
+	for _, host := range m.connections {
+		hosts = append(hosts, host)
+	}
+	m.mux.RUnlock()
+
+	// Find the open connections, least recently used first. Hosts which are
+	// connecting hold the write lock and are skipped.
+	type openHost struct {
+		host     *Host
+		lastUsed int64
+	}
+	open := make([]openHost, 0, len(hosts))
+	for _, host := range hosts {
+		if !host.connectionMux.TryRLock() {
+			continue
+		}
+		if host.connectionOpen {
+			open = append(open,
+				openHost{host, atomic.LoadInt64(&host.lastUsed)})
+		}
+		host.connectionMux.RUnlock()
+	}
+	sort.Slice(open, func(i, j int) bool {
+		return open[i].lastUsed < open[j].lastUsed
+	})
+
+	numOpen := len(open)
+	for _, oh := range open {
+		var cause error
+		if oh.lastUsed < idleBefore {
+			cause = ErrConnectionIdle
+		} else if maxOpen > 0 && numOpen > maxOpen {
+			cause = ErrConnectionEvicted
+		} else {
+			continue
+		}
+
+		// Skip hosts which are in use; a failed TryLock means a send or
+		// connection is in progress
+		if !oh.host.connectionMux.TryLock() {
+			continue
+		}
+		if oh.host.connectionOpen &&
+			atomic.LoadInt64(&oh.host.lastUsed) == oh.lastUsed {
+			jww.DEBUG.Printf("Closing connection to host %s: %s",
+				oh.host.id, cause)
+			oh.host.disconnectWithCause(cause)
+			numOpen--
+		}
+		oh.host.connectionMux.Unlock()
+	}
+}
@@ -0,0 +1,139 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"gitlab.com/xx_network/primitives/id"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// isOpen returns whether the Host has an open connection.
+func isOpen(h *Host) bool {
+	h.connectionMux.RLock()
+	defer h.connectionMux.RUnlock()
+	return h.connectionOpen
+}
+
+// waitClosed waits for the connection of the Host to be closed.
+func waitClosed(t *testing.T, h *Host) {
+	deadline := time.Now().Add(5 * time.Second)
+	for isOpen(h) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Connection to host %s was not closed", h.id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// connectHosts adds and connects the given number of hosts to the Manager.
+func connectHosts(t *testing.T, m *Manager, num int) []*Host {
+	hosts := make([]*Host, num)
+	for i := range hosts {
+		hid := id.NewIdFromString("limited"+strconv.Itoa(i), id.Node, t)
+		h, err := m.AddHost(hid, ServerAddress, nil, GetDefaultHostParams())
+		if err != nil {
+			t.Fatalf("Failed to add host %d: %+v", i, err)
+		}
+		if err = h.Connect(); err != nil {
+			t.Fatalf("Failed to connect host %d: %+v", i, err)
+		}
+		hosts[i] = h
+		time.Sleep(time.Millisecond)
+	}
+	return hosts
+}
+
+// Tests that RemoveHost closes the connection of the removed Host.
+func TestManager_RemoveHost_Disconnects(t *testing.T) {
+	m := newManager()
+	h := connectHosts(t, m, 1)[0]
+
+	m.RemoveHost(h.id)
+	if isOpen(h) || h.isAlive() {
+		t.Errorf("RemoveHost did not close the connection")
+	}
+}
+
+// Tests that the least recently used connections are closed when the
+// connection limit is exceeded.
+func TestManager_SetConnectionLimits_MaxOpen(t *testing.T) {
+	m := newManager()
+	m.SetConnectionLimits(ConnectionLimits{MaxOpenConnections: 2})
+	hosts := connectHosts(t, m, 3)
+
+	waitClosed(t, hosts[0])
+	if !isOpen(hosts[1]) || !isOpen(hosts[2]) {
+		t.Errorf("Most recently used connections were closed")
+	}
+	if _, ok := m.GetHost(hosts[0].id); !ok {
+		t.Errorf("Evicted host was removed from the manager")
+	}
+
+	// Lowering the limit applies it immediately
+	m.SetConnectionLimits(ConnectionLimits{MaxOpenConnections: 1})
+	waitClosed(t, hosts[1])
+	if !isOpen(hosts[2]) {
+		t.Errorf("Most recently used connection was closed")
+	}
+	m.DisconnectAll()
+}
+
+// Tests that connections without sends are closed after the idle timeout.
+func TestManager_SetConnectionLimits_IdleTimeout(t *testing.T) {
+	m := newManager()
+	m.SetConnectionLimits(ConnectionLimits{IdleTimeout: 100 * time.Millisecond})
+	hosts := connectHosts(t, m, 2)
+
+	waitClosed(t, hosts[0])
+	waitClosed(t, hosts[1])
+	if _, ok := m.GetHost(hosts[0].id); !ok {
+		t.Errorf("Idle host was removed from the manager")
+	}
+
+	// Disabling the timeout stops closing connections
+	m.SetConnectionLimits(ConnectionLimits{})
+	if err := hosts[0].Connect(); err != nil {
+		t.Fatalf("Failed to reconnect: %+v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if !isOpen(hosts[0]) {
+		t.Errorf("Connection was closed with the idle timeout disabled")
+	}
+	m.DisconnectAll()
+}
+
+// Tests that connections opened while the limit is being enforced are not
+// missed, but handled once the running pass finishes.
+func TestManager_enforceConnectionLimit_DuringEviction(t *testing.T) {
+	m := newManager()
+
+	// Mark a pass as running, as if one was busy closing connections
+	atomic.StoreUint32(&m.evicting, 1)
+	m.SetConnectionLimits(ConnectionLimits{MaxOpenConnections: 2})
+	hosts := connectHosts(t, m, 3)
+	time.Sleep(100 * time.Millisecond)
+	if !isOpen(hosts[0]) {
+		t.Fatalf("Connection was closed while another pass was running")
+	}
+
+	// The running pass handles the requests made while it ran
+	m.evictConnections()
+	if isOpen(hosts[0]) {
+		t.Errorf("Connection opened during the pass was not evicted")
+	}
+	if !isOpen(hosts[1]) || !isOpen(hosts[2]) {
+		t.Errorf("Most recently used connections were closed")
+	}
+	if atomic.LoadUint32(&m.evicting) != 0 {
+		t.Errorf("Eviction is still marked as running")
+	}
+	m.DisconnectAll()
+}
@@ -58,6 +58,7 @@ func TestWebConnection(t *testing.T) {
 			Manager:          newManager(),
 			listeningAddress: addr,
 			grpcServer:       s,
+			serverOpts:       GetDefaultServerOptions(),
 		}
 
 		err = pc.Restart()
@@ -147,7 +148,7 @@ func TestWebConnection_TLS(t *testing.T) {
 	}
 	pc.ServeWithWeb()
 	time.Sleep(time.Second)
-	pc.Shutdown()
+	pc.Shutdown(context.Background())
 
 	hostParams := GetDefaultHostParams()
 	hostParams.ConnectionType = Web
@@ -228,7 +229,7 @@ func TestWebConnection_TLS(t *testing.T) {
 				t.Errorf("Did not receive cert: %+v", err)
 			}
 
-			pc.Shutdown()
+			pc.Shutdown(context.Background())
 			h.disconnect()
 			grpcHost.disconnect()
 		})
@@ -323,7 +324,7 @@ func TestServeWeb_Matchers(t *testing.T) {
 			}
 
 			h.disconnect()
-			pc.Shutdown()
+			pc.Shutdown(context.Background())
 		})
 	}
 	TestingOnlyInsecureTLSVerify = false
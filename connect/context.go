@@ -46,6 +46,10 @@ func GetAddressFromContext(ctx context.Context) (address string, port string, er
 	if !ok {
 		return address, port, errors.New("Could not retrieve peer information from context")
 	}
+	// Unix domain socket peers have no host or port
+	if info.Addr.Network() == "unix" {
+		return info.Addr.String(), port, nil
+	}
 	address, port, err = net.SplitHostPort(info.Addr.String())
 	return
 }
@@ -0,0 +1,72 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// Contains the optional ECC identity keys used to sign handshakes
+
+package connect
+
+import (
+	pb "gitlab.com/xx_network/comms/messages"
+	"gitlab.com/xx_network/crypto/signature/ec"
+)
+
+// SetEccPrivateKey sets the Ed25519 identity key of the ProtoComms, used to
+// sign messages for hosts whose HostParams.SignatureScheme is ED25519.
+func (c *ProtoComms) SetEccPrivateKey(key *ec.PrivateKey) {
+	c.certMux.Lock()
+	defer c.certMux.Unlock()
+	c.eccPrivateKey = key
+}
+
+// GetEccPrivateKey returns the Ed25519 identity key of the ProtoComms, or nil
+// if none is set.
+func (c *ProtoComms) GetEccPrivateKey() *ec.PrivateKey {
+	c.certMux.RLock()
+	defer c.certMux.RUnlock()
+	return c.eccPrivateKey
+}
+
+// SetAllowedSignatureSchemes restricts the signature schemes accepted when
+// verifying signed messages from hosts. By default, every scheme is allowed.
+func (c *ProtoComms) SetAllowedSignatureSchemes(schemes ...pb.SignatureScheme) {
+	allowed := make(map[pb.SignatureScheme]struct{}, len(schemes))
+	for _, scheme := range schemes {
+		allowed[scheme] = struct{}{}
+	}
+
+	c.schemesMux.Lock()
+	defer c.schemesMux.Unlock()
+	c.allowedSchemes = allowed
+}
+
+// IsSignatureSchemeAllowed returns true if signatures of the given scheme are
+// accepted.
+func (c *ProtoComms) IsSignatureSchemeAllowed(scheme pb.SignatureScheme) bool {
+	c.schemesMux.RLock()
+	defer c.schemesMux.RUnlock()
+	if c.allowedSchemes == nil {
+		return true
+	}
+	_, ok := c.allowedSchemes[scheme]
+	return ok
+}
+
+// SetEccPublicKey sets the Ed25519 identity key of the Host, used to verify
+// its ED25519 signatures.
+func (h *Host) SetEccPublicKey(key *ec.PublicKey) {
+	h.keyMux.Lock()
+	defer h.keyMux.Unlock()
+	h.eccPublicKey = key
+}
+
+// GetEccPublicKey returns the Ed25519 identity key of the Host, or nil if
+// none is set.
+func (h *Host) GetEccPublicKey() *ec.PublicKey {
+	h.keyMux.RLock()
+	defer h.keyMux.RUnlock()
+	return h.eccPublicKey
+}
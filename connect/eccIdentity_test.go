@@ -0,0 +1,117 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"gitlab.com/xx_network/comms/connect/token"
+	pb "gitlab.com/xx_network/comms/messages"
+	"gitlab.com/xx_network/comms/testkeys"
+	"gitlab.com/xx_network/crypto/csprng"
+	"gitlab.com/xx_network/crypto/signature/ec"
+	"gitlab.com/xx_network/primitives/id"
+	"testing"
+)
+
+// newEccTestComms returns a ProtoComms with RSA and ECC keys and a Host for
+// itself which signs with the given scheme.
+func newEccTestComms(t *testing.T, scheme pb.SignatureScheme) (*ProtoComms,
+	*Host) {
+	testId := id.NewIdFromString("ecc", id.Node, t)
+	comm := &ProtoComms{
+		networkId: testId,
+		tokens:    token.NewMap(),
+		Manager:   newManager(),
+	}
+	err := comm.setPrivateKey(testkeys.LoadFromPath(testkeys.GetNodeKeyPath()))
+	if err != nil {
+		t.Fatalf("Failed to set private key: %+v", err)
+	}
+	eccKey, err := ec.NewKeyPair(csprng.NewSystemRNG())
+	if err != nil {
+		t.Fatalf("Failed to generate ECC key: %+v", err)
+	}
+	comm.SetEccPrivateKey(eccKey)
+
+	params := GetDefaultHostParams()
+	params.SignatureScheme = scheme
+	host, err := comm.AddHost(testId, "test",
+		testkeys.LoadFromPath(testkeys.GetNodeCertPath()), params)
+	if err != nil {
+		t.Fatalf("Failed to add host: %+v", err)
+	}
+	host.SetEccPublicKey(eccKey.GetPublic())
+	return comm, host
+}
+
+// packToken generates a token and packs it signed for the Host.
+func packToken(t *testing.T, comm *ProtoComms,
+	host *Host) *pb.AuthenticatedMessage {
+	tokenBytes, _ := comm.GenerateToken()
+	msg, err := comm.PackAuthenticatedMessage(
+		&pb.AssignToken{Token: tokenBytes}, host, true)
+	if err != nil {
+		t.Fatalf("Failed to pack message: %+v", err)
+	}
+	return msg
+}
+
+// Tests that a token signed with the Ed25519 identity key is validated and
+// that the message states the scheme.
+func TestProtoComms_ValidateToken_Ed25519(t *testing.T) {
+	comm, host := newEccTestComms(t, pb.SignatureScheme_ED25519)
+
+	msg := packToken(t, comm, host)
+	if msg.Scheme != pb.SignatureScheme_ED25519 {
+		t.Errorf("Unexpected scheme.\nexpected: %s\nreceived: %s",
+			pb.SignatureScheme_ED25519, msg.Scheme)
+	}
+	if err := comm.ValidateToken(msg); err != nil {
+		t.Errorf("Failed to validate ED25519 token: %+v", err)
+	}
+
+	// A signature of the wrong scheme does not verify
+	msg = packToken(t, comm, host)
+	msg.Scheme = pb.SignatureScheme_RSA
+	if err := comm.ValidateToken(msg); err == nil {
+		t.Errorf("Validated ED25519 signature as RSA")
+	}
+
+	// Without the public key of the host, the signature cannot be verified
+	msg = packToken(t, comm, host)
+	host.SetEccPublicKey(nil)
+	if err := comm.ValidateToken(msg); err == nil {
+		t.Errorf("Validated ED25519 signature without a public key")
+	}
+}
+
+// Tests that SetAllowedSignatureSchemes rejects signatures of other schemes.
+func TestProtoComms_SetAllowedSignatureSchemes(t *testing.T) {
+	comm, host := newEccTestComms(t, pb.SignatureScheme_ED25519)
+	comm.SetAllowedSignatureSchemes(pb.SignatureScheme_RSA)
+
+	if err := comm.ValidateToken(packToken(t, comm, host)); err == nil {
+		t.Errorf("Validated a signature of a disallowed scheme")
+	}
+
+	host.params.SignatureScheme = pb.SignatureScheme_RSA
+	if err := comm.ValidateToken(packToken(t, comm, host)); err != nil {
+		t.Errorf("Failed to validate a signature of an allowed scheme: %+v",
+			err)
+	}
+}
+
+// Tests that signing with ED25519 fails without an ECC private key.
+func TestProtoComms_signMessage_NoEccKey(t *testing.T) {
+	comm, host := newEccTestComms(t, pb.SignatureScheme_ED25519)
+	comm.SetEccPrivateKey(nil)
+
+	_, err := comm.PackAuthenticatedMessage(&pb.Ping{}, host, true)
+	if err == nil {
+		t.Errorf("Signed without an ECC private key")
+	}
+}
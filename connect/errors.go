@@ -0,0 +1,311 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// Contains the classes of errors returned by comms and their mapping to and
+// from gRPC status codes
+
+package connect
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"syscall"
+)
+
+// Sentinel errors classifying the failures of sending to a Host. Returned
+// errors wrap them with context, so they are matched with errors.Is. See also
+// ErrAuthFailed, ErrPermissionDenied, ErrProxyError and
+// ErrTooManyProxyErrors.
+var (
+	// ErrBlankAddress is returned when sending to a Host without an address.
+	ErrBlankAddress = errors.New(
+		"Host address is blank, host might be receive only.")
+
+	// ErrCoolOff is the class of errors returned when sends to a Host are
+	// stopped to let it recover. See ErrCircuitOpen.
+	ErrCoolOff = errors.New("Host is in cool down. Cannot connect.")
+
+	// ErrCircuitOpen is returned without dialing when sending to a Host whose
+	// circuit breaker is open. It is in the ErrCoolOff class.
+	ErrCircuitOpen = newClassError(ErrCoolOff, "circuit breaker is open")
+
+	// ErrConnectFailed is returned when all attempts to connect to a Host
+	// have failed.
+	ErrConnectFailed = errors.New("failed to connect to host")
+
+	// ErrHostDisconnected is returned when the connection to a Host is down
+	// or is lost during a send. Sends failing with it are retried.
+	ErrHostDisconnected = errors.New("host disconnected")
+
+	// ErrHandshakeFailed is returned when the token handshake with a Host
+	// fails while connecting for a send. The errors also unwrap to the error
+	// of the handshake, keeping its class.
+	ErrHandshakeFailed = errors.New("failed to authenticate with host")
+)
+
+// handshakeError is an error of the token handshake, in the
+// ErrHandshakeFailed class as well as the class of the error itself.
+type handshakeError struct {
+	err error
+}
+
+// Error returns the message of the error of the handshake.
+func (e *handshakeError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the error of the handshake.
+func (e *handshakeError) Unwrap() error {
+	return e.err
+}
+
+// Is returns true for ErrHandshakeFailed.
+func (e *handshakeError) Is(target error) bool {
+	return target == ErrHandshakeFailed
+}
+
+// Domain of the gRPC ErrorInfo details identifying the class of an error
+const errorInfoDomain = "xx.network"
+
+// errorClass is the gRPC status code and ErrorInfo reason an error class is
+// sent with.
+type errorClass struct {
+	err    error
+	code   codes.Code
+	reason string
+}
+
+// Known error classes. Specific classes come before the classes they belong
+// to, as the first match is used.
+var errorClasses = []errorClass{
+	{ErrMessageReplayed, codes.AlreadyExists, "MESSAGE_REPLAYED"},
+	{ErrMessageOutsideWindow, codes.FailedPrecondition,
+		"MESSAGE_OUTSIDE_WINDOW"},
+	{ErrMissingReplayProtection, codes.FailedPrecondition,
+		"MISSING_REPLAY_PROTECTION"},
+	{ErrAuthFailed, codes.Unauthenticated, "AUTH_FAILED"},
+	{ErrPermissionDenied, codes.PermissionDenied, "PERMISSION_DENIED"},
+	{ErrBlankAddress, codes.FailedPrecondition, "BLANK_ADDRESS"},
+	{ErrCircuitOpen, codes.Unavailable, "CIRCUIT_OPEN"},
+	{ErrCoolOff, codes.Unavailable, "COOL_OFF"},
+	{ErrConnectFailed, codes.Unavailable, "CONNECT_FAILED"},
+	{ErrHostDisconnected, codes.Unavailable, "HOST_DISCONNECTED"},
+	{ErrTooManyProxyErrors, codes.Unavailable, "TOO_MANY_PROXY_ERRORS"},
+	{ErrProxyError, codes.Unavailable, "PROXY_ERROR"},
+}
+
+// classError is a sentinel error which belongs to a broader class, so that
+// errors.Is matches both.
+type classError struct {
+	msg   string
+	class error
+}
+
+// newClassError creates a sentinel error in the class whose message is the
+// message of the class followed by msg.
+func newClassError(class error, msg string) error {
+	return &classError{msg: class.Error() + ": " + msg, class: class}
+}
+
+// Error returns the message of the classError.
+func (e *classError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the class of the classError.
+func (e *classError) Unwrap() error {
+	return e.class
+}
+
+// RemoteError is an error returned by the remote end of a connection. It
+// keeps the gRPC status of the error and unwraps to its class, if known, so
+// it works with both status.FromError and errors.Is.
+type RemoteError struct {
+	status *status.Status
+	class  error
+	// Message of the error, including any context the status was wrapped in
+	msg string
+}
+
+// Error returns the message of the error.
+func (e *RemoteError) Error() string {
+	return e.msg
+}
+
+// GRPCStatus returns the gRPC status of the error.
+func (e *RemoteError) GRPCStatus() *status.Status {
+	return e.status
+}
+
+// Code returns the gRPC status code of the error.
+func (e *RemoteError) Code() codes.Code {
+	return e.status.Code()
+}
+
+// Unwrap returns the class of the error, nil if it is unknown.
+func (e *RemoteError) Unwrap() error {
+	return e.class
+}
+
+// ToGrpcStatus converts an error returned by a server handler into a gRPC
+// status error whose code and ErrorInfo details describe its class, so that
+// FromGrpcStatus can restore the class on the client. Errors which already
+// carry a status are returned unchanged. Returns nil for a nil error.
+func ToGrpcStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if st, ok := grpcStatus(err); ok {
+		if _, direct := err.(grpcStatusError); direct {
+			return err
+		}
+		// Keep the context the status was wrapped in
+		pbStatus := st.Proto()
+		pbStatus.Message = err.Error()
+		return status.ErrorProto(pbStatus)
+	}
+
+	code := codes.Unknown
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	}
+
+	st := status.New(code, err.Error())
+	for _, ec := range errorClasses {
+		if errors.Is(err, ec.err) {
+			st = status.New(ec.code, err.Error())
+			withInfo, infoErr := st.WithDetails(&errdetails.ErrorInfo{
+				Reason: ec.reason,
+				Domain: errorInfoDomain,
+			})
+			if infoErr == nil {
+				st = withInfo
+			}
+			break
+		}
+	}
+	return st.Err()
+}
+
+// FromGrpcStatus converts a gRPC status error returned by a client call into
+// a RemoteError classified by its ErrorInfo details or, for peers which do
+// not send them, by its code. Other errors are returned unchanged.
+func FromGrpcStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	var remoteErr *RemoteError
+	if errors.As(err, &remoteErr) {
+		return err
+	}
+	st, ok := grpcStatus(err)
+	if !ok || st.Code() == codes.OK {
+		return err
+	}
+	return &RemoteError{status: st, class: classifyStatus(st), msg: err.Error()}
+}
+
+// grpcStatusError is implemented by errors carrying a gRPC status.
+type grpcStatusError interface {
+	GRPCStatus() *status.Status
+}
+
+// grpcStatus returns the gRPC status of the first error in the chain which
+// carries one.
+func grpcStatus(err error) (*status.Status, bool) {
+	var se grpcStatusError
+	if !errors.As(err, &se) {
+		return nil, false
+	}
+	return se.GRPCStatus(), true
+}
+
+// classifyStatus returns the class of the error described by the gRPC
+// status, nil if it is unknown.
+func classifyStatus(st *status.Status) error {
+	for _, detail := range st.Details() {
+		info, ok := detail.(*errdetails.ErrorInfo)
+		if !ok || info.Domain != errorInfoDomain {
+			continue
+		}
+		for _, ec := range errorClasses {
+			if ec.reason == info.Reason {
+				return ec.err
+			}
+		}
+	}
+
+	// Gateways report proxy errors in the message of the status
+	if strings.Contains(st.Message(), ProxyError) {
+		return ErrProxyError
+	}
+
+	switch st.Code() {
+	case codes.Unauthenticated:
+		return ErrAuthFailed
+	case codes.PermissionDenied:
+		return ErrPermissionDenied
+	case codes.Unavailable:
+		return ErrHostDisconnected
+	case codes.DeadlineExceeded:
+		return context.DeadlineExceeded
+	case codes.Canceled:
+		return context.Canceled
+	default:
+		return nil
+	}
+}
+
+// errorClassName returns the reason of the class of the error, as sent in its
+// gRPC ErrorInfo details, or UNKNOWN if it is in no known class.
+func errorClassName(err error) string {
+	for _, ec := range errorClasses {
+		if errors.Is(err, ec.err) {
+			return ec.reason
+		}
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "DEADLINE_EXCEEDED"
+	case errors.Is(err, context.Canceled):
+		return "CANCELED"
+	default:
+		return unknownErrorClass
+	}
+}
+
+// returns true if the connection error is one of the connection errors which
+// should be retried. Errors which lost their type, such as those flattened to
+// text, are matched by their message.
+func isConnError(err error) bool {
+	if errors.Is(err, ErrHostDisconnected) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	return strings.Contains(err.Error(), "context deadline exceeded") ||
+		strings.Contains(err.Error(), "connection refused") ||
+		strings.Contains(err.Error(), "host disconnected")
+}
+
+// isRetryable returns true if a send which failed with the error may succeed
+// when retried after reconnecting and authenticating again.
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrMessageReplayed) ||
+		errors.Is(err, ErrMessageOutsideWindow) ||
+		errors.Is(err, ErrMissingReplayProtection) {
+		return false
+	}
+	return isConnError(err) || IsAuthError(err)
+}
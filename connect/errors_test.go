@@ -0,0 +1,153 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"gitlab.com/xx_network/primitives/id"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+// Tests that every error class survives the conversion to a gRPC status and
+// back, keeping its code and message.
+func TestToGrpcStatus_FromGrpcStatus(t *testing.T) {
+	for _, ec := range errorClasses {
+		err := errors.WithMessage(ec.err, "context")
+		statusErr := ToGrpcStatus(err)
+		if status.Code(statusErr) != ec.code {
+			t.Errorf("Unexpected code for %q.\nexpected: %s\nreceived: %s",
+				ec.err, ec.code, status.Code(statusErr))
+		}
+
+		remoteErr := FromGrpcStatus(statusErr)
+		if !errors.Is(remoteErr, ec.err) {
+			t.Errorf("Class %q was not restored: %+v", ec.err, remoteErr)
+		}
+		if st, ok := status.FromError(remoteErr); !ok ||
+			st.Code() != ec.code || st.Message() != err.Error() {
+			t.Errorf("Status of %q was not kept: %+v", ec.err, st)
+		}
+	}
+
+	if ToGrpcStatus(nil) != nil || FromGrpcStatus(nil) != nil {
+		t.Errorf("Nil errors were not returned as nil")
+	}
+	plain := errors.New("plain")
+	if FromGrpcStatus(plain) != plain {
+		t.Errorf("Error without a status was changed")
+	}
+	if status.Code(ToGrpcStatus(plain)) != codes.Unknown {
+		t.Errorf("Unclassified error did not map to %s", codes.Unknown)
+	}
+}
+
+// Tests that statuses without ErrorInfo details, such as those of older
+// peers, are classified by their code and message.
+func TestFromGrpcStatus_Code(t *testing.T) {
+	tests := []struct {
+		err   error
+		class error
+	}{
+		{status.Error(codes.Unauthenticated, "bad token"), ErrAuthFailed},
+		{status.Error(codes.Unavailable, "transport is closing"),
+			ErrHostDisconnected},
+		{status.Error(codes.DeadlineExceeded, "deadline"),
+			context.DeadlineExceeded},
+		{status.Error(codes.Unknown, "gateway: "+ProxyError), ErrProxyError},
+		{errors.WithMessage(status.Error(codes.Unauthenticated, "bad token"),
+			"wrapped"), ErrAuthFailed},
+	}
+
+	for i, tt := range tests {
+		err := FromGrpcStatus(tt.err)
+		if !errors.Is(err, tt.class) {
+			t.Errorf("Error %d was not classified as %q: %+v", i, tt.class, err)
+		}
+		if err.Error() != tt.err.Error() {
+			t.Errorf("Message of error %d changed.\nexpected: %s\nreceived: %s",
+				i, tt.err, err)
+		}
+	}
+
+	var remoteErr *RemoteError
+	if !errors.As(FromGrpcStatus(status.Error(codes.NotFound, "x")),
+		&remoteErr) || remoteErr.Code() != codes.NotFound ||
+		remoteErr.Unwrap() != nil {
+		t.Errorf("Unexpected unclassified RemoteError: %+v", remoteErr)
+	}
+}
+
+// Tests that AuthFailedError and the replay errors are auth errors.
+func TestAuthFailedError_Is(t *testing.T) {
+	err := errors.WithMessage(
+		AuthError(id.NewIdFromString("host", id.Node, t)), "context")
+	var authErr *AuthFailedError
+	if !errors.Is(err, ErrAuthFailed) || !errors.As(err, &authErr) ||
+		authErr.HostId == nil {
+		t.Errorf("AuthError is not an AuthFailedError: %+v", err)
+	}
+
+	for _, replayErr := range []error{ErrMessageReplayed,
+		ErrMessageOutsideWindow, ErrMissingReplayProtection} {
+		if !errors.Is(replayErr, ErrAuthFailed) || !IsAuthError(replayErr) {
+			t.Errorf("%q is not an auth error", replayErr)
+		}
+	}
+}
+
+// Tests that sends are retried by error class.
+func TestProtoComms_transmit_Retry(t *testing.T) {
+	c := &ProtoComms{Manager: newManager()}
+	params := GetDefaultHostParams()
+	params.AuthEnabled = false
+	params.MaxRetries = 3
+	host, err := c.AddHost(id.NewIdFromString("retry", id.Node, t),
+		ServerAddress, nil, params)
+	if err != nil {
+		t.Fatalf("Failed to add host: %+v", err)
+	}
+	defer host.Disconnect()
+
+	tests := []struct {
+		err   error
+		class error
+		tries uint32
+	}{
+		{status.Error(codes.Unavailable, "transport is closing"),
+			ErrHostDisconnected, params.MaxRetries},
+		{ToGrpcStatus(ErrMessageReplayed), ErrMessageReplayed, 1},
+		{ToGrpcStatus(ErrCoolOff), ErrCoolOff, 1},
+		{status.Error(codes.NotFound, "not found"), nil, 1},
+	}
+
+	for i, tt := range tests {
+		var tries uint32
+		_, err = c.transmit(host, func(Connection) (interface{}, error) {
+			tries++
+			return nil, tt.err
+		})
+		if tt.class != nil && !errors.Is(err, tt.class) {
+			t.Errorf("Error %d was not classified as %q: %+v", i, tt.class, err)
+		}
+		if status.Code(err) != status.Code(tt.err) {
+			t.Errorf("Status of error %d was not kept: %+v", i, err)
+		}
+		if tries != tt.tries {
+			t.Errorf("Unexpected number of tries for error %d."+
+				"\nexpected: %d\nreceived: %d", i, tt.tries, tries)
+		}
+	}
+
+	_, err = c.transmit(&Host{}, nil)
+	if !errors.Is(err, ErrBlankAddress) {
+		t.Errorf("Expected %q, received %+v", ErrBlankAddress, err)
+	}
+}
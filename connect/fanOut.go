@@ -0,0 +1,224 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// Contains the helpers sending the same request to several hosts
+
+package connect
+
+import (
+	"context"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/pkg/errors"
+	jww "github.com/spf13/jwalterweatherman"
+	"time"
+)
+
+// ErrQuorumNotReached is returned by the fan-out sends when fewer sends
+// succeeded than required.
+var ErrQuorumNotReached = errors.New("not enough hosts answered")
+
+// FanOutFunc is the transmit function of a fan-out send. It is called once
+// for every Host which is sent to, with a context which is canceled once the
+// goal of the fan-out is met.
+type FanOutFunc func(ctx context.Context, host *Host, conn Connection) (
+	*any.Any, error)
+
+// FanOutParams configures a fan-out send.
+type FanOutParams struct {
+	// If nonzero, only as many sends as needed to meet the goal are started
+	// at first, in the order of the hosts. Another is started each time a
+	// send fails or HedgeDelay passes after the last one was started. Zero
+	// starts all sends at once.
+	HedgeDelay time.Duration
+}
+
+// GetDefaultFanOutParams returns the default FanOutParams, which start all
+// sends at once.
+func GetDefaultFanOutParams() FanOutParams {
+	return FanOutParams{
+		HedgeDelay: 0,
+	}
+}
+
+// FanOutResult is the outcome of the send to one Host of a fan-out.
+type FanOutResult struct {
+	Host   *Host
+	Result *any.Any
+	Err    error
+
+	// False if the send was not started because the goal was met or the
+	// context ended first
+	Started bool
+}
+
+// FanOutResults holds the outcomes of a fan-out send.
+type FanOutResults struct {
+	// Outcomes in the order of the hosts sent to
+	Results []FanOutResult
+
+	// Number of sends which succeeded
+	Successes int
+}
+
+// GetSuccessful returns the outcomes of the sends which succeeded, in the
+// order of the hosts.
+func (fr *FanOutResults) GetSuccessful() []FanOutResult {
+	successful := make([]FanOutResult, 0, fr.Successes)
+	for _, result := range fr.Results {
+		if result.Started && result.Err == nil {
+			successful = append(successful, result)
+		}
+	}
+	return successful
+}
+
+// GetFailed returns the outcomes of the sends which failed, in the order of
+// the hosts. This includes sends canceled once the goal was met.
+func (fr *FanOutResults) GetFailed() []FanOutResult {
+	failed := make([]FanOutResult, 0, len(fr.Results)-fr.Successes)
+	for _, result := range fr.Results {
+		if result.Started && result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// SendToAny sends to the hosts until one send succeeds, then cancels the
+// others. Its result is the first of FanOutResults.GetSuccessful. Returns an
+// error in the ErrQuorumNotReached class if every send failed.
+func (c *ProtoComms) SendToAny(ctx context.Context, hosts []*Host,
+	f FanOutFunc, params FanOutParams) (*FanOutResults, error) {
+	return c.fanOut(ctx, hosts, 1, f, params)
+}
+
+// SendToAll sends to every Host. Returns an error in the ErrQuorumNotReached
+// class if any send failed.
+func (c *ProtoComms) SendToAll(ctx context.Context, hosts []*Host,
+	f FanOutFunc, params FanOutParams) (*FanOutResults, error) {
+	return c.fanOut(ctx, hosts, len(hosts), f, params)
+}
+
+// SendQuorum sends to the hosts until k sends succeed, then cancels the
+// others. Returns an error in the ErrQuorumNotReached class if fewer than k
+// sends succeeded.
+func (c *ProtoComms) SendQuorum(ctx context.Context, hosts []*Host, k int,
+	f FanOutFunc, params FanOutParams) (*FanOutResults, error) {
+	if k <= 0 || k > len(hosts) {
+		return nil, errors.Errorf("Cannot reach a quorum of %d with %d hosts",
+			k, len(hosts))
+	}
+	return c.fanOut(ctx, hosts, k, f, params)
+}
+
+// fanOutOutcome is the outcome of one send of a fan-out.
+type fanOutOutcome struct {
+	index  int
+	result *any.Any
+	err    error
+}
+
+// fanOut sends to the hosts with SendWithContext until goal sends succeed,
+// then cancels the sends still running. It waits for every started send to
+// return, so that the results are complete.
+func (c *ProtoComms) fanOut(ctx context.Context, hosts []*Host, goal int,
+	f FanOutFunc, params FanOutParams) (*FanOutResults, error) {
+	results := &FanOutResults{Results: make([]FanOutResult, len(hosts))}
+	for i, host := range hosts {
+		results.Results[i].Host = host
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	outcomes := make(chan fanOutOutcome, len(hosts))
+	next, running := 0, 0
+	start := func() {
+		i := next
+		next++
+		running++
+		results.Results[i].Started = true
+		go func() {
+			result, err := c.SendWithContext(ctx, hosts[i],
+				func(ctx context.Context, conn Connection) (*any.Any, error) {
+					return f(ctx, hosts[i], conn)
+				})
+			outcomes <- fanOutOutcome{index: i, result: result, err: err}
+		}()
+	}
+
+	hedging := params.HedgeDelay > 0
+	initial := len(hosts)
+	if hedging {
+		initial = goal
+	}
+	for next < initial {
+		start()
+	}
+
+	// Fires when the next hedged send is due
+	var hedge <-chan time.Time
+	hedgeTimer := time.NewTimer(params.HedgeDelay)
+	defer hedgeTimer.Stop()
+	if hedging && next < len(hosts) {
+		hedge = hedgeTimer.C
+	}
+	startHedged := func() {
+		if !hedgeTimer.Stop() {
+			select {
+			case <-hedgeTimer.C:
+			default:
+			}
+		}
+		hedge = nil
+		if results.Successes >= goal || ctx.Err() != nil ||
+			next >= len(hosts) {
+			return
+		}
+		start()
+		if next < len(hosts) {
+			hedgeTimer.Reset(params.HedgeDelay)
+			hedge = hedgeTimer.C
+		}
+	}
+
+	done := ctx.Done()
+	for running > 0 {
+		select {
+		case outcome := <-outcomes:
+			running--
+			result := &results.Results[outcome.index]
+			result.Result, result.Err = outcome.result, outcome.err
+			if outcome.err != nil {
+				jww.DEBUG.Printf("Fan-out send to host %s failed: %s",
+					result.Host.id, outcome.err)
+				if hedging {
+					startHedged()
+				}
+				continue
+			}
+
+			results.Successes++
+			if results.Successes == goal {
+				// Cancel the losers
+				cancel()
+				hedge, done = nil, nil
+			}
+		case <-hedge:
+			startHedged()
+		case <-done:
+			hedge, done = nil, nil
+		}
+	}
+
+	if results.Successes < goal {
+		return results, errors.WithMessagef(ErrQuorumNotReached,
+			"%d of %d hosts answered, %d required", results.Successes,
+			len(hosts), goal)
+	}
+	return results, nil
+}
@@ -0,0 +1,161 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"context"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/pkg/errors"
+	"gitlab.com/xx_network/primitives/id"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// makeFanOutHosts adds n hosts connecting to the test server.
+func makeFanOutHosts(t *testing.T, c *ProtoComms, n int) []*Host {
+	params := GetDefaultHostParams()
+	params.AuthEnabled = false
+	params.MaxRetries = 1
+	hosts := make([]*Host, n)
+	for i := range hosts {
+		var err error
+		hosts[i], err = c.AddHost(id.NewIdFromString(
+			"fanOut"+strconv.Itoa(i), id.Node, t), ServerAddress, nil, params)
+		if err != nil {
+			t.Fatalf("Failed to add host: %+v", err)
+		}
+	}
+	t.Cleanup(c.DisconnectAll)
+	return hosts
+}
+
+// fanOutTestFunc returns a FanOutFunc which fails for the hosts at the
+// failing indexes, blocks until canceled for those at the blocking indexes
+// and otherwise answers with the index of the host.
+func fanOutTestFunc(hosts []*Host, failing, blocking map[int]bool,
+	calls *int32) FanOutFunc {
+	return func(ctx context.Context, host *Host, _ Connection) (*any.Any,
+		error) {
+		atomic.AddInt32(calls, 1)
+		for i, h := range hosts {
+			if h != host {
+				continue
+			}
+			if failing[i] {
+				return nil, status.Error(codes.NotFound, "not found")
+			}
+			if blocking[i] {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return &any.Any{TypeUrl: strconv.Itoa(i)}, nil
+		}
+		return nil, errors.New("unknown host")
+	}
+}
+
+// Tests that SendToAny returns the first success and cancels the other
+// sends.
+func TestProtoComms_SendToAny(t *testing.T) {
+	c := &ProtoComms{Manager: newManager()}
+	hosts := makeFanOutHosts(t, c, 3)
+	var calls int32
+	f := fanOutTestFunc(hosts, map[int]bool{0: true}, map[int]bool{2: true},
+		&calls)
+
+	results, err := c.SendToAny(context.Background(), hosts, f,
+		GetDefaultFanOutParams())
+	if err != nil {
+		t.Fatalf("SendToAny failed: %+v", err)
+	}
+	successful := results.GetSuccessful()
+	if len(successful) != 1 || successful[0].Result.TypeUrl != "1" {
+		t.Errorf("Unexpected successful sends: %+v", successful)
+	}
+	failed := results.GetFailed()
+	if len(failed) != 2 || !errors.Is(failed[1].Err, context.Canceled) {
+		t.Errorf("Losing send was not canceled: %+v", failed)
+	}
+
+	// Every send fails
+	f = fanOutTestFunc(hosts, map[int]bool{0: true, 1: true, 2: true}, nil,
+		&calls)
+	_, err = c.SendToAny(context.Background(), hosts, f,
+		GetDefaultFanOutParams())
+	if !errors.Is(err, ErrQuorumNotReached) {
+		t.Errorf("Expected %q, received %+v", ErrQuorumNotReached, err)
+	}
+}
+
+// Tests that SendToAll sends to every Host and reports any failure.
+func TestProtoComms_SendToAll(t *testing.T) {
+	c := &ProtoComms{Manager: newManager()}
+	hosts := makeFanOutHosts(t, c, 3)
+	var calls int32
+	f := fanOutTestFunc(hosts, map[int]bool{1: true}, nil, &calls)
+
+	results, err := c.SendToAll(context.Background(), hosts, f,
+		GetDefaultFanOutParams())
+	if !errors.Is(err, ErrQuorumNotReached) {
+		t.Errorf("Expected %q, received %+v", ErrQuorumNotReached, err)
+	}
+	if calls != 3 || results.Successes != 2 {
+		t.Errorf("Unexpected sends. calls: %d, successes: %d", calls,
+			results.Successes)
+	}
+	for i, result := range results.Results {
+		if result.Host != hosts[i] || !result.Started ||
+			(result.Err != nil) != (i == 1) {
+			t.Errorf("Unexpected result %d: %+v", i, result)
+		}
+	}
+}
+
+// Tests that SendQuorum stops once k sends succeed and that hedged sends are
+// started on failure or after the HedgeDelay.
+func TestProtoComms_SendQuorum(t *testing.T) {
+	c := &ProtoComms{Manager: newManager()}
+	hosts := makeFanOutHosts(t, c, 4)
+	var calls int32
+	params := FanOutParams{HedgeDelay: time.Minute}
+
+	// The failure of host 0 starts host 2 without waiting for the delay
+	f := fanOutTestFunc(hosts, map[int]bool{0: true}, nil, &calls)
+	start := time.Now()
+	results, err := c.SendQuorum(context.Background(), hosts, 2, f, params)
+	if err != nil {
+		t.Fatalf("SendQuorum failed: %+v", err)
+	}
+	if time.Since(start) >= params.HedgeDelay || calls != 3 ||
+		results.Results[3].Started {
+		t.Errorf("Unexpected hedged sends. calls: %d, results: %+v", calls,
+			results.Results)
+	}
+
+	// Host 1 blocks, so host 2 is started after the delay
+	calls = 0
+	params.HedgeDelay = 50 * time.Millisecond
+	f = fanOutTestFunc(hosts, nil, map[int]bool{1: true}, &calls)
+	results, err = c.SendQuorum(context.Background(), hosts, 2, f, params)
+	if err != nil {
+		t.Fatalf("SendQuorum failed: %+v", err)
+	}
+	if calls != 3 || !errors.Is(results.Results[1].Err, context.Canceled) {
+		t.Errorf("Unexpected hedged sends. calls: %d, results: %+v", calls,
+			results.Results)
+	}
+
+	if _, err = c.SendQuorum(context.Background(), hosts, 5, f,
+		params); err == nil {
+		t.Errorf("SendQuorum accepted a quorum larger than the hosts")
+	}
+}
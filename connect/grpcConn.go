@@ -1,10 +1,10 @@
 package connect
 
 import (
+	"context"
 	"crypto/x509"
-	"errors"
-	"fmt"
 	"git.xx.network/elixxir/grpc-web-go-client/grpcweb"
+	"github.com/pkg/errors"
 	jww "github.com/spf13/jwalterweatherman"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/connectivity"
@@ -33,7 +33,13 @@ func (gc *grpcConn) GetGrpcConn() *grpc.ClientConn {
 
 // Connect initializes the appropriate connection using helper functions.
 func (gc *grpcConn) Connect() error {
-	return gc.connectGrpcHelper()
+	return gc.connectGrpcHelper(context.Background())
+}
+
+// connectWithContext initializes the connection, giving up once the context
+// is done.
+func (gc *grpcConn) connectWithContext(ctx context.Context) error {
+	return gc.connectGrpcHelper(ctx)
 }
 
 // IsWeb returns true if the connection is configured for web connections
@@ -48,7 +54,7 @@ func (gc *grpcConn) GetRemoteCertificate() (*x509.Certificate, error) {
 
 // connectGrpcHelper creates a connection while not under a write lock.
 // undefined behavior if the caller has not taken the write lock
-func (gc *grpcConn) connectGrpcHelper() (err error) {
+func (gc *grpcConn) connectGrpcHelper(parent context.Context) (err error) {
 	// Configure TLS options
 	var securityDial grpc.DialOption
 	if gc.h.credentials != nil {
@@ -65,27 +71,26 @@ func (gc *grpcConn) connectGrpcHelper() (err error) {
 	jww.DEBUG.Printf("Attempting to establish connection to %s using"+
 		" credentials: %+v", gc.h.GetAddress(), securityDial)
 
-	// Attempt to establish a new connection
-	var numRetries uint32
+	// Attempt to establish a new connection, retrying according to the
+	// ConnectRetryPolicy
+	policy := gc.h.getConnectRetryPolicy()
+	start := time.Now()
 	//todo-remove this retry block when grpc is updated
-	for numRetries = 0; numRetries < gc.h.params.MaxRetries && !gc.isAlive(); numRetries++ {
+	for attempt := uint32(0); !gc.isAlive() && parent.Err() == nil; attempt++ {
 		gc.h.disconnect()
 
-		jww.DEBUG.Printf("Connecting to %+v Attempt number %+v of %+v",
-			gc.h.GetAddress(), numRetries, gc.h.params.MaxRetries)
+		jww.DEBUG.Printf("Connecting to %+v Attempt number %+v",
+			gc.h.GetAddress(), attempt)
 
-		// If timeout is enabled, the max wait time becomes
-		// ~14 seconds (with maxRetries=100)
-		backoffTime := 2000 * (numRetries/16 + 1)
-		if backoffTime > 15000 {
-			backoffTime = 15000
-		}
-		ctx, cancel := newContext(time.Duration(backoffTime) * time.Millisecond)
+		ctx, cancel := attemptContext(parent, policy, attempt)
 
 		dialOpts := []grpc.DialOption{
 			grpc.WithBlock(),
 			grpc.WithKeepaliveParams(gc.h.params.KaClientOpts),
 			grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(math.MaxInt32)),
+			grpc.WithChainUnaryInterceptor(gc.h.macUnaryClientInterceptor),
+			grpc.WithChainStreamInterceptor(gc.h.macStreamClientInterceptor),
+			grpc.WithStatsHandler(&hostStatsHandler{stats: gc.h.stats}),
 			securityDial,
 		}
 
@@ -98,20 +103,31 @@ func (gc *grpcConn) connectGrpcHelper() (err error) {
 		// Create the connection
 		gc.connection, err = grpc.DialContext(ctx, gc.h.GetAddress(),
 			dialOpts...)
+		cancel()
+		if gc.isAlive() {
+			break
+		} else if err == nil {
+			err = errors.New("connection is not alive")
+		}
 
-		if err != nil {
-			jww.DEBUG.Printf("Attempt number %+v to connect to %s failed\n",
-				numRetries, gc.h.GetAddress())
+		jww.DEBUG.Printf("Attempt number %+v to connect to %s failed: %s",
+			attempt, gc.h.GetAddress(), err)
+		wait, retry := policy.Retry(attempt, time.Since(start), err)
+		if !retry || !waitRetry(parent, wait) {
+			break
 		}
-		cancel()
 	}
 
 	// Verify that the connection was established successfully
 	if !gc.isAlive() {
 		gc.h.disconnect()
-		return errors.New(fmt.Sprintf(
+		if parent.Err() != nil {
+			return errors.WithMessagef(parent.Err(),
+				"Gave up connecting to %s", gc.h.GetAddress())
+		}
+		return errors.WithMessagef(ErrConnectFailed,
 			"Last try to connect to %s failed. Giving up",
-			gc.h.GetAddress()))
+			gc.h.GetAddress())
 	}
 
 	// Add the successful connection to the Manager
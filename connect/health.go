@@ -0,0 +1,182 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// Contains the standard gRPC health checking service of servers and the
+// health checks of hosts
+
+package connect
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	jww "github.com/spf13/jwalterweatherman"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"time"
+)
+
+// Full method name of the health check of the grpc.health.v1 service
+const healthCheckMethod = "/grpc.health.v1.Health/Check"
+
+// getHealthServer returns the health server of the ProtoComms, creating it
+// on first use.
+func (c *ProtoComms) getHealthServer() *health.Server {
+	c.healthOnce.Do(func() {
+		c.healthServer = health.NewServer()
+	})
+	return c.healthServer
+}
+
+// SetServingStatus sets the status the grpc.health.v1 service of the server
+// reports for the service. The empty service name is the status of the
+// server as a whole, which is SERVING until Shutdown is called. Services
+// without a status are reported as unknown.
+func (c *ProtoComms) SetServingStatus(service string,
+	status healthpb.HealthCheckResponse_ServingStatus) {
+	c.getHealthServer().SetServingStatus(service, status)
+}
+
+// HealthState is the result of the last health check of a Host.
+type HealthState struct {
+	// Service which was checked, empty for the server as a whole
+	Service string
+
+	// Status reported by the Host, UNKNOWN if the check failed or never ran
+	Status healthpb.HealthCheckResponse_ServingStatus
+
+	// Error of the check, nil if it succeeded
+	Err error
+
+	// Time of the check, zero if it never ran
+	Checked time.Time
+}
+
+// IsUnhealthy returns true if the Host was checked and the check failed or
+// reported the service as not serving. Hosts which were never checked are
+// not unhealthy.
+func (hs HealthState) IsUnhealthy() bool {
+	return !hs.Checked.IsZero() &&
+		(hs.Err != nil || hs.Status != healthpb.HealthCheckResponse_SERVING)
+}
+
+// HealthCheck asks the grpc.health.v1 service of the Host for the status of
+// the service, connecting if needed, and caches the result for GetHealth.
+// The empty service name checks the server as a whole.
+func (h *Host) HealthCheck(ctx context.Context, service string) (
+	healthpb.HealthCheckResponse_ServingStatus, error) {
+	status, err := h.checkHealth(ctx, service)
+	h.setHealth(HealthState{
+		Service: service,
+		Status:  status,
+		Err:     err,
+		Checked: time.Now(),
+	})
+	return status, err
+}
+
+// checkHealth connects to the Host if needed and calls its health check.
+func (h *Host) checkHealth(ctx context.Context, service string) (
+	healthpb.HealthCheckResponse_ServingStatus, error) {
+	h.connectionMux.Lock()
+	if !h.isAlive() {
+		if err := h.connectWithContext(ctx); err != nil {
+			h.connectionMux.Unlock()
+			return healthpb.HealthCheckResponse_UNKNOWN, errors.WithMessagef(
+				err, "Failed to connect to host %s for health check", h.id)
+		}
+	}
+	h.connectionMux.Unlock()
+
+	h.connectionMux.RLock()
+	defer h.connectionMux.RUnlock()
+	req := &healthpb.HealthCheckRequest{Service: service}
+	resp := &healthpb.HealthCheckResponse{}
+	var err error
+	if h.connection.IsWeb() {
+		err = h.connection.GetWebConn().Invoke(ctx, healthCheckMethod, req,
+			resp)
+	} else {
+		resp, err = healthpb.NewHealthClient(
+			h.connection.GetGrpcConn()).Check(ctx, req)
+	}
+	if err != nil {
+		return healthpb.HealthCheckResponse_UNKNOWN, errors.WithMessagef(
+			FromGrpcStatus(err), "Health check of host %s failed", h.id)
+	}
+	return resp.GetStatus(), nil
+}
+
+// GetHealth returns the result of the last health check of the Host.
+func (h *Host) GetHealth() HealthState {
+	h.healthMux.RLock()
+	defer h.healthMux.RUnlock()
+	return h.health
+}
+
+// setHealth caches the result of a health check and notifies observers if
+// the Host became healthy or unhealthy.
+func (h *Host) setHealth(state HealthState) {
+	h.healthMux.Lock()
+	previous := h.health
+	h.health = state
+	h.healthMux.Unlock()
+
+	if previous.IsUnhealthy() != state.IsUnhealthy() ||
+		previous.Status != state.Status {
+		h.connectionMux.RLock()
+		h.notify(HostHealthChanged, state.Err)
+		h.connectionMux.RUnlock()
+	}
+}
+
+// StartHealthProber checks the health of the service on the Host every
+// interval in the background, keeping the result of GetHealth current and
+// the connection to the Host open. Each check may take up to the PingTimeout
+// of the Host. Replaces any prober already running.
+func (h *Host) StartHealthProber(service string, interval time.Duration) {
+	h.healthMux.Lock()
+	defer h.healthMux.Unlock()
+	if h.healthStop != nil {
+		close(h.healthStop)
+	}
+	h.healthStop = make(chan struct{})
+	go h.healthProber(service, interval, h.healthStop)
+}
+
+// StopHealthProber stops the prober started by StartHealthProber, if any.
+// The last result of GetHealth is kept.
+func (h *Host) StopHealthProber() {
+	h.healthMux.Lock()
+	defer h.healthMux.Unlock()
+	if h.healthStop != nil {
+		close(h.healthStop)
+		h.healthStop = nil
+	}
+}
+
+// healthProber checks the health of the service every interval until stop is
+// closed.
+func (h *Host) healthProber(service string, interval time.Duration,
+	stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(),
+			h.params.PingTimeout)
+		if _, err := h.HealthCheck(ctx, service); err != nil {
+			jww.DEBUG.Printf("Health check of host %s failed: %+v", h.id,
+				err)
+		}
+		cancel()
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
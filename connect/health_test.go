@@ -0,0 +1,159 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"context"
+	"gitlab.com/xx_network/primitives/id"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"testing"
+	"time"
+)
+
+// Tests that servers report the serving status set by the application and
+// that HealthCheck caches the result.
+func TestHost_HealthCheck(t *testing.T) {
+	serverID := id.NewIdFromString("healthy", id.Node, t)
+	pc, err := StartCommServer(serverID, "127.0.0.1:11443", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	defer pc.Shutdown(context.Background())
+	pc.SetServingStatus("messages.Generic",
+		healthpb.HealthCheckResponse_NOT_SERVING)
+	pc.Serve()
+
+	params := GetDefaultHostParams()
+	params.AuthEnabled = false
+	host, err := NewHost(serverID, "127.0.0.1:11443", nil, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer host.Disconnect()
+	if host.GetHealth().IsUnhealthy() {
+		t.Errorf("Host which was never checked is unhealthy")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	status, err := host.HealthCheck(ctx, "")
+	if err != nil || status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Server is not serving: %s %+v", status, err)
+	}
+	if host.GetHealth().IsUnhealthy() {
+		t.Errorf("Serving host is unhealthy: %+v", host.GetHealth())
+	}
+
+	status, err = host.HealthCheck(ctx, "messages.Generic")
+	if err != nil || status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Unexpected status of the service: %s %+v", status, err)
+	}
+	health := host.GetHealth()
+	if !health.IsUnhealthy() || health.Service != "messages.Generic" {
+		t.Errorf("Host which is not serving is healthy: %+v", health)
+	}
+
+	if _, err = host.HealthCheck(ctx, "unknown"); err == nil {
+		t.Errorf("Checked the health of an unknown service")
+	}
+	if !host.GetHealth().IsUnhealthy() {
+		t.Errorf("Failed check did not make the host unhealthy")
+	}
+}
+
+// Tests that the health prober keeps the health of the Host current and
+// notifies observers of changes.
+func TestHost_StartHealthProber(t *testing.T) {
+	serverID := id.NewIdFromString("probed", id.Node, t)
+	pc, err := StartCommServer(serverID, "127.0.0.1:11444", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	defer pc.Shutdown(context.Background())
+	pc.SetServingStatus("probed", healthpb.HealthCheckResponse_NOT_SERVING)
+	pc.Serve()
+
+	manager := newManager()
+	params := GetDefaultHostParams()
+	params.AuthEnabled = false
+	host, err := manager.AddHost(serverID, "127.0.0.1:11444", nil, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.RemoveHost(serverID)
+	events := make(chan HostEvent, 10)
+	host.AddObserver(func(event HostEvent) {
+		if event.Type == HostHealthChanged {
+			events <- event
+		}
+	})
+
+	host.StartHealthProber("probed", 10*time.Millisecond)
+	waitHealth := func(status healthpb.HealthCheckResponse_ServingStatus) {
+		select {
+		case <-events:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", HostHealthChanged)
+		}
+		if host.GetHealth().Status != status {
+			t.Errorf("Unexpected status: %+v", host.GetHealth())
+		}
+	}
+
+	waitHealth(healthpb.HealthCheckResponse_NOT_SERVING)
+	if len(manager.GetAvailableHosts()) != 0 {
+		t.Errorf("Manager returned an unhealthy host")
+	}
+
+	pc.SetServingStatus("probed", healthpb.HealthCheckResponse_SERVING)
+	waitHealth(healthpb.HealthCheckResponse_SERVING)
+	if len(manager.GetAvailableHosts()) != 1 {
+		t.Errorf("Manager did not return the healthy host")
+	}
+
+	host.StopHealthProber()
+	checked := host.GetHealth().Checked
+	time.Sleep(50 * time.Millisecond)
+	if !host.GetHealth().Checked.Equal(checked) {
+		t.Errorf("Prober kept running after it was stopped")
+	}
+}
+
+// Tests that Shutdown marks the server as not serving and Restart resumes it.
+func TestProtoComms_Shutdown_Health(t *testing.T) {
+	pc, err := StartCommServer(id.NewIdFromString("draining", id.Node, t),
+		"127.0.0.1:11445", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	check := func() healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := pc.getHealthServer().Check(context.Background(),
+			&healthpb.HealthCheckRequest{})
+		if err != nil {
+			t.Fatalf("Health check failed: %+v", err)
+		}
+		return resp.Status
+	}
+
+	if check() != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("New server is not serving")
+	}
+	if err = pc.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if check() != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Server is serving after Shutdown")
+	}
+	if err = pc.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Shutdown(context.Background())
+	if check() != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Server is not serving after restarting")
+	}
+}
@@ -16,11 +16,11 @@ import (
 	"github.com/pkg/errors"
 	jww "github.com/spf13/jwalterweatherman"
 	"gitlab.com/xx_network/comms/connect/token"
+	"gitlab.com/xx_network/crypto/signature/ec"
 	"gitlab.com/xx_network/crypto/signature/rsa"
 	tlsCreds "gitlab.com/xx_network/crypto/tls"
 	"gitlab.com/xx_network/primitives/exponential"
 	"gitlab.com/xx_network/primitives/id"
-	"gitlab.com/xx_network/primitives/rateLimiting"
 	"google.golang.org/grpc/credentials"
 	"math"
 	"strings"
@@ -49,6 +49,11 @@ type Host struct {
 	// Live used for sending to this host
 	transmissionToken *token.Live
 
+	// Counter of the requests MACed for this Host and the counters of the
+	// MACed requests received from it under the current reception token
+	requestCounter  uint64
+	requestCounters counterWindow
+
 	// GRPC connection object
 	connection      Connection
 	connectionCount uint64
@@ -62,15 +67,43 @@ type Host struct {
 	// RSA Public Key corresponding to the TLS Certificate
 	rsaPublicKey *rsa.PublicKey
 
+	// Optional Ed25519 identity key of the Host
+	eccPublicKey *ec.PublicKey
+	// Guards the public keys, which UpdateCertificate may replace
+	keyMux sync.RWMutex
+
 	// State tracking for host metric
 	metrics *Metric
 
+	// Latency and outcome statistics of the sends to the Host
+	stats *hostStatsTracker
+
 	// Tracks the exponential moving average of proxy error messages so that if
 	// too many connection error occur, the layer above can be informed
 	proxyErrorMetric *exponential.MovingAvg
 
-	coolOffBucket *rateLimiting.Bucket
-	inCoolOff     bool
+	// Stops sends while too many fail, nil if disabled
+	breaker *circuitBreaker
+
+	// Result of the last health check and the channel stopping the health
+	// prober
+	health     HealthState
+	healthStop chan struct{}
+	healthMux  sync.RWMutex
+
+	// Labels Manager.Select can filter on
+	labels    map[string]string
+	labelsMux sync.RWMutex
+
+	// Observers of the lifecycle events of this Host
+	observers hostObservers
+	// Manager the Host belongs to, only set under the connectionMux
+	manager *Manager
+	// Set while the Host has an established connection which has not been
+	// closed
+	connectionOpen bool
+	// Time of the last connection or send, in Unix nanoseconds
+	lastUsed int64
 
 	// Stored default values (should be non-mutated)
 	params HostParams
@@ -98,6 +131,7 @@ func newHost(id *id.ID, address string, cert []byte, params HostParams) (host *H
 		transmissionToken: token.NewLive(),
 		receptionToken:    token.NewLive(),
 		metrics:           newMetric(),
+		stats:             newHostStatsTracker(),
 		proxyErrorMetric:  exponential.NewMovingAvg(params.ProxyErrorMetricParams),
 		params:            params,
 		windowSize:        &windowSize,
@@ -105,10 +139,13 @@ func newHost(id *id.ID, address string, cert []byte, params HostParams) (host *H
 
 	host.connection = newConnection(params.ConnectionType, host)
 
-	if params.EnableCoolOff {
-		host.coolOffBucket = rateLimiting.CreateBucket(
-			params.NumSendsBeforeCoolOff+1, params.NumSendsBeforeCoolOff+1,
-			params.CoolOffTimeout, nil)
+	host.labels = make(map[string]string, len(params.Labels))
+	for key, value := range params.Labels {
+		host.labels[key] = value
+	}
+
+	if breakerParams := params.circuitBreakerParams(); breakerParams.Enabled {
+		host.breaker = newCircuitBreaker(breakerParams)
 	}
 
 	if host.params.MaxRetries == 0 {
@@ -141,6 +178,8 @@ func (h *Host) SetWindowSize(size int32) {
 
 // GetPubKey simple getter for the public key
 func (h *Host) GetPubKey() *rsa.PublicKey {
+	h.keyMux.RLock()
+	defer h.keyMux.RUnlock()
 	return h.rsaPublicKey
 }
 
@@ -165,6 +204,14 @@ func (h *Host) GetMessagingContext() (context.Context, context.CancelFunc) {
 	return h.GetMessagingContextWithTimeout(h.params.SendTimeout)
 }
 
+// GetMessagingContextFrom returns a context object for message sending
+// configured according to HostParams, derived from the given context so that
+// it also ends when the given context does
+func (h *Host) GetMessagingContextFrom(
+	ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, h.params.SendTimeout)
+}
+
 // GetMessagingContextWithTimeout returns a context object for message sending configured according to HostParams
 func (h *Host) GetMessagingContextWithTimeout(
 	timeout time.Duration) (context.Context, context.CancelFunc) {
@@ -179,6 +226,23 @@ func (h *Host) GetId() *id.ID {
 	return h.id
 }
 
+// GetLabel returns the value of the label of the Host with the key. Returns
+// false if the Host has no such label.
+func (h *Host) GetLabel(key string) (string, bool) {
+	h.labelsMux.RLock()
+	defer h.labelsMux.RUnlock()
+	value, ok := h.labels[key]
+	return value, ok
+}
+
+// SetLabel sets the label of the Host with the key, replacing its value if
+// it is already set.
+func (h *Host) SetLabel(key, value string) {
+	h.labelsMux.Lock()
+	defer h.labelsMux.Unlock()
+	h.labels[key] = value
+}
+
 // GetAddress returns the address of the host.
 func (h *Host) GetAddress() string {
 	a := h.addressAtomic.Load()
@@ -195,6 +259,9 @@ func (h *Host) UpdateAddress(address string) {
 
 // GetMetrics returns a deep copy of Host's Metric
 // This resets the state of metrics
+//
+// Deprecated: use GetStats or GetWindowStats, which do not reset the
+// statistics they return.
 func (h *Host) GetMetrics() *Metric {
 	return h.metrics.get()
 }
@@ -249,10 +316,11 @@ func (h *Host) Disconnect() {
 }
 
 // ConditionalDisconnect closes the Host connection under the write lock only
-// if the connection count has not increased
-func (h *Host) conditionalDisconnect(count uint64) {
+// if the connection count has not increased. The cause is reported to
+// observers.
+func (h *Host) conditionalDisconnect(count uint64, cause error) {
 	if count == h.connectionCount {
-		h.disconnect()
+		h.disconnectWithCause(cause)
 	}
 }
 
@@ -270,10 +338,16 @@ func (h *Host) transmit(f func(conn Connection) (interface{},
 
 	// Check if connection is down
 	if h.connection == nil {
-		return nil, errors.New("Failed to transmit: host disconnected")
+		return nil, errors.WithMessage(ErrHostDisconnected,
+			"Failed to transmit")
 	}
 
+	start := time.Now()
+	atomic.StoreInt64(&h.lastUsed, start.UnixNano())
 	a, err := f(h.connection)
+	// Restore the class of errors returned by the remote end
+	err = FromGrpcStatus(err)
+	h.stats.observeSend(time.Since(start), err)
 
 	if h.params.EnableMetrics && err != nil {
 		// Checks if the received error is a among excluded errors
@@ -288,10 +362,14 @@ func (h *Host) transmit(f func(conn Connection) (interface{},
 		// moving average. If the cutoff is reached for too many timeouts,
 		// return TooManyProxyError instead so that the host can be removed from
 		// the host pool on the layer above.
+		wasOverCutoff := h.proxyErrorMetric.IsOverCutoff()
 		err2 := h.proxyErrorMetric.Intake(
-			exponential.BoolToFloat(strings.Contains(err.Error(), ProxyError)))
+			exponential.BoolToFloat(isProxyError(err)))
 		if err2 != nil {
-			err = errors.Errorf("%s: %+v", TooManyProxyError, err2)
+			err = errors.WithMessagef(ErrTooManyProxyErrors, "%+v", err2)
+			if !wasOverCutoff {
+				h.notify(HostProxyErrorThreshold, err)
+			}
 		}
 	}
 
@@ -308,12 +386,24 @@ func (h *Host) Connect() error {
 
 // connect attempts to connect to the host if it does not have a valid connection
 func (h *Host) connect() error {
+	return h.connectWithContext(context.Background())
+}
+
+// connectWithContext attempts to connect to the host if it does not have a
+// valid connection, giving up once the context is done
+func (h *Host) connectWithContext(ctx context.Context) error {
 	//connect to remote
-	if err := h.connection.Connect(); err != nil {
+	if err := h.connection.connectWithContext(ctx); err != nil {
 		return err
 	}
 
 	h.connectionCount++
+	h.connectionOpen = true
+	atomic.StoreInt64(&h.lastUsed, time.Now().UnixNano())
+	h.notify(HostConnected, nil)
+	if h.manager != nil {
+		h.manager.enforceConnectionLimit()
+	}
 
 	return nil
 }
@@ -337,19 +427,43 @@ func (h *Host) isAlive() bool {
 // disconnect closes the Host connection while not under a write lock.
 // undefined behavior if the caller has not taken the write lock
 func (h *Host) disconnect() {
+	h.disconnectWithCause(nil)
+}
+
+// disconnectWithCause closes the Host connection while not under a write
+// lock and reports the cause to observers if a connection was established.
+// undefined behavior if the caller has not taken the write lock
+func (h *Host) disconnectWithCause(cause error) {
+	if h.connection == nil {
+		return
+	}
 	h.connection.disconnect()
 	h.transmissionToken.Clear()
+	if h.connectionOpen {
+		h.connectionOpen = false
+		h.notify(HostDisconnected, cause)
+	}
 }
 
 // setCredentials sets GRPC TransportCredentials and RSA PublicKey objects
 // using a PEM-encoded TLS Certificate
 func (h *Host) setCredentials() error {
+	creds, pubKey, err := newCredentials(h.certificate)
+	h.credentials = creds
+	h.rsaPublicKey = pubKey
+	return err
+}
+
+// newCredentials creates the GRPC TransportCredentials and RSA PublicKey
+// objects for a PEM-encoded TLS Certificate
+func newCredentials(certificate []byte) (credentials.TransportCredentials,
+	*rsa.PublicKey, error) {
 
 	// If no TLS Certificate specified, print a warning and do nothing
-	if h.certificate == nil || len(h.certificate) == 0 {
+	if certificate == nil || len(certificate) == 0 {
 		if TestingOnlyDisableTLS {
 			jww.WARN.Printf("No TLS Certificate specified!")
-			return nil
+			return nil, nil, nil
 		} else {
 			jww.FATAL.Panicf("TLS cannot be disabled in production, only for testing suites!")
 		}
@@ -357,28 +471,81 @@ func (h *Host) setCredentials() error {
 
 	// Obtain the DNS name included with the certificate
 	dnsName := ""
-	cert, err := tlsCreds.LoadCertificate(string(h.certificate))
+	cert, err := tlsCreds.LoadCertificate(string(certificate))
 	if err != nil {
-		return errors.Errorf("Error forming transportCredentials: %+v", err)
+		return nil, nil, errors.Errorf(
+			"Error forming transportCredentials: %+v", err)
 	}
 	if len(cert.DNSNames) > 0 {
 		dnsName = cert.DNSNames[0]
 	}
 
 	// Create the TLS Credentials object
-	h.credentials, err = tlsCreds.NewCredentialsFromPEM(string(h.certificate),
-		dnsName)
+	creds, err := tlsCreds.NewCredentialsFromPEM(string(certificate), dnsName)
 	if err != nil {
-		return errors.Errorf("Error forming transportCredentials: %+v", err)
+		return nil, nil, errors.Errorf(
+			"Error forming transportCredentials: %+v", err)
 	}
 
 	// Create the RSA Public Key object
-	h.rsaPublicKey, err = tlsCreds.NewPublicKeyFromPEM(h.certificate)
+	pubKey, err := tlsCreds.NewPublicKeyFromPEM(certificate)
 	if err != nil {
 		err = errors.Errorf("Error extracting PublicKey: %+v", err)
 	}
 
-	return err
+	return creds, pubKey, err
+}
+
+// UpdateCertificate replaces the PEM-encoded TLS Certificate of the Host,
+// along with the credentials and RSA PublicKey derived from it. The
+// connection is closed, the tokens cleared and the circuit breaker closed
+// under the connection lock, so the next send reconnects and authenticates
+// again with the new certificate. The Host is unchanged if the certificate
+// is invalid.
+func (h *Host) UpdateCertificate(cert []byte) error {
+	creds, pubKey, err := newCredentials(cert)
+	if err != nil {
+		return err
+	}
+
+	h.connectionMux.Lock()
+	defer h.connectionMux.Unlock()
+	h.setCertificate(cert, creds, pubKey)
+	return nil
+}
+
+// update changes the address and TLS Certificate of the Host together, as
+// UpdateCertificate does for the certificate alone.
+func (h *Host) update(address string, cert []byte) error {
+	creds, pubKey, err := newCredentials(cert)
+	if err != nil {
+		return err
+	}
+
+	h.connectionMux.Lock()
+	defer h.connectionMux.Unlock()
+	h.UpdateAddress(address)
+	h.setCertificate(cert, creds, pubKey)
+	return nil
+}
+
+// setCertificate stores the certificate and its credentials and RSA
+// PublicKey, then closes the connection and clears the tokens negotiated
+// under the old certificate. The circuit breaker is closed, as failures
+// under the old certificate say nothing about the new one.
+// undefined behavior if the caller has not taken the write lock
+func (h *Host) setCertificate(cert []byte,
+	creds credentials.TransportCredentials, pubKey *rsa.PublicKey) {
+	h.certificate = cert
+	h.credentials = creds
+	h.keyMux.Lock()
+	h.rsaPublicKey = pubKey
+	h.keyMux.Unlock()
+
+	h.disconnectWithCause(ErrCertificateUpdated)
+	h.receptionToken.Clear()
+	h.requestCounters.reset()
+	h.resetCircuit()
 }
 
 // Stringer interface for connection
@@ -406,5 +573,7 @@ func (h *Host) SetTestPublicKey(key *rsa.PublicKey, t interface{}) {
 	default:
 		jww.FATAL.Panicf("SetTestPublicKey is restricted to testing only. Got %T", t)
 	}
+	h.keyMux.Lock()
+	defer h.keyMux.Unlock()
 	h.rsaPublicKey = key
 }
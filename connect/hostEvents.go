@@ -0,0 +1,244 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// Contains the observer API for Host lifecycle events
+
+package connect
+
+import (
+	"gitlab.com/xx_network/primitives/id"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// HostEventType describes a transition in the lifecycle of a Host.
+type HostEventType uint8
+
+const (
+	// HostAdded is sent when the Host is added to a Manager.
+	HostAdded HostEventType = iota + 1
+
+	// HostRemoved is sent when the Host is removed from a Manager.
+	HostRemoved
+
+	// HostConnected is sent when a connection to the Host is established.
+	// The event carries the new connection count.
+	HostConnected
+
+	// HostDisconnected is sent when an established connection is closed. The
+	// event carries the cause, which is nil if Disconnect was called.
+	HostDisconnected
+
+	// HostHandshakeFailed is sent when the authentication handshake with the
+	// Host fails.
+	HostHandshakeFailed
+
+	// HostProxyErrorThreshold is sent when the proxy error average of the
+	// Host goes over its cutoff and ErrTooManyProxyErrors is returned.
+	HostProxyErrorThreshold
+
+	// HostCircuitOpened is sent when the circuit breaker of the Host opens
+	// after too many failed sends.
+	HostCircuitOpened
+
+	// HostCircuitHalfOpened is sent when the circuit breaker of the Host
+	// starts letting probe sends through.
+	HostCircuitHalfOpened
+
+	// HostCircuitClosed is sent when the circuit breaker of the Host closes,
+	// either because its probes succeeded or because it was reset.
+	HostCircuitClosed
+
+	// HostHealthChanged is sent when a health check of the Host reports a
+	// different status than the previous one. The event carries the error of
+	// the check, if it failed.
+	HostHealthChanged
+)
+
+// String returns a human-readable name for the HostEventType.
+func (het HostEventType) String() string {
+	switch het {
+	case HostAdded:
+		return "Added"
+	case HostRemoved:
+		return "Removed"
+	case HostConnected:
+		return "Connected"
+	case HostDisconnected:
+		return "Disconnected"
+	case HostHandshakeFailed:
+		return "HandshakeFailed"
+	case HostProxyErrorThreshold:
+		return "ProxyErrorThreshold"
+	case HostCircuitOpened:
+		return "CircuitOpened"
+	case HostCircuitHalfOpened:
+		return "CircuitHalfOpened"
+	case HostCircuitClosed:
+		return "CircuitClosed"
+	case HostHealthChanged:
+		return "HealthChanged"
+	default:
+		return "Unknown HostEventType " + strconv.Itoa(int(het))
+	}
+}
+
+// HostEvent is a lifecycle transition of a Host.
+type HostEvent struct {
+	Type HostEventType
+
+	// ID of the Host the event occurred on
+	HostId *id.ID
+
+	// Connection count of the Host at the time of the event
+	ConnectionCount uint64
+
+	// Cause of the event, if any
+	Err error
+
+	// Number of events dropped just before this one because the observers
+	// fell behind
+	Dropped uint64
+}
+
+// Maximum number of events waiting to be delivered to the observers of a
+// Host or Manager. When exceeded, the oldest waiting event is dropped.
+const maxQueuedHostEvents = 1024
+
+// HostObserver is called with every HostEvent it is registered for. Events
+// are delivered in order from a separate goroutine, so an observer may call
+// back into the Host or Manager. A slow observer delays the delivery of later
+// events but never blocks the Host. Observers should return quickly: once
+// maxQueuedHostEvents are waiting, the oldest are dropped and counted in the
+// Dropped field of the next event delivered.
+type HostObserver func(event HostEvent)
+
+// hostObservers is a set of HostObserver which are notified of events in
+// order. The zero value is ready to use.
+type hostObservers struct {
+	observers map[uint64]HostObserver
+	nextId    uint64
+
+	// Events waiting to be delivered, the number dropped since the last
+	// delivery and whether a goroutine is delivering them
+	queue   []HostEvent
+	dropped uint64
+	running bool
+
+	mux sync.Mutex
+}
+
+// add registers an observer and returns the ID used to remove it.
+func (ho *hostObservers) add(observer HostObserver) uint64 {
+	ho.mux.Lock()
+	defer ho.mux.Unlock()
+	if ho.observers == nil {
+		ho.observers = make(map[uint64]HostObserver)
+	}
+	ho.nextId++
+	ho.observers[ho.nextId] = observer
+	return ho.nextId
+}
+
+// remove unregisters the observer with the given ID.
+func (ho *hostObservers) remove(observerId uint64) {
+	ho.mux.Lock()
+	defer ho.mux.Unlock()
+	delete(ho.observers, observerId)
+}
+
+// notify queues the event for delivery to all observers, starting a delivery
+// goroutine if one is not already running. If the queue is full, the oldest
+// event is dropped.
+func (ho *hostObservers) notify(event HostEvent) {
+	ho.mux.Lock()
+	defer ho.mux.Unlock()
+	if len(ho.observers) == 0 {
+		return
+	}
+	if len(ho.queue) >= maxQueuedHostEvents {
+		ho.queue[0] = HostEvent{}
+		ho.queue = ho.queue[1:]
+		ho.dropped++
+	}
+	ho.queue = append(ho.queue, event)
+	if !ho.running {
+		ho.running = true
+		go ho.deliver()
+	}
+}
+
+// deliver calls the observers with each queued event until the queue is
+// empty. Observers are called in the order they were added.
+func (ho *hostObservers) deliver() {
+	for {
+		ho.mux.Lock()
+		if len(ho.queue) == 0 {
+			ho.running = false
+			ho.mux.Unlock()
+			return
+		}
+		event := ho.queue[0]
+		ho.queue[0] = HostEvent{}
+		ho.queue = ho.queue[1:]
+		event.Dropped, ho.dropped = ho.dropped, 0
+
+		ids := make([]uint64, 0, len(ho.observers))
+		for observerId := range ho.observers {
+			ids = append(ids, observerId)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		observers := make([]HostObserver, len(ids))
+		for i, observerId := range ids {
+			observers[i] = ho.observers[observerId]
+		}
+		ho.mux.Unlock()
+
+		for _, observer := range observers {
+			observer(event)
+		}
+	}
+}
+
+// AddObserver registers an observer for the lifecycle events of the Host.
+// Returns an ID which can be passed to RemoveObserver.
+func (h *Host) AddObserver(observer HostObserver) uint64 {
+	return h.observers.add(observer)
+}
+
+// RemoveObserver unregisters the observer with the given ID.
+func (h *Host) RemoveObserver(observerId uint64) {
+	h.observers.remove(observerId)
+}
+
+// notify sends an event of the given type to the observers of the Host and
+// of the Manager it belongs to.
+func (h *Host) notify(eventType HostEventType, err error) {
+	event := HostEvent{
+		Type:            eventType,
+		HostId:          h.id,
+		ConnectionCount: h.connectionCount,
+		Err:             err,
+	}
+	h.observers.notify(event)
+	if h.manager != nil {
+		h.manager.observers.notify(event)
+	}
+}
+
+// AddObserver registers an observer for the lifecycle events of every Host
+// in the Manager, including hosts added later. Returns an ID which can be
+// passed to RemoveObserver.
+func (m *Manager) AddObserver(observer HostObserver) uint64 {
+	return m.observers.add(observer)
+}
+
+// RemoveObserver unregisters the observer with the given ID.
+func (m *Manager) RemoveObserver(observerId uint64) {
+	m.observers.remove(observerId)
+}
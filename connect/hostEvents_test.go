@@ -0,0 +1,191 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"github.com/pkg/errors"
+	"gitlab.com/xx_network/primitives/id"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// receiveEvents reads the given number of events from the channel.
+func receiveEvents(t *testing.T, events chan HostEvent, num int) []HostEventType {
+	received := make([]HostEventType, 0, num)
+	for i := 0; i < num; i++ {
+		select {
+		case event := <-events:
+			received = append(received, event.Type)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for events; received %v", received)
+		}
+	}
+	return received
+}
+
+// Tests that Manager observers receive the lifecycle events of a Host in
+// order and that Host observers only receive the events of their Host.
+func TestManager_AddObserver(t *testing.T) {
+	manager := newManager()
+	managerEvents := make(chan HostEvent, 10)
+	observerId := manager.AddObserver(func(event HostEvent) {
+		managerEvents <- event
+	})
+
+	testId := id.NewIdFromString("observed", id.Node, t)
+	host, err := manager.AddHost(testId, ServerAddress, nil,
+		GetDefaultHostParams())
+	if err != nil {
+		t.Fatalf("Failed to add host: %+v", err)
+	}
+	hostEvents := make(chan HostEvent, 10)
+	host.AddObserver(func(event HostEvent) { hostEvents <- event })
+
+	if err = host.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %+v", err)
+	}
+	host.Disconnect()
+	host.Disconnect()
+	manager.RemoveHost(testId)
+
+	expected := []HostEventType{
+		HostAdded, HostConnected, HostDisconnected, HostRemoved}
+	received := receiveEvents(t, managerEvents, len(expected))
+	if !reflect.DeepEqual(expected, received) {
+		t.Errorf("Unexpected manager events.\nexpected: %v\nreceived: %v",
+			expected, received)
+	}
+
+	expected = []HostEventType{HostConnected, HostDisconnected, HostRemoved}
+	received = receiveEvents(t, hostEvents, len(expected))
+	if !reflect.DeepEqual(expected, received) {
+		t.Errorf("Unexpected host events.\nexpected: %v\nreceived: %v",
+			expected, received)
+	}
+
+	// Events after removal only reach the Host observers
+	manager.RemoveObserver(observerId)
+	host.Connect()
+	receiveEvents(t, hostEvents, 1)
+	select {
+	case event := <-managerEvents:
+		t.Errorf("Manager observer received event after removal: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+	host.Disconnect()
+}
+
+// Tests that the disconnect cause and the proxy error threshold are reported
+// once per transition.
+func TestHost_notify_Causes(t *testing.T) {
+	p := GetDefaultHostParams()
+	p.ProxyErrorMetricParams.Cutoff = 0.17
+	host, err := NewHost(id.NewIdFromString("causes", id.Node, t),
+		ServerAddress, nil, p)
+	if err != nil {
+		t.Fatalf("Failed to create host: %+v", err)
+	}
+	events := make(chan HostEvent, 10)
+	host.AddObserver(func(event HostEvent) { events <- event })
+
+	proxyErr := errors.New(ProxyError)
+	f := func(Connection) (interface{}, error) { return nil, proxyErr }
+	for i := 0; i < 3; i++ {
+		_, _ = host.transmit(f)
+	}
+
+	if err = host.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %+v", err)
+	}
+	cause := errors.New("send failed")
+	host.connectionMux.Lock()
+	host.conditionalDisconnect(host.connectionCount, cause)
+	host.connectionMux.Unlock()
+
+	var received []HostEvent
+	for i := 0; i < 3; i++ {
+		select {
+		case event := <-events:
+			received = append(received, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for events; received %+v", received)
+		}
+	}
+
+	if received[0].Type != HostProxyErrorThreshold {
+		t.Errorf("Expected %s, received %s", HostProxyErrorThreshold,
+			received[0].Type)
+	}
+	if received[1].Type != HostConnected || received[1].ConnectionCount != 1 {
+		t.Errorf("Unexpected connect event: %+v", received[1])
+	}
+	if received[2].Type != HostDisconnected || received[2].Err != cause {
+		t.Errorf("Unexpected disconnect event: %+v", received[2])
+	}
+}
+
+// Tests that events for a blocked observer are bounded and that the dropped
+// events are counted on the next event delivered.
+func TestHostObservers_notify_Bounded(t *testing.T) {
+	var ho hostObservers
+	unblock := make(chan struct{})
+	events := make(chan HostEvent, 2*maxQueuedHostEvents)
+	ho.add(func(event HostEvent) {
+		<-unblock
+		events <- event
+	})
+
+	// The first event is taken by the blocked observer, the rest queue
+	total := 3 * maxQueuedHostEvents
+	ho.notify(HostEvent{ConnectionCount: 0})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ho.mux.Lock()
+		taken := len(ho.queue) == 0 && ho.running
+		ho.mux.Unlock()
+		if taken {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Observer did not take the first event")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 1; i < total; i++ {
+		ho.notify(HostEvent{ConnectionCount: uint64(i)})
+	}
+
+	ho.mux.Lock()
+	queued := len(ho.queue)
+	ho.mux.Unlock()
+	if queued > maxQueuedHostEvents {
+		t.Errorf("Queue is not bounded: %d events", queued)
+	}
+
+	close(unblock)
+	var received, dropped uint64
+	var last uint64
+	for received+dropped < uint64(total) {
+		select {
+		case event := <-events:
+			received++
+			dropped += event.Dropped
+			if received > 1 && event.ConnectionCount <= last {
+				t.Fatalf("Events out of order: %d after %d",
+					event.ConnectionCount, last)
+			}
+			last = event.ConnectionCount
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out; received %d, dropped %d", received, dropped)
+		}
+	}
+	if received != maxQueuedHostEvents+1 || last != uint64(total-1) {
+		t.Errorf("Unexpected delivery: received %d, dropped %d, last %d",
+			received, dropped, last)
+	}
+}
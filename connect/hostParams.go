@@ -8,6 +8,7 @@
 package connect
 
 import (
+	pb "gitlab.com/xx_network/comms/messages"
 	"gitlab.com/xx_network/primitives/exponential"
 	"google.golang.org/grpc/keepalive"
 	"time"
@@ -15,24 +16,74 @@ import (
 
 // HostParams is the configuration object for Host creation
 type HostParams struct {
-	// Set maximum number of connection attempts
+	// Set maximum number of connection and transmission attempts. Only used
+	// by the legacy retry policies, when ConnectRetryPolicy or
+	// SendRetryPolicy is nil.
 	MaxRetries uint32
 
 	// Set maximum number of transmission attempts
+	//
+	// Deprecated: Not read. Sends are retried according to SendRetryPolicy,
+	// or MaxRetries times if it is nil.
 	MaxSendRetries uint32
 
+	// Policy deciding how connection attempts are retried and how long each
+	// may take. Nil uses NewLegacyConnectRetryPolicy with MaxRetries, which
+	// is the default; GetDefaultConnectRetryPolicy returns a policy with
+	// exponential backoff.
+	ConnectRetryPolicy RetryPolicy
+
+	// Policy deciding how failed sends are retried. Nil uses
+	// NewLegacySendRetryPolicy with MaxRetries, which is the default;
+	// GetDefaultSendRetryPolicy returns a policy with exponential backoff.
+	SendRetryPolicy RetryPolicy
+
 	// Toggle authorization for Host
 	AuthEnabled bool
 
-	// Toggles connection cool off
+	// Scheme used to sign messages to the Host. ED25519 requires an ECC
+	// private key on the ProtoComms and the Host to know its public key.
+	SignatureScheme pb.SignatureScheme
+
+	// How long the transmission token is used before the handshake is run
+	// again to replace it. Should be shorter than the ReceptionTokenTTL of
+	// the server. Zero never replaces it.
+	TransmissionTokenTTL time.Duration
+
+	// If set, signed messages to the Host carry a timestamp and nonce which
+	// are covered by the signature, so that the Host can reject replays. The
+	// Host must support replay protection to verify them.
+	EnableReplayProtection bool
+
+	// If set, requests to the Host carry a MAC over the method name, the
+	// serialized request and a counter, keyed from the transmission token,
+	// instead of the token itself. Streams are MAC'd when opened over the
+	// method name and counter only, so their messages are not covered. Only
+	// supported on gRPC connections; Web hosts ignore it and send the raw
+	// token, which servers with RequireRequestMAC set reject.
+	EnableRequestMAC bool
+
+	// Toggles connection cool off. If set and CircuitBreaker is not enabled,
+	// the Host gets a circuit breaker which opens for CoolOffTimeout after
+	// NumSendsBeforeCoolOff consecutive failed sends.
+	//
+	// Deprecated: use CircuitBreaker.
 	EnableCoolOff bool
 
-	// Number of leaky bucket sends before it stops
+	// Number of consecutive failed sends before cool off
+	//
+	// Deprecated: use CircuitBreaker.ConsecutiveFailures.
 	NumSendsBeforeCoolOff uint32
 
 	// Amount of time after a cool off is triggered before allowed to send again
+	//
+	// Deprecated: use CircuitBreaker.OpenTimeout.
 	CoolOffTimeout time.Duration
 
+	// Configures the circuit breaker which stops sends to the Host while too
+	// many of them fail
+	CircuitBreaker CircuitBreakerParams
+
 	// Message send timeout (context deadline)
 	SendTimeout time.Duration
 
@@ -65,17 +116,25 @@ type HostParams struct {
 	// ConnectionType describes the method for the underlying host connection
 	ConnectionType ConnectionType
 	WebParams      WebConnParam
+
+	// Labels of the Host which Manager.Select can filter on, e.g. a region
+	Labels map[string]string
 }
 
-// GetDefaultHostParams Get default set of host params
+// GetDefaultHostParams Get default set of host params. The retry policies
+// are left nil, so connections and sends are retried as before RetryPolicy
+// was added, up to MaxRetries times.
 func GetDefaultHostParams() HostParams {
 	return HostParams{
 		MaxRetries:            100,
 		MaxSendRetries:        3,
 		AuthEnabled:           true,
+		SignatureScheme:       pb.SignatureScheme_RSA,
+		TransmissionTokenTTL:  20 * time.Hour,
 		EnableCoolOff:         false,
 		NumSendsBeforeCoolOff: 3,
 		CoolOffTimeout:        60 * time.Second,
+		CircuitBreaker:        GetDefaultCircuitBreakerParams(),
 		SendTimeout:           2 * time.Minute,
 		PingTimeout:           5 * time.Second,
 		EnableMetrics:         false,
@@ -0,0 +1,246 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// Contains the selection of hosts from a Manager by filter and strategy
+
+package connect
+
+import (
+	"github.com/pkg/errors"
+	"gitlab.com/xx_network/primitives/id"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoHostAvailable is returned by Manager.Select when no Host passes the
+// filter.
+var ErrNoHostAvailable = errors.New("no host matches the filter")
+
+// Window of the statistics used by the latency-aware strategies
+const selectionStatsWindow = time.Minute
+
+// HostFilter describes the hosts Manager.Select chooses from. A Host must
+// pass every set condition; the zero value passes every Host.
+type HostFilter struct {
+	// Types of ID the Host may have. Empty allows any type.
+	Types []id.Type
+
+	// If set, only hosts which are Connected, with an open connection which
+	// needs no authentication handshake, pass
+	Connected bool
+
+	// If set, hosts whose circuit breaker is open or whose last health check
+	// found them unhealthy do not pass
+	Available bool
+
+	// Labels the Host must have, with the same values
+	Labels map[string]string
+
+	// Custom condition, passing the Host if it returns true
+	Custom func(host *Host) bool
+}
+
+// passes returns true if the Host meets every condition of the filter.
+func (hf HostFilter) passes(host *Host) bool {
+	if len(hf.Types) > 0 {
+		hostType := host.id.GetType()
+		matched := false
+		for _, t := range hf.Types {
+			matched = matched || t == hostType
+		}
+		if !matched {
+			return false
+		}
+	}
+	if hf.Connected {
+		if connected, _ := host.Connected(); !connected {
+			return false
+		}
+	}
+	if hf.Available && (host.GetCircuitState() == CircuitOpen ||
+		host.GetHealth().IsUnhealthy()) {
+		return false
+	}
+	for key, value := range hf.Labels {
+		if label, ok := host.GetLabel(key); !ok || label != value {
+			return false
+		}
+	}
+	return hf.Custom == nil || hf.Custom(host)
+}
+
+// SelectionStrategy chooses one of the hosts which passed the filter of
+// Manager.Select. The hosts are never empty and are sorted by ID, so that
+// strategies may keep state across calls.
+type SelectionStrategy interface {
+	Choose(hosts []*Host) *Host
+}
+
+// SelectionStrategyFunc adapts a function to a SelectionStrategy.
+type SelectionStrategyFunc func(hosts []*Host) *Host
+
+// Choose calls the function.
+func (f SelectionStrategyFunc) Choose(hosts []*Host) *Host {
+	return f(hosts)
+}
+
+// Random chooses a Host uniformly at random.
+var Random SelectionStrategy = SelectionStrategyFunc(func(
+	hosts []*Host) *Host {
+	return hosts[rand.Intn(len(hosts))]
+})
+
+// LeastLatency chooses the Host with the lowest mean send latency in the last
+// minute. Hosts without sends in that time are preferred, so that they are
+// measured.
+var LeastLatency SelectionStrategy = SelectionStrategyFunc(func(
+	hosts []*Host) *Host {
+	best, bestLatency := hosts[0], selectionLatency(hosts[0])
+	for _, host := range hosts[1:] {
+		if latency := selectionLatency(host); latency < bestLatency {
+			best, bestLatency = host, latency
+		}
+	}
+	return best
+})
+
+// PowerOfTwoChoices picks two hosts at random and chooses the one with the
+// lower mean send latency in the last minute, which spreads load better than
+// LeastLatency when many clients select at once.
+var PowerOfTwoChoices SelectionStrategy = SelectionStrategyFunc(func(
+	hosts []*Host) *Host {
+	if len(hosts) == 1 {
+		return hosts[0]
+	}
+	i := rand.Intn(len(hosts))
+	j := rand.Intn(len(hosts) - 1)
+	if j >= i {
+		j++
+	}
+	if selectionLatency(hosts[j]) < selectionLatency(hosts[i]) {
+		return hosts[j]
+	}
+	return hosts[i]
+})
+
+// selectionLatency returns the mean send latency of the Host within the
+// selection window, weighed up by its failure rate.
+func selectionLatency(host *Host) time.Duration {
+	stats := host.GetWindowStats(selectionStatsWindow)
+	return time.Duration(float64(stats.Latency.GetMean()) *
+		(1 + stats.GetFailureRate()))
+}
+
+// RoundRobin is a SelectionStrategy choosing the hosts in turn.
+type RoundRobin struct {
+	next uint64
+}
+
+// NewRoundRobin creates a RoundRobin starting at the first Host.
+func NewRoundRobin() *RoundRobin {
+	return &RoundRobin{}
+}
+
+// Choose returns the Host after the one chosen last.
+func (rr *RoundRobin) Choose(hosts []*Host) *Host {
+	i := atomic.AddUint64(&rr.next, 1) - 1
+	return hosts[i%uint64(len(hosts))]
+}
+
+// Weighted is a SelectionStrategy choosing hosts at random in proportion to
+// their weight.
+type Weighted struct {
+	// Returns the weight of the Host. Hosts with a weight of zero or less
+	// are only chosen if every Host has one. Nil uses DefaultWeight.
+	Weight func(host *Host) float64
+}
+
+// DefaultWeight weighs hosts by the fraction of their sends which succeeded
+// in the last minute, divided by their mean send latency in seconds. Hosts
+// without sends in that time get the weight of a Host answering every send
+// in 100ms.
+func DefaultWeight(host *Host) float64 {
+	stats := host.GetWindowStats(selectionStatsWindow)
+	if stats.GetSends() == 0 {
+		return 10
+	}
+	latency := stats.Latency.GetMean().Seconds()
+	if latency < 0.001 {
+		latency = 0.001
+	}
+	return (1 - stats.GetFailureRate()) / latency
+}
+
+// Choose returns a random Host, chosen in proportion to its weight.
+func (w Weighted) Choose(hosts []*Host) *Host {
+	weight := w.Weight
+	if weight == nil {
+		weight = DefaultWeight
+	}
+
+	weights := make([]float64, len(hosts))
+	var total float64
+	for i, host := range hosts {
+		if weights[i] = weight(host); weights[i] > 0 {
+			total += weights[i]
+		}
+	}
+	if total <= 0 {
+		return Random.Choose(hosts)
+	}
+
+	target := rand.Float64() * total
+	for i, host := range hosts {
+		if weights[i] <= 0 {
+			continue
+		}
+		if target -= weights[i]; target < 0 {
+			return host
+		}
+	}
+	// Rounding may leave target at zero after the last Host
+	for i := len(hosts) - 1; ; i-- {
+		if weights[i] > 0 {
+			return hosts[i]
+		}
+	}
+}
+
+// Select chooses one of the hosts in the Manager which pass the filter using
+// the strategy. Returns ErrNoHostAvailable if no Host passes the filter.
+func (m *Manager) Select(filter HostFilter,
+	strategy SelectionStrategy) (*Host, error) {
+	hosts := m.Filter(filter)
+	if len(hosts) == 0 {
+		return nil, ErrNoHostAvailable
+	}
+	return strategy.Choose(hosts), nil
+}
+
+// Filter returns the hosts in the Manager which pass the filter, sorted by
+// ID.
+func (m *Manager) Filter(filter HostFilter) []*Host {
+	m.mux.RLock()
+	hosts := make([]*Host, 0, len(m.connections))
+	for _, host := range m.connections {
+		hosts = append(hosts, host)
+	}
+	m.mux.RUnlock()
+
+	passed := hosts[:0]
+	for _, host := range hosts {
+		if filter.passes(host) {
+			passed = append(passed, host)
+		}
+	}
+	sort.Slice(passed, func(i, j int) bool {
+		return passed[i].id.String() < passed[j].id.String()
+	})
+	return passed
+}
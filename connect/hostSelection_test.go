@@ -0,0 +1,139 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"github.com/pkg/errors"
+	"gitlab.com/xx_network/primitives/id"
+	"testing"
+	"time"
+)
+
+// makeSelectionManager returns a Manager with two nodes and a gateway, in
+// the order they are sorted by ID.
+func makeSelectionManager(t *testing.T) (*Manager, []*Host) {
+	m := newManager()
+	ids := []*id.ID{
+		id.NewIdFromString("selectA", id.Node, t),
+		id.NewIdFromString("selectB", id.Node, t),
+		id.NewIdFromString("selectC", id.Gateway, t),
+	}
+	hosts := make([]*Host, len(ids))
+	for i, hid := range ids {
+		params := GetDefaultHostParams()
+		params.AuthEnabled = false
+		params.Labels = map[string]string{"region": "eu"}
+		var err error
+		hosts[i], err = m.AddHost(hid, ServerAddress, nil, params)
+		if err != nil {
+			t.Fatalf("Failed to add host: %+v", err)
+		}
+	}
+	hosts[1].SetLabel("region", "us")
+	t.Cleanup(m.DisconnectAll)
+	return m, hosts
+}
+
+// Tests that Manager.Filter applies every condition of the HostFilter.
+func TestManager_Filter(t *testing.T) {
+	m, hosts := makeSelectionManager(t)
+	if err := hosts[2].Connect(); err != nil {
+		t.Fatalf("Failed to connect: %+v", err)
+	}
+	hosts[0].setHealth(HealthState{Err: errors.New("unreachable"),
+		Checked: time.Now()})
+
+	tests := []struct {
+		filter   HostFilter
+		expected []*Host
+	}{
+		{HostFilter{}, hosts},
+		{HostFilter{Types: []id.Type{id.Node}}, hosts[:2]},
+		{HostFilter{Connected: true}, hosts[2:]},
+		{HostFilter{Available: true}, hosts[1:]},
+		{HostFilter{Labels: map[string]string{"region": "eu"}},
+			[]*Host{hosts[0], hosts[2]}},
+		{HostFilter{Custom: func(host *Host) bool { return host == hosts[1] }},
+			hosts[1:2]},
+		{HostFilter{Types: []id.Type{id.Gateway},
+			Labels: map[string]string{"region": "us"}}, nil},
+	}
+	for i, tt := range tests {
+		received := m.Filter(tt.filter)
+		if len(received) != len(tt.expected) {
+			t.Errorf("Unexpected hosts for filter %d.\nexpected: %v"+
+				"\nreceived: %v", i, tt.expected, received)
+			continue
+		}
+		for j := range received {
+			if received[j] != tt.expected[j] {
+				t.Errorf("Unexpected host %d for filter %d: %s", j, i,
+					received[j])
+			}
+		}
+	}
+
+	if _, err := m.Select(tests[len(tests)-1].filter,
+		Random); !errors.Is(err, ErrNoHostAvailable) {
+		t.Errorf("Expected %q, received %+v", ErrNoHostAvailable, err)
+	}
+}
+
+// Tests that the selection strategies choose hosts as described.
+func TestManager_Select(t *testing.T) {
+	m, hosts := makeSelectionManager(t)
+	nodes := HostFilter{Types: []id.Type{id.Node}}
+	hosts[0].stats.observeSend(500*time.Millisecond, nil)
+	hosts[1].stats.observeSend(10*time.Millisecond, nil)
+
+	rr := NewRoundRobin()
+	for i := 0; i < 4; i++ {
+		host, err := m.Select(nodes, rr)
+		if err != nil || host != hosts[i%2] {
+			t.Errorf("RoundRobin chose %s on call %d: %+v", host, i, err)
+		}
+	}
+
+	for _, strategy := range []SelectionStrategy{LeastLatency,
+		PowerOfTwoChoices} {
+		for i := 0; i < 10; i++ {
+			if host, _ := m.Select(nodes, strategy); host != hosts[1] {
+				t.Errorf("%T chose the slower host %s", strategy, host)
+			}
+		}
+	}
+
+	// Hosts without sends are preferred by LeastLatency
+	if host, _ := m.Select(HostFilter{}, LeastLatency); host != hosts[2] {
+		t.Errorf("LeastLatency did not prefer the unmeasured host: %s", host)
+	}
+
+	weighted := Weighted{Weight: func(host *Host) float64 {
+		if host == hosts[2] {
+			return 1
+		}
+		return 0
+	}}
+	for i := 0; i < 10; i++ {
+		if host, _ := m.Select(HostFilter{}, weighted); host != hosts[2] {
+			t.Errorf("Weighted chose a host without weight: %s", host)
+		}
+	}
+
+	if DefaultWeight(hosts[1]) <= DefaultWeight(hosts[0]) {
+		t.Errorf("Faster host does not have a larger default weight")
+	}
+	seen := make(map[*Host]bool)
+	for i := 0; i < 100; i++ {
+		host, _ := m.Select(HostFilter{}, Random)
+		seen[host] = true
+	}
+	if len(seen) != len(hosts) {
+		t.Errorf("Random did not choose every host: %d", len(seen))
+	}
+}
@@ -0,0 +1,351 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// Contains the rolling latency and outcome statistics of hosts
+
+package connect
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/stats"
+	"sync"
+	"time"
+)
+
+// Width and number of the slots the recent statistics of a Host are kept in.
+// Windowed statistics cover at most statsSlots*statsSlotWidth.
+const (
+	statsSlotWidth = 10 * time.Second
+	statsSlots     = 60
+)
+
+// MaxStatsWindow is the longest window GetWindowStats covers.
+const MaxStatsWindow = statsSlots * statsSlotWidth
+
+// Class of failed sends whose error is in none of the known classes
+const unknownErrorClass = "UNKNOWN"
+
+// LatencyBuckets are the upper bounds of the buckets of send latency
+// histograms. Latencies above the last bound fall in an extra bucket.
+var LatencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// LatencyHistogram counts send latencies in the LatencyBuckets.
+type LatencyHistogram struct {
+	// Counts[i] is the number of latencies above LatencyBuckets[i-1] and at
+	// most LatencyBuckets[i]. The last count is of latencies above every
+	// bound.
+	Counts []uint64
+
+	// Number and total of the latencies
+	Count uint64
+	Sum   time.Duration
+}
+
+// newLatencyHistogram creates an empty LatencyHistogram.
+func newLatencyHistogram() LatencyHistogram {
+	return LatencyHistogram{Counts: make([]uint64, len(LatencyBuckets)+1)}
+}
+
+// observe counts a latency.
+func (lh *LatencyHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(LatencyBuckets) && d > LatencyBuckets[i] {
+		i++
+	}
+	lh.Counts[i]++
+	lh.Count++
+	lh.Sum += d
+}
+
+// add adds the counts of another histogram.
+func (lh *LatencyHistogram) add(other LatencyHistogram) {
+	for i, count := range other.Counts {
+		lh.Counts[i] += count
+	}
+	lh.Count += other.Count
+	lh.Sum += other.Sum
+}
+
+// GetMean returns the mean latency, zero if none was observed.
+func (lh LatencyHistogram) GetMean() time.Duration {
+	if lh.Count == 0 {
+		return 0
+	}
+	return lh.Sum / time.Duration(lh.Count)
+}
+
+// GetQuantile returns the upper bound of the bucket holding the q-quantile
+// of the latencies, for q between 0 and 1. Latencies above every bound are
+// reported as the last bound. Returns zero if no latency was observed.
+func (lh LatencyHistogram) GetQuantile(q float64) time.Duration {
+	if lh.Count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(lh.Count))
+	if rank >= lh.Count {
+		rank = lh.Count - 1
+	}
+	var seen uint64
+	for i, count := range lh.Counts {
+		seen += count
+		if seen > rank && i < len(LatencyBuckets) {
+			return LatencyBuckets[i]
+		}
+	}
+	return LatencyBuckets[len(LatencyBuckets)-1]
+}
+
+// HostStats are the latency and outcome statistics of the sends to a Host,
+// either since it was created or within a window.
+type HostStats struct {
+	// Number of send attempts which succeeded and failed
+	Successes uint64
+	Failures  uint64
+
+	// Failed send attempts by the reason of their error class, e.g.
+	// HOST_DISCONNECTED or AUTH_FAILED
+	FailuresByClass map[string]uint64
+
+	// Latencies of the send attempts
+	Latency LatencyHistogram
+
+	// Bytes sent to and received from the Host over gRPC connections
+	BytesSent     uint64
+	BytesReceived uint64
+
+	// Number and total duration of the handshakes with the Host
+	Handshakes        uint64
+	HandshakeDuration time.Duration
+
+	// Times of the last send attempt which succeeded and failed, zero if
+	// there was none
+	LastSuccess time.Time
+	LastFailure time.Time
+}
+
+// newHostStats creates empty HostStats.
+func newHostStats() HostStats {
+	return HostStats{
+		FailuresByClass: make(map[string]uint64),
+		Latency:         newLatencyHistogram(),
+	}
+}
+
+// GetSends returns the number of send attempts.
+func (hs HostStats) GetSends() uint64 {
+	return hs.Successes + hs.Failures
+}
+
+// GetFailureRate returns the fraction of send attempts which failed, zero if
+// there was none.
+func (hs HostStats) GetFailureRate() float64 {
+	if hs.GetSends() == 0 {
+		return 0
+	}
+	return float64(hs.Failures) / float64(hs.GetSends())
+}
+
+// GetMeanHandshakeDuration returns the mean duration of the handshakes, zero
+// if there was none.
+func (hs HostStats) GetMeanHandshakeDuration() time.Duration {
+	if hs.Handshakes == 0 {
+		return 0
+	}
+	return hs.HandshakeDuration / time.Duration(hs.Handshakes)
+}
+
+// add adds the statistics of another HostStats.
+func (hs *HostStats) add(other HostStats) {
+	hs.Successes += other.Successes
+	hs.Failures += other.Failures
+	for class, count := range other.FailuresByClass {
+		hs.FailuresByClass[class] += count
+	}
+	hs.Latency.add(other.Latency)
+	hs.BytesSent += other.BytesSent
+	hs.BytesReceived += other.BytesReceived
+	hs.Handshakes += other.Handshakes
+	hs.HandshakeDuration += other.HandshakeDuration
+	if other.LastSuccess.After(hs.LastSuccess) {
+		hs.LastSuccess = other.LastSuccess
+	}
+	if other.LastFailure.After(hs.LastFailure) {
+		hs.LastFailure = other.LastFailure
+	}
+}
+
+// deepCopy returns a copy of the HostStats which shares no memory with it.
+func (hs HostStats) deepCopy() HostStats {
+	c := newHostStats()
+	c.add(hs)
+	return c
+}
+
+// statsSlot holds the statistics of one slot of the recent window.
+type statsSlot struct {
+	start int64
+	stats HostStats
+}
+
+// hostStatsTracker tracks the statistics of a Host in total and in slots
+// covering the recent window. Reading them does not reset them.
+type hostStatsTracker struct {
+	total HostStats
+	slots [statsSlots]statsSlot
+	mux   sync.Mutex
+}
+
+// newHostStatsTracker creates an empty hostStatsTracker.
+func newHostStatsTracker() *hostStatsTracker {
+	return &hostStatsTracker{total: newHostStats()}
+}
+
+// update applies the change to the total and to the slot of the current
+// time.
+func (hs *hostStatsTracker) update(
+	change func(stats *HostStats, now time.Time)) {
+	now := time.Now()
+	hs.mux.Lock()
+	defer hs.mux.Unlock()
+	change(&hs.total, now)
+	change(&hs.slot(now).stats, now)
+}
+
+// slot returns the slot the time falls in, emptying it if it held the
+// statistics of an earlier window.
+// undefined behavior if the caller has not taken the lock
+func (hs *hostStatsTracker) slot(now time.Time) *statsSlot {
+	start := now.UnixNano() / int64(statsSlotWidth)
+	slot := &hs.slots[start%statsSlots]
+	if slot.start != start {
+		*slot = statsSlot{start: start, stats: newHostStats()}
+	}
+	return slot
+}
+
+// observeSend records the outcome and latency of a send attempt.
+func (hs *hostStatsTracker) observeSend(latency time.Duration, err error) {
+	// Sends abandoned by the caller say nothing about the Host
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	class := ""
+	if err != nil {
+		class = errorClassName(err)
+	}
+	hs.update(func(stats *HostStats, now time.Time) {
+		stats.Latency.observe(latency)
+		if err == nil {
+			stats.Successes++
+			stats.LastSuccess = now
+		} else {
+			stats.Failures++
+			stats.FailuresByClass[class]++
+			stats.LastFailure = now
+		}
+	})
+}
+
+// observeHandshake records the duration of a handshake.
+func (hs *hostStatsTracker) observeHandshake(d time.Duration) {
+	hs.update(func(stats *HostStats, _ time.Time) {
+		stats.Handshakes++
+		stats.HandshakeDuration += d
+	})
+}
+
+// observeBytes records bytes sent to and received from the Host.
+func (hs *hostStatsTracker) observeBytes(sent, received int) {
+	hs.update(func(stats *HostStats, _ time.Time) {
+		stats.BytesSent += uint64(sent)
+		stats.BytesReceived += uint64(received)
+	})
+}
+
+// get returns a copy of the statistics since the Host was created.
+func (hs *hostStatsTracker) get() HostStats {
+	hs.mux.Lock()
+	defer hs.mux.Unlock()
+	return hs.total.deepCopy()
+}
+
+// getWindow returns the statistics of the slots within the window.
+func (hs *hostStatsTracker) getWindow(window time.Duration) HostStats {
+	if window > MaxStatsWindow {
+		window = MaxStatsWindow
+	}
+	slots := int64((window + statsSlotWidth - 1) / statsSlotWidth)
+
+	hs.mux.Lock()
+	defer hs.mux.Unlock()
+	current := time.Now().UnixNano() / int64(statsSlotWidth)
+	stats := newHostStats()
+	for _, slot := range hs.slots {
+		if slot.stats.FailuresByClass != nil && current-slot.start < slots {
+			stats.add(slot.stats)
+		}
+	}
+	return stats
+}
+
+// GetStats returns the statistics of the sends to the Host since it was
+// created. Unlike GetMetrics, reading them does not reset them, so any
+// number of consumers may watch the same Host.
+func (h *Host) GetStats() HostStats {
+	return h.stats.get()
+}
+
+// GetWindowStats returns the statistics of the sends to the Host within the
+// window before now, in slots of ten seconds, for ranking hosts and alerting.
+// Windows longer than MaxStatsWindow are shortened to it.
+func (h *Host) GetWindowStats(window time.Duration) HostStats {
+	return h.stats.getWindow(window)
+}
+
+// hostStatsHandler is a gRPC stats.Handler counting the bytes sent to and
+// received from a Host.
+type hostStatsHandler struct {
+	stats *hostStatsTracker
+}
+
+// TagRPC returns the context unchanged.
+func (hsh *hostStatsHandler) TagRPC(ctx context.Context,
+	_ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+// HandleRPC counts the wire length of the payloads of the RPC.
+func (hsh *hostStatsHandler) HandleRPC(_ context.Context, s stats.RPCStats) {
+	switch payload := s.(type) {
+	case *stats.OutPayload:
+		hsh.stats.observeBytes(payload.WireLength, 0)
+	case *stats.InPayload:
+		hsh.stats.observeBytes(0, payload.WireLength)
+	}
+}
+
+// TagConn returns the context unchanged.
+func (hsh *hostStatsHandler) TagConn(ctx context.Context,
+	_ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+// HandleConn ignores connection events.
+func (hsh *hostStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
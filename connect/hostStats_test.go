@@ -0,0 +1,141 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	pb "gitlab.com/xx_network/comms/messages"
+	"gitlab.com/xx_network/primitives/id"
+	"testing"
+	"time"
+)
+
+// Tests that LatencyHistogram counts latencies in their buckets and
+// estimates the mean and quantiles.
+func TestLatencyHistogram(t *testing.T) {
+	lh := newLatencyHistogram()
+	if lh.GetMean() != 0 || lh.GetQuantile(0.5) != 0 {
+		t.Errorf("Empty histogram reported latencies")
+	}
+
+	for _, d := range []time.Duration{time.Millisecond, 7 * time.Millisecond,
+		7 * time.Millisecond, 20 * time.Second} {
+		lh.observe(d)
+	}
+	if lh.Count != 4 || lh.Counts[0] != 1 || lh.Counts[1] != 2 ||
+		lh.Counts[len(LatencyBuckets)] != 1 {
+		t.Errorf("Unexpected counts: %+v", lh)
+	}
+
+	expected := (time.Millisecond + 14*time.Millisecond + 20*time.Second) / 4
+	if lh.GetMean() != expected {
+		t.Errorf("Unexpected mean.\nexpected: %s\nreceived: %s", expected,
+			lh.GetMean())
+	}
+	quantiles := map[float64]time.Duration{
+		0:    5 * time.Millisecond,
+		0.5:  10 * time.Millisecond,
+		0.99: 10 * time.Second,
+	}
+	for q, bound := range quantiles {
+		if lh.GetQuantile(q) != bound {
+			t.Errorf("Unexpected %.2f quantile.\nexpected: %s\nreceived: %s",
+				q, bound, lh.GetQuantile(q))
+		}
+	}
+}
+
+// Tests that reading the statistics does not reset them and that the window
+// only covers recent slots.
+func TestHostStatsTracker(t *testing.T) {
+	hs := newHostStatsTracker()
+	hs.observeSend(time.Millisecond, nil)
+	hs.observeSend(time.Millisecond, errors.WithStack(ErrHostDisconnected))
+	hs.observeSend(time.Millisecond, errors.New("domain error"))
+	hs.observeSend(time.Millisecond, errors.WithStack(context.Canceled))
+	hs.observeHandshake(20 * time.Millisecond)
+	hs.observeBytes(10, 20)
+
+	for i := 0; i < 2; i++ {
+		stats := hs.get()
+		if stats.Successes != 1 || stats.Failures != 2 ||
+			stats.GetFailureRate() != 2.0/3 {
+			t.Errorf("Unexpected outcomes on read %d: %+v", i, stats)
+		}
+		if stats.FailuresByClass["HOST_DISCONNECTED"] != 1 ||
+			stats.FailuresByClass[unknownErrorClass] != 1 {
+			t.Errorf("Unexpected failure classes: %v", stats.FailuresByClass)
+		}
+		if stats.BytesSent != 10 || stats.BytesReceived != 20 ||
+			stats.GetMeanHandshakeDuration() != 20*time.Millisecond {
+			t.Errorf("Unexpected bytes or handshakes: %+v", stats)
+		}
+		if stats.LastSuccess.IsZero() || stats.LastFailure.IsZero() {
+			t.Errorf("Times of the last outcomes were not set")
+		}
+	}
+
+	if hs.getWindow(time.Minute).GetSends() != 3 {
+		t.Errorf("Window is missing recent sends")
+	}
+
+	// Age the slot past a one minute window
+	hs.mux.Lock()
+	slot := hs.slot(time.Now())
+	slot.start -= int64(time.Minute / statsSlotWidth)
+	hs.mux.Unlock()
+	if hs.getWindow(time.Minute).GetSends() != 0 {
+		t.Errorf("Window includes sends older than it")
+	}
+	if hs.getWindow(time.Hour).GetSends() != 3 {
+		t.Errorf("Longer window is missing sends")
+	}
+	if hs.get().GetSends() != 3 {
+		t.Errorf("Total lost sends of expired slots")
+	}
+}
+
+// Tests that sends through a Host update its statistics, including the
+// bytes sent over its gRPC connection.
+func TestHost_GetStats(t *testing.T) {
+	c := &ProtoComms{Manager: newManager()}
+	params := GetDefaultHostParams()
+	params.AuthEnabled = false
+	params.MaxRetries = 1
+	host, err := c.AddHost(id.NewIdFromString("stats", id.Node, t),
+		ServerAddress, nil, params)
+	if err != nil {
+		t.Fatalf("Failed to add host: %+v", err)
+	}
+	defer host.Disconnect()
+
+	_, err = c.transmit(host, func(conn Connection) (interface{}, error) {
+		ctx, cancel := host.GetMessagingContext()
+		defer cancel()
+		// The test server has no services, so the call is unimplemented
+		return nil, conn.GetGrpcConn().Invoke(ctx,
+			"/messages.Generic/AuthenticateToken", &pb.Ack{Error: "stats"},
+			&pb.Ack{})
+	})
+	if err == nil {
+		t.Fatalf("Call to an unimplemented method succeeded")
+	}
+
+	stats := host.GetStats()
+	if stats.Failures != 1 || stats.FailuresByClass[unknownErrorClass] != 1 ||
+		stats.Latency.Count != 1 {
+		t.Errorf("Send was not recorded: %+v", stats)
+	}
+	if stats.BytesSent == 0 {
+		t.Errorf("Bytes sent were not recorded")
+	}
+	if host.GetWindowStats(time.Minute).Failures != 1 {
+		t.Errorf("Send is missing from the window")
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"bytes"
 	"github.com/pkg/errors"
 	jww "github.com/spf13/jwalterweatherman"
+	"gitlab.com/xx_network/comms/connect/token"
+	"gitlab.com/xx_network/comms/testkeys"
 	"gitlab.com/xx_network/primitives/id"
 	"net"
 	"strings"
@@ -240,3 +242,66 @@ func TestHost_transmit_ProxyError(t *testing.T) {
 			"\nexpected: %s\nreceived: %+v", TooManyProxyError, err)
 	}
 }
+
+// Tests that UpdateCertificate replaces the certificate and public key of the
+// Host, closes its connection and clears its tokens.
+func TestHost_UpdateCertificate(t *testing.T) {
+	nodeCert := testkeys.LoadFromPath(testkeys.GetNodeCertPath())
+	gatewayCert := testkeys.LoadFromPath(testkeys.GetGatewayCertPath())
+	host, err := NewHost(id.NewIdFromString("rotating", id.Node, t),
+		ServerAddress, nodeCert, GetDefaultHostParams())
+	if err != nil {
+		t.Fatalf("Unable to create host: %+v", err)
+	}
+	oldKey := host.GetPubKey()
+
+	host.connectionOpen = true
+	tkn := token.Token{}
+	copy(tkn[:], "rotatingToken")
+	host.transmissionToken.Set(tkn)
+	host.receptionToken.Set(tkn)
+
+	if err = host.UpdateCertificate(gatewayCert); err != nil {
+		t.Fatalf("Failed to update certificate: %+v", err)
+	}
+	if !bytes.Equal(host.certificate, gatewayCert) {
+		t.Errorf("Certificate was not replaced")
+	}
+	if host.GetPubKey() == nil || host.GetPubKey().N.Cmp(oldKey.N) == 0 {
+		t.Errorf("Public key was not replaced")
+	}
+	if host.connectionOpen {
+		t.Errorf("Connection with the old certificate was not closed")
+	}
+	if host.transmissionToken.Has() || host.receptionToken.Has() {
+		t.Errorf("Tokens were not cleared")
+	}
+
+	// The circuit breaker is closed with the new certificate
+	params := GetDefaultHostParams()
+	params.CircuitBreaker.Enabled = true
+	params.CircuitBreaker.ConsecutiveFailures = 1
+	host.breaker = newCircuitBreaker(params.CircuitBreaker)
+	host.recordSend(false, ErrConnectFailed)
+	if host.GetCircuitState() != CircuitOpen {
+		t.Fatalf("Circuit breaker did not open")
+	}
+	if err = host.UpdateCertificate(nodeCert); err != nil {
+		t.Fatalf("Failed to update certificate: %+v", err)
+	}
+	if host.GetCircuitState() != CircuitClosed {
+		t.Errorf("Circuit breaker was not closed: %s",
+			host.GetCircuitState())
+	}
+	if err = host.UpdateCertificate(gatewayCert); err != nil {
+		t.Fatalf("Failed to update certificate: %+v", err)
+	}
+
+	// An invalid certificate leaves the Host unchanged
+	if err = host.UpdateCertificate([]byte("invalid")); err == nil {
+		t.Errorf("Updated to an invalid certificate")
+	}
+	if !bytes.Equal(host.certificate, gatewayCert) {
+		t.Errorf("Certificate changed on a failed update")
+	}
+}
@@ -0,0 +1,155 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// Contains the server interceptors which authenticate incoming requests
+
+package connect
+
+import (
+	"context"
+	jww "github.com/spf13/jwalterweatherman"
+	pb "gitlab.com/xx_network/comms/messages"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// authContextKey is the context key under which the interceptors store the
+// Auth of a request.
+type authContextKey struct{}
+
+// AuthFromContext returns the Auth stored in the context by the server
+// interceptors. Returns false if the method does not require authentication
+// or the context did not pass through the interceptors.
+func AuthFromContext(ctx context.Context) (*Auth, bool) {
+	auth, ok := ctx.Value(authContextKey{}).(*Auth)
+	return auth, ok
+}
+
+// RequireAuthentication marks the given full gRPC method names (e.g.
+// "/messages.Generic/AuthenticateToken") as requiring authentication. Calls
+// to these methods are authenticated by the server interceptors before
+// reaching the handler and rejected with codes.Unauthenticated on failure.
+// The resulting Auth can be retrieved in the handler with AuthFromContext.
+func (c *ProtoComms) RequireAuthentication(methods ...string) {
+	c.authMethodsMux.Lock()
+	defer c.authMethodsMux.Unlock()
+
+	if c.authMethods == nil {
+		c.authMethods = make(map[string]struct{}, len(methods))
+	}
+	for _, method := range methods {
+		c.authMethods[method] = struct{}{}
+	}
+}
+
+// requiresAuthentication returns true if the full method name was marked by
+// RequireAuthentication.
+func (c *ProtoComms) requiresAuthentication(method string) bool {
+	c.authMethodsMux.RLock()
+	defer c.authMethodsMux.RUnlock()
+	_, ok := c.authMethods[method]
+	return ok
+}
+
+// authenticateRequest authenticates a request using the AuthenticatedMessage
+// if one is given, otherwise using the authentication packed into the
+// context. The request body, covered by a request MAC, is nil for streams.
+// Returns a gRPC status error if authentication fails.
+func (c *ProtoComms) authenticateRequest(ctx context.Context,
+	msg *pb.AuthenticatedMessage, req interface{}, method string) (*Auth,
+	error) {
+	var err error
+	if msg == nil {
+		msg, err = UnpackAuthenticatedContext(ctx)
+		if err != nil {
+			jww.DEBUG.Printf("Rejected unauthenticated call to %s: %+v",
+				method, err)
+			return nil, status.Errorf(codes.Unauthenticated, "%s: %s",
+				baseAuthErr, err)
+		}
+	}
+
+	auth, err := c.authenticatedReceiver(msg, ctx, req)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "%s: %s",
+			baseAuthErr, err)
+	}
+
+	if !auth.IsAuthenticated {
+		jww.DEBUG.Printf("Rejected unauthenticated call to %s: %s",
+			method, auth.Reason)
+		return nil, status.Errorf(codes.Unauthenticated, "%s: %s",
+			AuthError(auth.Sender.id), auth.Reason)
+	}
+
+	return auth, nil
+}
+
+// authUnaryInterceptor authenticates unary calls to methods which require
+// authentication, enforces their MethodPolicy and stores the Auth in the
+// handler context. Errors returned by the handler are converted to gRPC
+// status errors describing their class.
+func (c *ProtoComms) authUnaryInterceptor(ctx context.Context,
+	req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	if !c.requiresAuthentication(info.FullMethod) {
+		resp, err := handler(ctx, req)
+		return resp, ToGrpcStatus(err)
+	}
+
+	msg, _ := req.(*pb.AuthenticatedMessage)
+	auth, err := c.authenticateRequest(ctx, msg, req, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.authorizeRequest(auth, info.FullMethod); err != nil {
+		return nil, ToGrpcStatus(err)
+	}
+
+	resp, err := handler(context.WithValue(ctx, authContextKey{}, auth), req)
+	return resp, ToGrpcStatus(err)
+}
+
+// authStreamInterceptor authenticates streams to methods which require
+// authentication, enforces their MethodPolicy and stores the Auth in the
+// stream context. Errors returned by the handler are converted to gRPC
+// status errors describing their class.
+func (c *ProtoComms) authStreamInterceptor(srv interface{},
+	ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	if !c.requiresAuthentication(info.FullMethod) {
+		return ToGrpcStatus(handler(srv, ss))
+	}
+
+	// The request MAC of a stream covers only its method and counter
+	auth, err := c.authenticateRequest(ss.Context(), nil, nil,
+		info.FullMethod)
+	if err != nil {
+		return err
+	}
+	if err = c.authorizeRequest(auth, info.FullMethod); err != nil {
+		return ToGrpcStatus(err)
+	}
+
+	return ToGrpcStatus(handler(srv, &authServerStream{
+		ServerStream: ss,
+		ctx:          context.WithValue(ss.Context(), authContextKey{}, auth),
+	}))
+}
+
+// authServerStream wraps a grpc.ServerStream to replace its context with one
+// carrying the Auth of the stream.
+type authServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the context of the stream including its Auth.
+func (s *authServerStream) Context() context.Context {
+	return s.ctx
+}
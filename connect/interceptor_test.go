@@ -0,0 +1,142 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"context"
+	"gitlab.com/xx_network/comms/connect/token"
+	pb "gitlab.com/xx_network/comms/messages"
+	"gitlab.com/xx_network/primitives/id"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+// authCheckServer is a Generic server whose handlers report the Auth found
+// in their context.
+type authCheckServer struct {
+	pb.UnimplementedGenericServer
+}
+
+func (s *authCheckServer) RequestToken(ctx context.Context, _ *pb.Ping) (*pb.AssignToken, error) {
+	auth, ok := AuthFromContext(ctx)
+	if !ok {
+		return &pb.AssignToken{}, nil
+	}
+	return &pb.AssignToken{Token: auth.Sender.GetId().Bytes()}, nil
+}
+
+func (s *authCheckServer) AuthenticateToken(ctx context.Context, _ *pb.AuthenticatedMessage) (*pb.Ack, error) {
+	auth, ok := AuthFromContext(ctx)
+	if !ok {
+		return &pb.Ack{Error: "no auth in context"}, nil
+	}
+	return &pb.Ack{Error: auth.Reason}, nil
+}
+
+// Tests that the interceptors reject unauthenticated calls to methods which
+// require authentication, pass through other methods, and store the Auth of
+// authenticated calls in the handler context.
+func TestProtoComms_RequireAuthentication(t *testing.T) {
+	serverID := id.NewIdFromString("server", id.Node, t)
+	pc, err := StartCommServer(serverID, "127.0.0.1:11433", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	defer pc.Shutdown(context.Background())
+	pc.RequireAuthentication("/messages.Generic/RequestToken",
+		"/messages.Generic/AuthenticateToken")
+	pb.RegisterGenericServer(pc.GetServer(), &authCheckServer{})
+	pc.Serve()
+
+	// Set up the client and the matching tokens on both sides
+	clientID := id.NewIdFromString("client", id.Node, t)
+	client, err := CreateCommClient(clientID, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverHost, err := client.AddHost(serverID, "127.0.0.1:11433", nil,
+		GetDefaultHostParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientHost, err := pc.AddHost(clientID, "", nil, GetDefaultHostParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tkn := token.Token{}
+	copy(tkn[:], "interceptorToken")
+	serverHost.transmissionToken.Set(tkn)
+	clientHost.receptionToken.Set(tkn)
+
+	conn, err := grpc.Dial("127.0.0.1:11433",
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	gc := pb.NewGenericClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Missing headers are rejected without reaching the handler
+	_, err = gc.RequestToken(ctx, &pb.Ping{})
+	if status.Code(err) != codes.Unauthenticated || !IsAuthError(err) {
+		t.Errorf("Expected an Unauthenticated auth error, received: %+v", err)
+	}
+
+	// A packed context is authenticated and the Auth reaches the handler
+	resp, err := gc.RequestToken(client.PackAuthenticatedContext(serverHost, ctx),
+		&pb.Ping{})
+	if err != nil {
+		t.Fatalf("Authenticated call failed: %+v", err)
+	}
+	if !clientID.Cmp(id.NewIdFromBytes(resp.Token, t)) {
+		t.Errorf("Handler did not receive the sender in its Auth")
+	}
+
+	// An AuthenticatedMessage request is authenticated using the message
+	msg, err := client.PackAuthenticatedMessage(&pb.Ping{}, serverHost, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack, err := gc.AuthenticateToken(ctx, msg)
+	if err != nil {
+		t.Fatalf("Authenticated message call failed: %+v", err)
+	}
+	if ack.Error != "authenticated" {
+		t.Errorf("Handler did not receive the Auth: %s", ack.Error)
+	}
+
+	// A wrong token is rejected
+	wrong := token.Token{}
+	copy(wrong[:], "wrongToken")
+	msg.Token = wrong.Marshal()
+	_, err = gc.AuthenticateToken(ctx, msg)
+	if status.Code(err) != codes.Unauthenticated || !IsAuthError(err) {
+		t.Errorf("Expected an Unauthenticated auth error, received: %+v", err)
+	}
+}
+
+// Tests that methods not marked by RequireAuthentication are not
+// authenticated.
+func TestProtoComms_RequireAuthentication_Unmarked(t *testing.T) {
+	pc := &ProtoComms{}
+	pc.RequireAuthentication("/messages.Generic/AuthenticateToken")
+
+	if pc.requiresAuthentication("/messages.Generic/RequestToken") {
+		t.Errorf("Unmarked method requires authentication")
+	}
+	if !pc.requiresAuthentication("/messages.Generic/AuthenticateToken") {
+		t.Errorf("Marked method does not require authentication")
+	}
+}
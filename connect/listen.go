@@ -0,0 +1,59 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// Contains functionality for opening server listeners
+
+package connect
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	jww "github.com/spf13/jwalterweatherman"
+	"net"
+	"strings"
+	"time"
+)
+
+const addressInUseErr = "address already in use"
+
+// Listen opens a net.Listener on the given address using the network, TCP
+// tuning and bind retry policy in opts. If the address is in use, binding is
+// retried until BindRetry.MaxAttempts is reached or ctx is done.
+func Listen(ctx context.Context, address string,
+	opts ServerOptions) (net.Listener, error) {
+	network := opts.Network
+	if network == "" {
+		network = "tcp"
+	}
+
+	lc := net.ListenConfig{KeepAlive: opts.TcpKeepAlive}
+	for attempt := uint32(1); ; attempt++ {
+		lis, err := lc.Listen(ctx, network, address)
+		if err == nil {
+			return lis, nil
+		}
+
+		if !strings.Contains(err.Error(), addressInUseErr) ||
+			attempt >= opts.BindRetry.MaxAttempts {
+			return nil, errors.WithMessagef(err,
+				"Could not listen on %s after %d attempts", address, attempt)
+		}
+
+		jww.WARN.Printf("Could not listen on %s, is port in use? waiting "+
+			"%s (attempt %d/%d): %s", address, opts.BindRetry.Delay, attempt,
+			opts.BindRetry.MaxAttempts, err)
+
+		timer := time.NewTimer(opts.BindRetry.Delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, errors.WithMessagef(ctx.Err(),
+				"Stopped listening on %s after %d attempts", address, attempt)
+		case <-timer.C:
+		}
+	}
+}
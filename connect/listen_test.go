@@ -0,0 +1,208 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	pb "gitlab.com/xx_network/comms/messages"
+	"gitlab.com/xx_network/primitives/id"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests that Listen fails immediately when the address is in use and only a
+// single attempt is allowed.
+func TestListen_NoRetry(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	opts := GetDefaultServerOptions()
+	opts.BindRetry = BindRetryParams{MaxAttempts: 1, Delay: time.Hour}
+
+	start := time.Now()
+	_, err = Listen(context.Background(), lis.Addr().String(), opts)
+	if err == nil || !strings.Contains(err.Error(), addressInUseErr) {
+		t.Fatalf("Listen did not fail with an address in use error: %+v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Listen waited before failing: %s", time.Since(start))
+	}
+}
+
+// Tests that Listen retries up to the maximum number of attempts.
+func TestListen_BoundedRetry(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	opts := GetDefaultServerOptions()
+	opts.BindRetry = BindRetryParams{MaxAttempts: 3, Delay: 10 * time.Millisecond}
+
+	_, err = Listen(context.Background(), lis.Addr().String(), opts)
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Fatalf("Listen did not give up after 3 attempts: %+v", err)
+	}
+}
+
+// Tests that Listen stops retrying when the context is cancelled.
+func TestListen_ContextCancelled(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	opts := GetDefaultServerOptions()
+	opts.BindRetry = BindRetryParams{MaxAttempts: 100, Delay: time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = Listen(ctx, lis.Addr().String(), opts)
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Fatalf("Listen did not stop on context cancellation: %+v", err)
+	}
+}
+
+// Tests that starting and restarting a server stop retrying to bind the
+// listening address when the context is done.
+func TestStartCommServerWithContext_Cancelled(t *testing.T) {
+	addr := "127.0.0.1:11450"
+	opts := GetDefaultServerOptions()
+	opts.BindRetry = BindRetryParams{MaxAttempts: 100, Delay: time.Hour}
+
+	pc, err := StartCommServerWithContext(context.Background(),
+		id.NewIdFromString("restart", id.Node, t), addr, nil, nil, nil, opts)
+	if err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	if err = pc.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = StartCommServerWithContext(ctx,
+		id.NewIdFromString("blocked", id.Node, t), addr, nil, nil, nil, opts)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Start did not stop on context cancellation: %+v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = pc.RestartWithContext(ctx); !errors.Is(err,
+		context.DeadlineExceeded) {
+		t.Errorf("Restart did not stop on context cancellation: %+v", err)
+	}
+
+	// A failed restart leaves the server stopped
+	resp, err := pc.getHealthServer().Check(context.Background(),
+		&healthpb.HealthCheckRequest{})
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Server reports serving after a failed restart: %v %+v",
+			resp, err)
+	}
+	if pc.tokenCleanupStop != nil {
+		t.Errorf("Token cleanup was started by a failed restart")
+	}
+	_ = pc.Shutdown(context.Background())
+}
+
+// Tests that a server started on an ephemeral port reports the real address
+// and can be reached on it.
+func TestStartCommServerWithListener_EphemeralPort(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := StartCommServerWithListener(
+		id.NewIdFromString("ephemeral", id.Node, t), lis, nil, nil, nil,
+		GetDefaultServerOptions())
+	if err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	defer pc.Shutdown(context.Background())
+
+	if pc.GetListeningAddress() != lis.Addr().String() ||
+		strings.HasSuffix(pc.GetListeningAddress(), ":0") {
+		t.Errorf("Unexpected listening address.\nexpected: %s\nreceived: %s",
+			lis.Addr(), pc.GetListeningAddress())
+	}
+
+	pb.RegisterGenericServer(pc.GetServer(), &TestGenericServer{resp: "hello"})
+	pc.Serve()
+
+	conn, err := grpc.Dial(pc.GetListeningAddress(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := newContext(5 * time.Second)
+	defer cancel()
+	resp, err := pb.NewGenericClient(conn).RequestToken(ctx, &pb.Ping{})
+	if err != nil {
+		t.Fatalf("Failed to send to ephemeral server: %+v", err)
+	}
+	if string(resp.Token) != "hello" {
+		t.Errorf("Unexpected response: %s", resp.Token)
+	}
+}
+
+// Tests that a server can be served over a unix domain socket.
+func TestStartCommServerWithOptions_Unix(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "comms.sock")
+
+	opts := GetDefaultServerOptions()
+	opts.Network = "unix"
+	pc, err := StartCommServerWithOptions(
+		id.NewIdFromString("unix", id.Node, t), socket, nil, nil, nil, opts)
+	if err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	defer pc.Shutdown(context.Background())
+
+	pb.RegisterGenericServer(pc.GetServer(), &TestGenericServer{resp: "unix"})
+	pc.Serve()
+
+	conn, err := grpc.Dial("unix://"+socket,
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := newContext(5 * time.Second)
+	defer cancel()
+	resp, err := pb.NewGenericClient(conn).RequestToken(ctx, &pb.Ping{})
+	if err != nil {
+		t.Fatalf("Failed to send over unix socket: %+v", err)
+	}
+	if string(resp.Token) != "unix" {
+		t.Errorf("Unexpected response: %s", resp.Token)
+	}
+}
@@ -12,6 +12,7 @@ package connect
 import (
 	"bytes"
 	"fmt"
+	"github.com/pkg/errors"
 	jww "github.com/spf13/jwalterweatherman"
 	"gitlab.com/xx_network/primitives/id"
 	"sync"
@@ -25,6 +26,18 @@ type Manager struct {
 	// A map of id.IDs to Hosts
 	connections map[id.ID]*Host
 	mux         sync.RWMutex
+
+	// Observers of the lifecycle events of every Host in the Manager
+	observers hostObservers
+
+	// Bounds on the open connections, the channel stopping the idle monitor,
+	// whether connections are being closed to enforce the limit and whether
+	// another pass was requested meanwhile
+	limits       ConnectionLimits
+	limitsMux    sync.RWMutex
+	idleStop     chan struct{}
+	evicting     uint32
+	evictPending uint32
 }
 
 func newManager() *Manager {
@@ -59,6 +72,13 @@ func (m *Manager) GetHost(hostId *id.ID) (*Host, bool) {
 	return host, ok
 }
 
+// GetAvailableHosts returns the Hosts whose circuit breaker is not open and
+// whose last health check did not find them unhealthy, sorted by ID, so that
+// pools can skip hosts which would fail without dialing.
+func (m *Manager) GetAvailableHosts() []*Host {
+	return m.Filter(HostFilter{Available: true})
+}
+
 // Creates and adds a Host object to the Manager using the given id
 func (m *Manager) AddHost(hid *id.ID, address string,
 	cert []byte, params HostParams) (host *Host, err error) {
@@ -86,13 +106,50 @@ func (m *Manager) AddHost(hid *id.ID, address string,
 func (m *Manager) addHost(host *Host) {
 	jww.DEBUG.Printf("Adding host: %s", host)
 	m.connections[*(host.id)] = host
+
+	host.connectionMux.Lock()
+	host.manager = m
+	host.notify(HostAdded, nil)
+	host.connectionMux.Unlock()
+}
+
+// UpdateHost changes the address and certificate of the Host with the given
+// ID in place, keeping its metrics and observers. Its connection is closed,
+// its tokens cleared and its circuit breaker closed, so it reconnects and
+// authenticates again on the next send. The Host is unchanged if the
+// certificate is invalid.
+func (m *Manager) UpdateHost(hid *id.ID, address string,
+	cert []byte) (*Host, error) {
+	host, ok := m.GetHost(hid)
+	if !ok {
+		return nil, errors.Errorf("Cannot update host %s: host not found", hid)
+	}
+	if err := host.update(address, cert); err != nil {
+		return nil, errors.WithMessagef(err, "Cannot update host %s", hid)
+	}
+	return host, nil
 }
 
-// Removes a host from the connection manager
+// Removes a host from the connection manager and closes its connection. The
+// connection is closed after the host is removed, so that waiting for sends
+// to it does not hold up other users of the Manager.
 func (m *Manager) RemoveHost(hid *id.ID) {
 	m.mux.Lock()
-	defer m.mux.Unlock()
+	host, ok := m.connections[*hid]
+	if !ok {
+		m.mux.Unlock()
+		return
+	}
 	delete(m.connections, *hid)
+	m.mux.Unlock()
+
+	host.StopHealthProber()
+
+	host.connectionMux.Lock()
+	host.disconnectWithCause(ErrHostRemoved)
+	host.notify(HostRemoved, nil)
+	host.manager = nil
+	host.connectionMux.Unlock()
 }
 
 // Closes all client connections and removes them from Manager
@@ -8,6 +8,7 @@
 package connect
 
 import (
+	"bytes"
 	"gitlab.com/xx_network/comms/testkeys"
 	"gitlab.com/xx_network/primitives/id"
 	"google.golang.org/grpc"
@@ -15,6 +16,7 @@ import (
 	"net"
 	"os"
 	"testing"
+	"time"
 )
 
 const ServerAddress = "0.0.0.0:5556"
@@ -192,3 +194,94 @@ func TestConnectionManager_RemoveHost(t *testing.T) {
 	// Removing the host again shouldn't cause any panics or problems
 	manager.RemoveHost(id)
 }
+
+// Tests that RemoveHost does not block the Manager while the connection of
+// the Host is in use.
+func TestConnectionManager_RemoveHost_Busy(t *testing.T) {
+	manager := newManager()
+	busyId := id.NewIdFromString("busy", id.Gateway, t)
+	otherId := id.NewIdFromString("other", id.Gateway, t)
+	busy := &Host{id: busyId}
+	manager.addHost(busy)
+	manager.addHost(&Host{id: otherId})
+
+	// Hold the connection as an in-flight send would
+	busy.connectionMux.RLock()
+	removed := make(chan struct{})
+	go func() {
+		manager.RemoveHost(busyId)
+		close(removed)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		if _, ok := manager.GetHost(busyId); !ok {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("Host was not removed from the Manager")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if _, ok := manager.GetHost(otherId); !ok {
+		t.Errorf("Other host is missing from the Manager")
+	}
+	select {
+	case <-removed:
+		t.Errorf("RemoveHost returned before the send finished")
+	default:
+	}
+
+	busy.connectionMux.RUnlock()
+	select {
+	case <-removed:
+	case <-deadline:
+		t.Fatalf("RemoveHost did not return after the send finished")
+	}
+}
+
+// Tests that UpdateHost changes the address and certificate of a Host in
+// place, keeping the same Host in the Manager.
+func TestManager_UpdateHost(t *testing.T) {
+	manager := newManager()
+	hid := id.NewIdFromString("rotating", id.Node, t)
+	host, err := manager.AddHost(hid, ServerAddress,
+		testkeys.LoadFromPath(testkeys.GetNodeCertPath()),
+		GetDefaultHostParams())
+	if err != nil {
+		t.Fatalf("Failed to add host: %+v", err)
+	}
+	host.stats.observeSend(time.Millisecond, nil)
+
+	gatewayCert := testkeys.LoadFromPath(testkeys.GetGatewayCertPath())
+	updated, err := manager.UpdateHost(hid, ServerAddress2, gatewayCert)
+	if err != nil {
+		t.Fatalf("Failed to update host: %+v", err)
+	}
+	if updated != host {
+		t.Errorf("UpdateHost replaced the Host")
+	}
+	if host.GetAddress() != ServerAddress2 {
+		t.Errorf("Address was not updated: %s", host.GetAddress())
+	}
+	if !bytes.Equal(host.certificate, gatewayCert) {
+		t.Errorf("Certificate was not updated")
+	}
+	if host.GetStats().Successes != 1 {
+		t.Errorf("Statistics were lost on update")
+	}
+
+	// Invalid certificates and unknown hosts are rejected without changes
+	if _, err = manager.UpdateHost(hid, ServerAddress, []byte("bad")); err == nil {
+		t.Errorf("Updated host with an invalid certificate")
+	}
+	if host.GetAddress() != ServerAddress2 {
+		t.Errorf("Address changed on a failed update")
+	}
+	_, err = manager.UpdateHost(id.NewIdFromString("unknown", id.Node, t),
+		ServerAddress, gatewayCert)
+	if err == nil {
+		t.Errorf("Updated an unknown host")
+	}
+}
@@ -0,0 +1,290 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// Contains a metrics registry exported in the OpenMetrics text format
+
+package connect
+
+import (
+	"bytes"
+	"fmt"
+	jww "github.com/spf13/jwalterweatherman"
+	"gitlab.com/xx_network/primitives/exponential"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OpenMetricsContentType is the content type of the text exported by a
+// MetricsRegistry.
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// Name of the collector registered by every ProtoComms
+const connectCollector = "connect"
+
+// MetricsCollector writes the current value of a set of metrics. It is called
+// on every scrape, so it must be cheap and thread safe.
+type MetricsCollector func(w *MetricsWriter)
+
+// MetricsRegistry gathers metrics from registered collectors and exports
+// them in the OpenMetrics text format. It implements http.Handler so that it
+// can be served on any mux.
+type MetricsRegistry struct {
+	collectors map[string]MetricsCollector
+	mux        sync.RWMutex
+}
+
+// NewMetricsRegistry creates an empty MetricsRegistry.
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		collectors: make(map[string]MetricsCollector),
+	}
+}
+
+// Register adds a collector under the given name, replacing any collector
+// already registered under it.
+func (mr *MetricsRegistry) Register(name string, collector MetricsCollector) {
+	mr.mux.Lock()
+	defer mr.mux.Unlock()
+	mr.collectors[name] = collector
+}
+
+// Unregister removes the collector registered under the given name.
+func (mr *MetricsRegistry) Unregister(name string) {
+	mr.mux.Lock()
+	defer mr.mux.Unlock()
+	delete(mr.collectors, name)
+}
+
+// Write gathers all registered collectors and writes the result to w in the
+// OpenMetrics text format. Collectors run in the order of their names.
+func (mr *MetricsRegistry) Write(w io.Writer) error {
+	mr.mux.RLock()
+	names := make([]string, 0, len(mr.collectors))
+	for name := range mr.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	collectors := make([]MetricsCollector, len(names))
+	for i, name := range names {
+		collectors[i] = mr.collectors[name]
+	}
+	mr.mux.RUnlock()
+
+	mw := &MetricsWriter{families: make(map[string]*metricFamily)}
+	for _, collect := range collectors {
+		collect(mw)
+	}
+
+	_, err := w.Write(mw.bytes())
+	return err
+}
+
+// ServeHTTP writes the gathered metrics as the response.
+func (mr *MetricsRegistry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	var buf bytes.Buffer
+	if err := mr.Write(&buf); err != nil {
+		jww.ERROR.Printf("Failed to gather metrics: %+v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", OpenMetricsContentType)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// MetricsWriter accumulates the samples written by collectors, grouping them
+// into metric families.
+type MetricsWriter struct {
+	families map[string]*metricFamily
+	order    []string
+}
+
+// metricFamily is a set of samples sharing a name, type and help text.
+type metricFamily struct {
+	name, kind, help string
+	samples          []string
+}
+
+// Counter writes a sample of a monotonically increasing counter. The name
+// must not include the "_total" suffix, which is added on export. Labels are
+// given as alternating names and values.
+func (mw *MetricsWriter) Counter(name, help string, value float64,
+	labels ...string) {
+	mw.sample(name, "counter", help, "_total", value, labels)
+}
+
+// Gauge writes a sample of a value which can go up and down. Labels are given
+// as alternating names and values.
+func (mw *MetricsWriter) Gauge(name, help string, value float64,
+	labels ...string) {
+	mw.sample(name, "gauge", help, "", value, labels)
+}
+
+// Summary writes the count and sum of a set of observations. Labels are given
+// as alternating names and values.
+func (mw *MetricsWriter) Summary(name, help string, count uint64, sum float64,
+	labels ...string) {
+	mw.sample(name, "summary", help, "_count", float64(count), labels)
+	mw.sample(name, "summary", help, "_sum", sum, labels)
+}
+
+// sample adds a sample to its family, creating the family on first use.
+func (mw *MetricsWriter) sample(name, kind, help, suffix string,
+	value float64, labels []string) {
+	family, ok := mw.families[name]
+	if !ok {
+		family = &metricFamily{name: name, kind: kind, help: help}
+		mw.families[name] = family
+		mw.order = append(mw.order, name)
+	} else if family.kind != kind {
+		jww.WARN.Printf("Dropping %s sample for metric %s of type %s",
+			kind, name, family.kind)
+		return
+	}
+
+	var line strings.Builder
+	line.WriteString(name + suffix)
+	if len(labels) > 1 {
+		line.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				line.WriteByte(',')
+			}
+			line.WriteString(labels[i] + `="` +
+				escapeLabelValue(labels[i+1]) + `"`)
+		}
+		line.WriteByte('}')
+	}
+	line.WriteString(" " + strconv.FormatFloat(value, 'g', -1, 64))
+	family.samples = append(family.samples, line.String())
+}
+
+// bytes returns the accumulated families in the OpenMetrics text format.
+func (mw *MetricsWriter) bytes() []byte {
+	var buf bytes.Buffer
+	for _, name := range mw.order {
+		family := mw.families[name]
+		_, _ = fmt.Fprintf(&buf, "# TYPE %s %s\n", family.name, family.kind)
+		_, _ = fmt.Fprintf(&buf, "# HELP %s %s\n", family.name,
+			escapeHelp(family.help))
+		for _, s := range family.samples {
+			buf.WriteString(s + "\n")
+		}
+	}
+	buf.WriteString("# EOF\n")
+	return buf.Bytes()
+}
+
+// escapeLabelValue escapes backslashes, quotes and new lines in label values.
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// escapeHelp escapes backslashes and new lines in help text.
+func escapeHelp(v string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(v)
+}
+
+// latencySummary tracks the count and total duration of an operation.
+type latencySummary struct {
+	count uint64
+	nanos uint64
+}
+
+// observe records a single duration.
+func (ls *latencySummary) observe(d time.Duration) {
+	atomic.AddUint64(&ls.count, 1)
+	atomic.AddUint64(&ls.nanos, uint64(d))
+}
+
+// get returns the number of observations and their total in seconds.
+func (ls *latencySummary) get() (uint64, float64) {
+	return atomic.LoadUint64(&ls.count),
+		time.Duration(atomic.LoadUint64(&ls.nanos)).Seconds()
+}
+
+// GetMetricsRegistry returns the MetricsRegistry of the ProtoComms. It always
+// contains the connection metrics of the ProtoComms; other packages, such as
+// gossip, register their own collectors on it.
+func (c *ProtoComms) GetMetricsRegistry() *MetricsRegistry {
+	c.metricsOnce.Do(func() {
+		c.metricsRegistry = NewMetricsRegistry()
+		c.metricsRegistry.Register(connectCollector, c.collectMetrics)
+	})
+	return c.metricsRegistry
+}
+
+// MetricsHandler returns an http.Handler which exports the metrics of the
+// ProtoComms in the OpenMetrics text format.
+func (c *ProtoComms) MetricsHandler() http.Handler {
+	return c.GetMetricsRegistry()
+}
+
+// collectMetrics writes the connection metrics of the ProtoComms and its
+// hosts.
+func (c *ProtoComms) collectMetrics(w *MetricsWriter) {
+	if c.tokens != nil {
+		w.Gauge("xx_comms_tokens", "Number of outstanding "+
+			"reverse-authentication tokens", float64(c.tokens.Len()))
+	}
+
+	count, sum := c.handshakeLatency.get()
+	w.Summary("xx_comms_handshake_duration_seconds", "Duration of client "+
+		"authentication handshakes", count, sum)
+
+	if c.Manager == nil {
+		return
+	}
+
+	c.Manager.mux.RLock()
+	hosts := make([]*Host, 0, len(c.connections))
+	for _, host := range c.connections {
+		hosts = append(hosts, host)
+	}
+	c.Manager.mux.RUnlock()
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].id.String() < hosts[j].id.String()
+	})
+
+	w.Gauge("xx_comms_hosts", "Number of hosts in the manager",
+		float64(len(hosts)))
+	for _, host := range hosts {
+		hostID := host.id.String()
+		host.connectionMux.RLock()
+		connections := host.connectionCount
+		host.connectionMux.RUnlock()
+		circuitOpen := host.GetCircuitState() == CircuitOpen
+		stats := host.GetStats()
+
+		w.Counter("xx_comms_host_sends", "Number of sends to the host",
+			float64(stats.GetSends()), "host", hostID)
+		w.Counter("xx_comms_host_send_errors", "Number of failed sends "+
+			"to the host", float64(stats.Failures), "host", hostID)
+		w.Summary("xx_comms_host_send_duration_seconds", "Duration of "+
+			"sends to the host", stats.Latency.Count,
+			stats.Latency.Sum.Seconds(), "host", hostID)
+		w.Counter("xx_comms_host_sent_bytes", "Number of bytes sent to "+
+			"the host", float64(stats.BytesSent), "host", hostID)
+		w.Counter("xx_comms_host_received_bytes", "Number of bytes "+
+			"received from the host", float64(stats.BytesReceived),
+			"host", hostID)
+		w.Counter("xx_comms_host_connections", "Number of connections "+
+			"made to the host", float64(connections), "host", hostID)
+		w.Gauge("xx_comms_host_circuit_open", "Set to 1 while the circuit "+
+			"breaker of the host is open",
+			float64(exponential.BoolToFloat(circuitOpen)), "host", hostID)
+		w.Gauge("xx_comms_host_proxy_errors_over_cutoff", "Set to 1 while "+
+			"the proxy error average of the host is over its cutoff",
+			float64(exponential.BoolToFloat(host.proxyErrorMetric.IsOverCutoff())),
+			"host", hostID)
+	}
+}
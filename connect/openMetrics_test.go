@@ -0,0 +1,109 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"gitlab.com/xx_network/comms/connect/token"
+	"gitlab.com/xx_network/primitives/id"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests that MetricsRegistry.Write groups samples into families and escapes
+// label values.
+func TestMetricsRegistry_Write(t *testing.T) {
+	mr := NewMetricsRegistry()
+	mr.Register("b", func(w *MetricsWriter) {
+		w.Gauge("b_gauge", "A gauge", 1.5)
+	})
+	mr.Register("a", func(w *MetricsWriter) {
+		w.Counter("a_count", "A\ncounter", 3, "tag", `x"y`)
+		w.Counter("a_count", "A\ncounter", 4, "tag", "z")
+		w.Summary("a_latency_seconds", "A summary", 2, 0.25)
+	})
+
+	var buf bytes.Buffer
+	if err := mr.Write(&buf); err != nil {
+		t.Fatalf("Failed to write metrics: %+v", err)
+	}
+
+	expected := "# TYPE a_count counter\n" +
+		"# HELP a_count A\\ncounter\n" +
+		"a_count_total{tag=\"x\\\"y\"} 3\n" +
+		"a_count_total{tag=\"z\"} 4\n" +
+		"# TYPE a_latency_seconds summary\n" +
+		"# HELP a_latency_seconds A summary\n" +
+		"a_latency_seconds_count 2\n" +
+		"a_latency_seconds_sum 0.25\n" +
+		"# TYPE b_gauge gauge\n" +
+		"# HELP b_gauge A gauge\n" +
+		"b_gauge 1.5\n" +
+		"# EOF\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected output.\nexpected:\n%s\nreceived:\n%s",
+			expected, buf.String())
+	}
+
+	mr.Unregister("a")
+	buf.Reset()
+	_ = mr.Write(&buf)
+	if strings.Contains(buf.String(), "a_count") {
+		t.Errorf("Unregistered collector was written:\n%s", buf.String())
+	}
+}
+
+// Tests that the ProtoComms metrics handler exports host send counts, tokens
+// and handshake latency.
+func TestProtoComms_MetricsHandler(t *testing.T) {
+	pc := &ProtoComms{
+		Manager: newManager(),
+		tokens:  token.NewMap(),
+	}
+	pc.tokens.Generate()
+	pc.handshakeLatency.observe(500 * time.Millisecond)
+
+	hostID := id.NewIdFromString("metrics", id.Node, t)
+	host, err := pc.AddHost(hostID, "", nil, GetDefaultHostParams())
+	if err != nil {
+		t.Fatalf("Failed to add host: %+v", err)
+	}
+	_, _ = host.transmit(func(Connection) (interface{}, error) {
+		return nil, nil
+	})
+	_, _ = host.transmit(func(Connection) (interface{}, error) {
+		return nil, errors.New("failure")
+	})
+
+	w := httptest.NewRecorder()
+	pc.MetricsHandler().ServeHTTP(w,
+		httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Header().Get("Content-Type") != OpenMetricsContentType {
+		t.Errorf("Unexpected content type: %s", w.Header().Get("Content-Type"))
+	}
+
+	body := w.Body.String()
+	for _, line := range []string{
+		"xx_comms_tokens 1",
+		"xx_comms_handshake_duration_seconds_count 1",
+		"xx_comms_handshake_duration_seconds_sum 0.5",
+		"xx_comms_hosts 1",
+		"xx_comms_host_sends_total{host=\"" + hostID.String() + "\"} 2",
+		"xx_comms_host_send_errors_total{host=\"" + hostID.String() + "\"} 1",
+		"xx_comms_host_connections_total{host=\"" + hostID.String() + "\"} 0",
+		"xx_comms_host_circuit_open{host=\"" + hostID.String() + "\"} 0",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Output missing %q:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// Contains the origin and CORS policy for grpc-web serving
+
+package connect
+
+import (
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	jww "github.com/spf13/jwalterweatherman"
+	"google.golang.org/grpc"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Maximum number of distinct rejected origins tracked individually. Further
+// origins are counted under otherOrigins.
+const maxTrackedOrigins = 1024
+const otherOrigins = "other"
+
+// Request headers always allowed so that grpc-web clients can function,
+// including the authentication and request MAC metadata sent by hosts
+var grpcWebRequestHeaders = []string{
+	"Content-Type", "X-Grpc-Web", "X-User-Agent", "Grpc-Timeout", "U-A",
+	"Cache-Control", "ID", "TOKEN", requestMACHeader, requestCounterHeader,
+}
+
+// OriginPolicy describes which browser origins may call the grpc-web
+// endpoints and the CORS headers returned to them. Requests without an
+// Origin header, such as those from non-browser clients, are not affected.
+type OriginPolicy struct {
+	// Origins allowed to call the endpoints, e.g. "https://xx.network".
+	// Entries may contain a single "*" wildcard, e.g. "https://*.xx.network";
+	// a lone "*" allows every origin.
+	AllowedOrigins []string
+
+	// Request headers browsers may send in addition to the ones grpc-web
+	// and host authentication require. A lone "*" allows every header the
+	// browser asks for in its preflight.
+	AllowedHeaders []string
+
+	// If set, browsers may include credentials such as cookies
+	AllowCredentials bool
+
+	// How long browsers may cache a preflight response. Zero omits the
+	// header.
+	MaxAge time.Duration
+}
+
+// GetDefaultOriginPolicy returns an OriginPolicy which allows every origin
+// and every request header.
+func GetDefaultOriginPolicy() OriginPolicy {
+	return OriginPolicy{
+		AllowedOrigins:   []string{"*"},
+		AllowedHeaders:   []string{"*"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+}
+
+// allows returns true if the origin matches one of the allowed origins.
+func (op OriginPolicy) allows(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range op.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+
+		prefix, suffix, wildcard := strings.Cut(allowed, "*")
+		if wildcard && len(origin) >= len(prefix)+len(suffix) &&
+			strings.HasPrefix(origin, prefix) &&
+			strings.HasSuffix(origin, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedHeaders returns the value of the Access-Control-Allow-Headers
+// header answering a preflight which asked for the requested headers.
+func (op OriginPolicy) allowedHeaders(requested string) string {
+	headers := append([]string{}, grpcWebRequestHeaders...)
+	for _, allowed := range op.AllowedHeaders {
+		if allowed == "*" {
+			if requested != "" {
+				headers = append(headers, requested)
+			}
+			continue
+		}
+		headers = append(headers, allowed)
+	}
+	return strings.Join(headers, ", ")
+}
+
+// originFilter enforces an OriginPolicy which can be changed at runtime and
+// counts the requests it rejects.
+type originFilter struct {
+	policy   OriginPolicy
+	rejected map[string]uint64
+	mux      sync.RWMutex
+}
+
+// newOriginFilter creates an originFilter enforcing the given policy.
+func newOriginFilter(policy OriginPolicy) *originFilter {
+	return &originFilter{
+		policy:   policy,
+		rejected: make(map[string]uint64),
+	}
+}
+
+// get returns the current policy.
+func (of *originFilter) get() OriginPolicy {
+	of.mux.RLock()
+	defer of.mux.RUnlock()
+	return of.policy
+}
+
+// set replaces the current policy.
+func (of *originFilter) set(policy OriginPolicy) {
+	of.mux.Lock()
+	defer of.mux.Unlock()
+	of.policy = policy
+}
+
+// reject records a request rejected for the given origin.
+func (of *originFilter) reject(origin string) {
+	of.mux.Lock()
+	defer of.mux.Unlock()
+	if _, ok := of.rejected[origin]; !ok &&
+		len(of.rejected) >= maxTrackedOrigins {
+		origin = otherOrigins
+	}
+	of.rejected[origin]++
+}
+
+// getRejected returns a copy of the rejected request counts by origin.
+func (of *originFilter) getRejected() map[string]uint64 {
+	of.mux.RLock()
+	defer of.mux.RUnlock()
+	rejected := make(map[string]uint64, len(of.rejected))
+	for origin, count := range of.rejected {
+		rejected[origin] = count
+	}
+	return rejected
+}
+
+// wrap returns an http.Handler which applies the policy before passing
+// requests to h. Preflight requests are answered directly and requests from
+// disallowed origins are rejected with http.StatusForbidden.
+func (of *originFilter) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		policy := of.get()
+		w.Header().Add("Vary", "Origin")
+		if !policy.allows(origin) {
+			jww.DEBUG.Printf("Rejected grpc-web request from origin %s", origin)
+			of.reject(origin)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if policy.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		// Answer preflight requests
+		if r.Method == http.MethodOptions &&
+			r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", http.MethodPost)
+			w.Header().Set("Access-Control-Allow-Headers",
+				policy.allowedHeaders(
+					r.Header.Get("Access-Control-Request-Headers")))
+			if policy.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age",
+					strconv.Itoa(int(policy.MaxAge/time.Second)))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// newWebHandler wraps the gRPC server for grpc-web clients, applying the
+// OriginPolicy of the ProtoComms. The grpcweb CORS handling is disabled as
+// the originFilter answers preflight requests itself.
+func (c *ProtoComms) newWebHandler(grpcServer *grpc.Server) http.Handler {
+	return c.getOriginFilter().wrap(grpcweb.WrapServer(grpcServer,
+		grpcweb.WithOriginFunc(func(origin string) bool { return false })))
+}
+
+// SetOriginPolicy replaces the OriginPolicy applied to grpc-web requests.
+// It may be called before or after serving and takes effect immediately for
+// new requests. Until it is called, GetDefaultOriginPolicy is used.
+func (c *ProtoComms) SetOriginPolicy(policy OriginPolicy) {
+	c.getOriginFilter().set(policy)
+}
+
+// GetOriginPolicy returns the OriginPolicy applied to grpc-web requests.
+func (c *ProtoComms) GetOriginPolicy() OriginPolicy {
+	return c.getOriginFilter().get()
+}
+
+// GetRejectedOrigins returns the number of grpc-web requests rejected by the
+// OriginPolicy, by origin.
+func (c *ProtoComms) GetRejectedOrigins() map[string]uint64 {
+	return c.getOriginFilter().getRejected()
+}
+
+// getOriginFilter returns the originFilter of the ProtoComms, creating it
+// with the default policy if it does not exist yet.
+func (c *ProtoComms) getOriginFilter() *originFilter {
+	c.originsOnce.Do(func() {
+		c.origins = newOriginFilter(GetDefaultOriginPolicy())
+	})
+	return c.origins
+}
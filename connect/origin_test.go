@@ -0,0 +1,242 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"context"
+	"fmt"
+	"gitlab.com/xx_network/primitives/id"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests that OriginPolicy.allows matches exact and wildcard origins.
+func TestOriginPolicy_allows(t *testing.T) {
+	policy := OriginPolicy{AllowedOrigins: []string{
+		"https://xx.network", "https://*.example.com", "http://localhost:*"}}
+
+	tests := map[string]bool{
+		"https://xx.network":         true,
+		"HTTPS://XX.NETWORK":         true,
+		"http://xx.network":          false,
+		"https://app.example.com":    true,
+		"https://a.b.example.com":    true,
+		"https://example.com":        false,
+		"https://evilexample.com":    false,
+		"https://example.com.evil":   false,
+		"http://localhost:3000":      true,
+		"http://localhost.evil:3000": false,
+	}
+	for origin, expected := range tests {
+		if policy.allows(origin) != expected {
+			t.Errorf("Unexpected result for origin %q."+
+				"\nexpected: %t\nreceived: %t", origin, expected, !expected)
+		}
+	}
+
+	if !GetDefaultOriginPolicy().allows("https://anything.io") {
+		t.Errorf("Default policy should allow every origin.")
+	}
+}
+
+// Tests that originFilter.wrap answers preflights, rejects and counts
+// disallowed origins, and passes requests without an Origin through.
+func TestOriginFilter_wrap(t *testing.T) {
+	policy := OriginPolicy{
+		AllowedOrigins:   []string{"https://xx.network"},
+		AllowedHeaders:   []string{"X-Custom"},
+		AllowCredentials: true,
+		MaxAge:           time.Minute,
+	}
+	of := newOriginFilter(policy)
+	reached := 0
+	h := of.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached++
+	}))
+
+	// Preflight from an allowed origin
+	r := httptest.NewRequest(http.MethodOptions, "/messages.Generic/Foo", nil)
+	r.Header.Set("Origin", "https://xx.network")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Unexpected preflight status: %d", w.Code)
+	}
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":      "https://xx.network",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     http.MethodPost,
+		"Access-Control-Allow-Headers": "Content-Type, X-Grpc-Web, " +
+			"X-User-Agent, Grpc-Timeout, U-A, Cache-Control, ID, TOKEN, " +
+			"MAC, COUNTER, X-Custom",
+		"Access-Control-Max-Age": "60",
+	}
+	for header, value := range expected {
+		if received := w.Header().Get(header); received != value {
+			t.Errorf("Unexpected %s header.\nexpected: %q\nreceived: %q",
+				header, value, received)
+		}
+	}
+
+	// Actual request from an allowed origin
+	r = httptest.NewRequest(http.MethodPost, "/messages.Generic/Foo", nil)
+	r.Header.Set("Origin", "https://xx.network")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if reached != 1 {
+		t.Errorf("Allowed request did not reach the handler.")
+	}
+
+	// Requests from a disallowed origin
+	for i := 0; i < 2; i++ {
+		r = httptest.NewRequest(http.MethodPost, "/messages.Generic/Foo", nil)
+		r.Header.Set("Origin", "https://evil.io")
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Unexpected status for rejected origin: %d", w.Code)
+		}
+		if w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("Rejected origin received CORS headers.")
+		}
+	}
+	if reached != 1 {
+		t.Errorf("Rejected request reached the handler.")
+	}
+	if of.getRejected()["https://evil.io"] != 2 {
+		t.Errorf("Unexpected rejection counts: %v", of.getRejected())
+	}
+
+	// Request without an Origin
+	r = httptest.NewRequest(http.MethodPost, "/messages.Generic/Foo", nil)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if reached != 2 {
+		t.Errorf("Request without origin did not reach the handler.")
+	}
+}
+
+// Tests that preflights asking for the headers hosts send are allowed by the
+// default policy and by a policy listing no extra headers.
+func TestOriginFilter_wrap_PreflightHeaders(t *testing.T) {
+	requested := []string{"cache-control", "content-type", "counter", "id",
+		"mac", "token", "x-grpc-web", "x-user-agent"}
+
+	explicit := GetDefaultOriginPolicy()
+	explicit.AllowedHeaders = nil
+	policies := map[string]OriginPolicy{
+		"default":  GetDefaultOriginPolicy(),
+		"explicit": explicit,
+	}
+	for name, policy := range policies {
+		h := newOriginFilter(policy).wrap(http.HandlerFunc(
+			func(http.ResponseWriter, *http.Request) {}))
+		r := httptest.NewRequest(http.MethodOptions,
+			"/messages.Generic/AuthenticateToken", nil)
+		r.Header.Set("Origin", "https://xx.network")
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		r.Header.Set("Access-Control-Request-Headers",
+			strings.Join(requested, ","))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		allowed := make(map[string]bool)
+		for _, header := range strings.Split(
+			w.Header().Get("Access-Control-Allow-Headers"), ",") {
+			allowed[strings.ToLower(strings.TrimSpace(header))] = true
+		}
+		for _, header := range requested {
+			if !allowed[header] {
+				t.Errorf("Header %s is not allowed by the %s policy: %q",
+					header, name,
+					w.Header().Get("Access-Control-Allow-Headers"))
+			}
+		}
+	}
+
+	// The default policy echoes headers it does not know
+	h := newOriginFilter(GetDefaultOriginPolicy()).wrap(http.HandlerFunc(
+		func(http.ResponseWriter, *http.Request) {}))
+	r := httptest.NewRequest(http.MethodOptions, "/messages.Generic/Foo", nil)
+	r.Header.Set("Origin", "https://xx.network")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	r.Header.Set("Access-Control-Request-Headers", "x-app-version")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if !strings.HasSuffix(w.Header().Get("Access-Control-Allow-Headers"),
+		", x-app-version") {
+		t.Errorf("Requested header was not echoed: %q",
+			w.Header().Get("Access-Control-Allow-Headers"))
+	}
+}
+
+// Tests that the number of distinct tracked origins is bounded.
+func TestOriginFilter_reject_Bounded(t *testing.T) {
+	of := newOriginFilter(OriginPolicy{})
+	for i := 0; i < maxTrackedOrigins+10; i++ {
+		of.reject(fmt.Sprintf("https://%d.io", i))
+	}
+
+	rejected := of.getRejected()
+	if len(rejected) != maxTrackedOrigins+1 {
+		t.Errorf("Unexpected number of tracked origins: %d", len(rejected))
+	}
+	if rejected[otherOrigins] != 10 {
+		t.Errorf("Unexpected count for untracked origins: %d",
+			rejected[otherOrigins])
+	}
+}
+
+// Tests that a served ProtoComms applies a policy changed at runtime.
+func TestProtoComms_SetOriginPolicy(t *testing.T) {
+	addr := "127.0.0.1:11436"
+	pc, err := StartCommServer(id.NewIdFromString("origin", id.Node, t), addr,
+		nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	pc.ServeWithWeb()
+	defer pc.Shutdown(context.Background())
+
+	preflight := func() int {
+		r, err := http.NewRequest(http.MethodOptions,
+			"http://"+addr+"/messages.Generic/RequestToken", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Origin", "https://app.xx.network")
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("Preflight failed: %+v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := preflight(); code != http.StatusNoContent {
+		t.Errorf("Default policy rejected preflight: %d", code)
+	}
+
+	pc.SetOriginPolicy(OriginPolicy{AllowedOrigins: []string{"https://xx.network"}})
+	if code := preflight(); code != http.StatusForbidden {
+		t.Errorf("Updated policy allowed preflight: %d", code)
+	}
+	if pc.GetRejectedOrigins()["https://app.xx.network"] != 1 {
+		t.Errorf("Unexpected rejection counts: %v", pc.GetRejectedOrigins())
+	}
+
+	pc.SetOriginPolicy(OriginPolicy{
+		AllowedOrigins: []string{"https://*.xx.network"}})
+	if code := preflight(); code != http.StatusNoContent {
+		t.Errorf("Wildcard policy rejected preflight: %d", code)
+	}
+}
@@ -0,0 +1,106 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// Contains the authorization policies enforced per gRPC method
+
+package connect
+
+import (
+	"github.com/pkg/errors"
+	jww "github.com/spf13/jwalterweatherman"
+	"gitlab.com/xx_network/primitives/id"
+)
+
+// ErrPermissionDenied is returned for authenticated calls from senders the
+// MethodPolicy of the method does not allow.
+var ErrPermissionDenied = errors.New("sender is not allowed to call method")
+
+// MethodPolicy describes which authenticated senders may call a method. A
+// sender is allowed if it matches any of the rules, so a MethodPolicy without
+// rules denies every sender.
+type MethodPolicy struct {
+	// Types of ID allowed to call the method, e.g. id.Node
+	AllowedTypes []id.Type
+
+	// IDs allowed to call the method regardless of their type
+	AllowedIDs []*id.ID
+
+	// Custom rule called with the Auth of the call and the full method name.
+	// The sender is allowed if it returns true.
+	Allow func(auth *Auth, method string) bool
+}
+
+// AllowTypes returns a MethodPolicy allowing senders with the given ID types.
+func AllowTypes(types ...id.Type) MethodPolicy {
+	return MethodPolicy{AllowedTypes: types}
+}
+
+// AllowIDs returns a MethodPolicy allowing only the given senders.
+func AllowIDs(ids ...*id.ID) MethodPolicy {
+	return MethodPolicy{AllowedIDs: ids}
+}
+
+// allows returns true if the authenticated sender may call the method.
+func (mp MethodPolicy) allows(auth *Auth, method string) bool {
+	sender := auth.Sender.GetId()
+	for _, t := range mp.AllowedTypes {
+		if sender.GetType() == t {
+			return true
+		}
+	}
+	for _, allowed := range mp.AllowedIDs {
+		if sender.Cmp(allowed) {
+			return true
+		}
+	}
+	return mp.Allow != nil && mp.Allow(auth, method)
+}
+
+// SetMethodPolicy sets the MethodPolicy enforced on the given full gRPC
+// method names, replacing any set before. The methods are marked by
+// RequireAuthentication, and authenticated calls from senders the policy
+// does not allow are rejected with codes.PermissionDenied before reaching
+// the handler.
+func (c *ProtoComms) SetMethodPolicy(policy MethodPolicy, methods ...string) {
+	c.RequireAuthentication(methods...)
+
+	c.authMethodsMux.Lock()
+	defer c.authMethodsMux.Unlock()
+
+	if c.methodPolicies == nil {
+		c.methodPolicies = make(map[string]MethodPolicy, len(methods))
+	}
+	for _, method := range methods {
+		c.methodPolicies[method] = policy
+	}
+}
+
+// GetMethodPolicy returns the MethodPolicy enforced on the full method name.
+// Returns false if no policy is set, in which case any authenticated sender
+// is allowed.
+func (c *ProtoComms) GetMethodPolicy(method string) (MethodPolicy, bool) {
+	c.authMethodsMux.RLock()
+	defer c.authMethodsMux.RUnlock()
+	policy, ok := c.methodPolicies[method]
+	return policy, ok
+}
+
+// authorizeRequest checks the authenticated call against the MethodPolicy of
+// the method and logs denied calls for auditing. Returns an error in the
+// ErrPermissionDenied class if the sender is not allowed.
+func (c *ProtoComms) authorizeRequest(auth *Auth, method string) error {
+	policy, ok := c.GetMethodPolicy(method)
+	if !ok || policy.allows(auth, method) {
+		return nil
+	}
+
+	sender := auth.Sender.GetId()
+	jww.WARN.Printf("Denied call to %s from %s (type %s) at %s: not allowed "+
+		"by method policy", method, sender, sender.GetType(), auth.IpAddress)
+	return errors.WithMessagef(ErrPermissionDenied, "%s may not call %s",
+		sender, method)
+}
@@ -0,0 +1,139 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"gitlab.com/xx_network/comms/connect/token"
+	pb "gitlab.com/xx_network/comms/messages"
+	"gitlab.com/xx_network/primitives/id"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+// Tests that a MethodPolicy allows senders matching any of its rules.
+func TestMethodPolicy_allows(t *testing.T) {
+	node := &Auth{Sender: &Host{id: id.NewIdFromString("node", id.Node, t)}}
+	gateway := &Auth{
+		Sender: &Host{id: id.NewIdFromString("gateway", id.Gateway, t)}}
+	const method = "/messages.Generic/RequestToken"
+
+	tests := []struct {
+		policy  MethodPolicy
+		node    bool
+		gateway bool
+	}{
+		{MethodPolicy{}, false, false},
+		{AllowTypes(id.Node), true, false},
+		{AllowTypes(id.Node, id.Gateway), true, true},
+		{AllowIDs(gateway.Sender.GetId()), false, true},
+		{MethodPolicy{Allow: func(auth *Auth, m string) bool {
+			return m == method && auth == gateway
+		}}, false, true},
+		{MethodPolicy{AllowedTypes: []id.Type{id.Node},
+			AllowedIDs: []*id.ID{gateway.Sender.GetId()}}, true, true},
+	}
+
+	for i, tt := range tests {
+		if tt.policy.allows(node, method) != tt.node {
+			t.Errorf("Policy %d: node allowed is not %t", i, tt.node)
+		}
+		if tt.policy.allows(gateway, method) != tt.gateway {
+			t.Errorf("Policy %d: gateway allowed is not %t", i, tt.gateway)
+		}
+	}
+}
+
+// Tests that SetMethodPolicy requires authentication for the methods and
+// replaces earlier policies.
+func TestProtoComms_SetMethodPolicy(t *testing.T) {
+	pc := &ProtoComms{}
+	const method = "/messages.Generic/RequestToken"
+	if _, ok := pc.GetMethodPolicy(method); ok {
+		t.Errorf("Policy found before one was set")
+	}
+
+	pc.SetMethodPolicy(AllowTypes(id.Node), method)
+	pc.SetMethodPolicy(AllowTypes(id.Gateway), method)
+	if !pc.requiresAuthentication(method) {
+		t.Errorf("Method with a policy does not require authentication")
+	}
+	policy, ok := pc.GetMethodPolicy(method)
+	if !ok || len(policy.AllowedTypes) != 1 ||
+		policy.AllowedTypes[0] != id.Gateway {
+		t.Errorf("Policy was not replaced: %+v", policy)
+	}
+}
+
+// Tests that the interceptors reject calls from senders the MethodPolicy does
+// not allow with codes.PermissionDenied, and pass allowed calls through.
+func TestProtoComms_SetMethodPolicy_Interceptor(t *testing.T) {
+	serverID := id.NewIdFromString("server", id.Node, t)
+	pc, err := StartCommServer(serverID, "127.0.0.1:11441", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	defer pc.Shutdown(context.Background())
+	pc.SetMethodPolicy(AllowTypes(id.Node), "/messages.Generic/RequestToken")
+	pc.SetMethodPolicy(AllowTypes(id.Gateway),
+		"/messages.Generic/AuthenticateToken")
+	pb.RegisterGenericServer(pc.GetServer(), &authCheckServer{})
+	pc.Serve()
+
+	clientID := id.NewIdFromString("client", id.Node, t)
+	client, err := CreateCommClient(clientID, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverHost, err := client.AddHost(serverID, "127.0.0.1:11441", nil,
+		GetDefaultHostParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientHost, err := pc.AddHost(clientID, "", nil, GetDefaultHostParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tkn := token.Token{}
+	copy(tkn[:], "policyToken")
+	serverHost.transmissionToken.Set(tkn)
+	clientHost.receptionToken.Set(tkn)
+
+	conn, err := grpc.Dial("127.0.0.1:11441",
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	gc := pb.NewGenericClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The node client is allowed to call methods open to nodes
+	_, err = gc.RequestToken(client.PackAuthenticatedContext(serverHost, ctx),
+		&pb.Ping{})
+	if err != nil {
+		t.Errorf("Allowed call failed: %+v", err)
+	}
+
+	// and denied on methods open only to gateways
+	msg, err := client.PackAuthenticatedMessage(&pb.Ping{}, serverHost, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = gc.AuthenticateToken(ctx, msg)
+	if status.Code(err) != codes.PermissionDenied ||
+		!errors.Is(FromGrpcStatus(err), ErrPermissionDenied) {
+		t.Errorf("Expected %s, received: %+v", codes.PermissionDenied, err)
+	}
+}
@@ -0,0 +1,173 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// Contains the replay protection for signed AuthenticatedMessages
+
+package connect
+
+import (
+	"github.com/pkg/errors"
+	pb "gitlab.com/xx_network/comms/messages"
+	"gitlab.com/xx_network/primitives/id"
+	"sync"
+	"time"
+)
+
+// Length of the nonce added to signed messages, in bytes
+const replayNonceLen = 16
+
+// Errors returned for signed messages rejected by replay protection. All of
+// them are in the ErrAuthFailed class.
+var (
+	// ErrMessageReplayed is returned for a message whose nonce was already
+	// received from the same sender within the replay window.
+	ErrMessageReplayed = newClassError(ErrAuthFailed,
+		"message was already received")
+
+	// ErrMessageOutsideWindow is returned for a message whose timestamp is
+	// too far from the local time to be checked for replays.
+	ErrMessageOutsideWindow = newClassError(ErrAuthFailed,
+		"message timestamp is outside the replay window")
+
+	// ErrMissingReplayProtection is returned for a message without a
+	// timestamp and nonce when they are required.
+	ErrMissingReplayProtection = newClassError(ErrAuthFailed,
+		"message has no timestamp and nonce")
+)
+
+// replayKey identifies a signed message received from a sender.
+type replayKey struct {
+	sender id.ID
+	nonce  string
+}
+
+// replayEntry is a message in the replay cache and its timestamp, in Unix
+// nanoseconds.
+type replayEntry struct {
+	key       replayKey
+	timestamp int64
+}
+
+// replayCache stores the nonces of signed messages received within the
+// replay window. Messages with timestamps outside the window are rejected,
+// so their nonces do not need to be kept.
+type replayCache struct {
+	window  time.Duration
+	maxSize int
+
+	seen map[replayKey]struct{}
+	// Messages in the order they were received
+	queue []replayEntry
+	// Highest timestamp of a message evicted to bound the cache. Messages at
+	// or before it are rejected, as they could be replays of evicted ones.
+	floor int64
+
+	mux sync.Mutex
+}
+
+// newReplayCache creates a replayCache accepting messages within the window
+// of the local time and holding at most maxSize nonces. A maxSize of zero
+// leaves the cache unbounded.
+func newReplayCache(window time.Duration, maxSize int) *replayCache {
+	return &replayCache{
+		window:  window,
+		maxSize: maxSize,
+		seen:    make(map[replayKey]struct{}),
+	}
+}
+
+// check records the message and returns an error if its timestamp is outside
+// the window or it was already received from the sender.
+func (rc *replayCache) check(sender *id.ID, timestamp int64, nonce []byte,
+	now time.Time) error {
+	earliest := now.Add(-rc.window).UnixNano()
+	if timestamp < earliest || timestamp > now.Add(rc.window).UnixNano() {
+		return errors.WithMessagef(ErrMessageOutsideWindow,
+			"message from %s sent at %s", sender, time.Unix(0, timestamp))
+	}
+
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+	rc.cleanup(earliest)
+
+	if timestamp <= rc.floor {
+		return errors.WithMessagef(ErrMessageOutsideWindow,
+			"message from %s is older than the replay cache", sender)
+	}
+
+	key := replayKey{sender: *sender, nonce: string(nonce)}
+	if _, ok := rc.seen[key]; ok {
+		return errors.WithMessagef(ErrMessageReplayed, "message from %s",
+			sender)
+	}
+
+	// Evict the oldest messages to make room, rejecting anything sent
+	// before them from now on
+	for rc.maxSize > 0 && len(rc.seen) >= rc.maxSize {
+		oldest := rc.queue[0]
+		rc.queue = rc.queue[1:]
+		delete(rc.seen, oldest.key)
+		if oldest.timestamp > rc.floor {
+			rc.floor = oldest.timestamp
+		}
+	}
+
+	rc.seen[key] = struct{}{}
+	rc.queue = append(rc.queue, replayEntry{key: key, timestamp: timestamp})
+	return nil
+}
+
+// cleanup removes messages sent before earliest, in Unix nanoseconds, from
+// the front of the queue. Must be called under the lock.
+func (rc *replayCache) cleanup(earliest int64) {
+	i := 0
+	for ; i < len(rc.queue) && rc.queue[i].timestamp < earliest; i++ {
+		delete(rc.seen, rc.queue[i].key)
+	}
+	rc.queue = rc.queue[i:]
+}
+
+// len returns the number of messages in the cache.
+func (rc *replayCache) len() int {
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+	return len(rc.seen)
+}
+
+// getReplayCache returns the replay cache of the ProtoComms, creating it from
+// the server options on first use.
+func (c *ProtoComms) getReplayCache() *replayCache {
+	c.replayOnce.Do(func() {
+		c.replay = newReplayCache(c.serverOpts.ReplayWindow,
+			c.serverOpts.ReplayCacheSize)
+	})
+	return c.replay
+}
+
+// checkReplay rejects signed messages which are replays or fall outside the
+// replay window. Messages without a timestamp and nonce are accepted unless
+// RequireReplayProtection is set. Must only be called once the signature of
+// the message has been verified.
+func (c *ProtoComms) checkReplay(sender *id.ID,
+	msg *pb.AuthenticatedMessage) error {
+	if msg.Timestamp == 0 && len(msg.Nonce) == 0 {
+		if c.serverOpts.RequireReplayProtection {
+			return errors.WithMessagef(ErrMissingReplayProtection,
+				"message from %s", sender)
+		}
+		return nil
+	} else if msg.Timestamp == 0 || len(msg.Nonce) == 0 {
+		return errors.WithMessagef(ErrMissingReplayProtection,
+			"message from %s", sender)
+	}
+
+	if c.serverOpts.ReplayWindow <= 0 {
+		return nil
+	}
+	return c.getReplayCache().check(sender, msg.Timestamp, msg.Nonce,
+		time.Now())
+}
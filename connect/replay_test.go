@@ -0,0 +1,139 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"github.com/pkg/errors"
+	pb "gitlab.com/xx_network/comms/messages"
+	"gitlab.com/xx_network/primitives/id"
+	"testing"
+	"time"
+)
+
+// Tests that the replayCache rejects repeated nonces of a sender and
+// timestamps outside the window.
+func TestReplayCache_check(t *testing.T) {
+	rc := newReplayCache(time.Minute, 0)
+	now := time.Now()
+	sender := id.NewIdFromString("sender", id.Node, t)
+	other := id.NewIdFromString("other", id.Node, t)
+
+	if err := rc.check(sender, now.UnixNano(), []byte("nonce"), now); err != nil {
+		t.Errorf("Failed to accept new message: %+v", err)
+	}
+	err := rc.check(sender, now.UnixNano(), []byte("nonce"), now)
+	if !errors.Is(err, ErrMessageReplayed) || !IsAuthError(err) {
+		t.Errorf("Expected %v, received %+v", ErrMessageReplayed, err)
+	}
+	if err = rc.check(other, now.UnixNano(), []byte("nonce"), now); err != nil {
+		t.Errorf("Failed to accept nonce of another sender: %+v", err)
+	}
+
+	for _, ts := range []time.Time{
+		now.Add(-2 * time.Minute), now.Add(2 * time.Minute)} {
+		err = rc.check(sender, ts.UnixNano(), []byte("late"), now)
+		if !errors.Is(err, ErrMessageOutsideWindow) || !IsAuthError(err) {
+			t.Errorf("Expected %v for %s, received %+v",
+				ErrMessageOutsideWindow, ts, err)
+		}
+	}
+
+	// Messages older than the window are dropped from the cache
+	later := now.Add(2 * time.Minute)
+	if err = rc.check(sender, later.UnixNano(), []byte("new"), later); err != nil {
+		t.Errorf("Failed to accept new message: %+v", err)
+	}
+	if rc.len() != 1 {
+		t.Errorf("Expired messages were not dropped: %d", rc.len())
+	}
+}
+
+// Tests that a full replayCache evicts its oldest messages and rejects
+// messages sent before them.
+func TestReplayCache_check_MaxSize(t *testing.T) {
+	rc := newReplayCache(time.Minute, 2)
+	now := time.Now()
+	sender := id.NewIdFromString("sender", id.Node, t)
+
+	for i, nonce := range []string{"a", "b", "c"} {
+		ts := now.Add(time.Duration(i) * time.Second).UnixNano()
+		if err := rc.check(sender, ts, []byte(nonce), now); err != nil {
+			t.Fatalf("Failed to accept message %s: %+v", nonce, err)
+		}
+	}
+	if rc.len() != 2 {
+		t.Errorf("Unexpected cache size: %d", rc.len())
+	}
+
+	// The evicted message cannot be replayed
+	err := rc.check(sender, now.UnixNano(), []byte("a"), now)
+	if !errors.Is(err, ErrMessageOutsideWindow) {
+		t.Errorf("Expected %v, received %+v", ErrMessageOutsideWindow, err)
+	}
+}
+
+// Tests that signed messages with replay protection are verified once and
+// rejected when resubmitted or tampered with.
+func TestProtoComms_VerifyAuthenticatedMessage_Replay(t *testing.T) {
+	comm, host := newBindingTestComms(t, GetDefaultServerOptions())
+	host.params.EnableReplayProtection = true
+
+	msg, err := comm.PackAuthenticatedMessage(&pb.Ack{Error: "once"}, host,
+		true)
+	if err != nil {
+		t.Fatalf("Failed to pack message: %+v", err)
+	}
+	if msg.Timestamp == 0 || len(msg.Nonce) != replayNonceLen {
+		t.Fatalf("Message has no timestamp and nonce: %+v", msg)
+	}
+
+	payload := &pb.Ack{}
+	if err = comm.VerifyAuthenticatedMessage(msg, payload); err != nil {
+		t.Fatalf("Failed to verify message: %+v", err)
+	}
+	if payload.Error != "once" {
+		t.Errorf("Unexpected payload: %+v", payload)
+	}
+
+	err = comm.VerifyAuthenticatedMessage(msg, &pb.Ack{})
+	if !errors.Is(err, ErrMessageReplayed) || !IsAuthError(err) {
+		t.Errorf("Expected %v, received %+v", ErrMessageReplayed, err)
+	}
+
+	// The timestamp and nonce are covered by the signature
+	msg, _ = comm.PackAuthenticatedMessage(&pb.Ack{}, host, true)
+	msg.Nonce[0]++
+	if err = comm.VerifyAuthenticatedMessage(msg, &pb.Ack{}); err == nil {
+		t.Errorf("Verified message with a modified nonce")
+	}
+	msg, _ = comm.PackAuthenticatedMessage(&pb.Ack{}, host, true)
+	msg.Timestamp, msg.Nonce = 0, nil
+	if err = comm.VerifyAuthenticatedMessage(msg, &pb.Ack{}); err == nil {
+		t.Errorf("Verified message with the nonce removed")
+	}
+}
+
+// Tests that messages without replay protection are only rejected when
+// RequireReplayProtection is set.
+func TestProtoComms_VerifyAuthenticatedMessage_RequireReplayProtection(
+	t *testing.T) {
+	comm, host := newBindingTestComms(t, GetDefaultServerOptions())
+	msg, err := comm.PackAuthenticatedMessage(&pb.Ack{}, host, true)
+	if err != nil {
+		t.Fatalf("Failed to pack message: %+v", err)
+	}
+	if err = comm.VerifyAuthenticatedMessage(msg, &pb.Ack{}); err != nil {
+		t.Errorf("Failed to verify unprotected message: %+v", err)
+	}
+
+	comm.serverOpts.RequireReplayProtection = true
+	err = comm.VerifyAuthenticatedMessage(msg, &pb.Ack{})
+	if !errors.Is(err, ErrMissingReplayProtection) || !IsAuthError(err) {
+		t.Errorf("Expected %v, received %+v", ErrMissingReplayProtection, err)
+	}
+}
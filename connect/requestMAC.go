@@ -0,0 +1,234 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// Contains the per-request MAC authentication keyed from the negotiated token
+
+package connect
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"gitlab.com/xx_network/comms/connect/token"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	protoV2 "google.golang.org/protobuf/proto"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// Metadata headers carrying the MAC of a request and its counter
+	requestMACHeader     = "MAC"
+	requestCounterHeader = "COUNTER"
+
+	// Label the MAC key is derived from the token with
+	requestMACLabel = "xx-network-request-mac"
+
+	// Number of counters below the highest received which are still accepted,
+	// allowing concurrent requests to arrive out of order
+	requestCounterWindow = 1024
+)
+
+// requestMACKey derives the key requests are MACed with from the token.
+func requestMACKey(t token.Token) []byte {
+	kdf := hmac.New(sha256.New, t.Marshal())
+	kdf.Write([]byte(requestMACLabel))
+	return kdf.Sum(nil)
+}
+
+// computeRequestMAC returns the MAC of the request, keyed from the token and
+// covering the full method name, the serialized body and the counter.
+func computeRequestMAC(t token.Token, method string, body []byte,
+	counter uint64) []byte {
+	mac := hmac.New(sha256.New, requestMACKey(t))
+	length := make([]byte, 8)
+	binary.BigEndian.PutUint64(length, uint64(len(method)))
+	mac.Write(length)
+	mac.Write([]byte(method))
+	binary.BigEndian.PutUint64(length, uint64(len(body)))
+	mac.Write(length)
+	mac.Write(body)
+	binary.BigEndian.PutUint64(length, counter)
+	mac.Write(length)
+	return mac.Sum(nil)
+}
+
+// marshalRequestBody serializes the body of a request deterministically, so
+// that the client and server MAC the same bytes. Requests which are not
+// protobuf messages, such as the opening of a stream, have an empty body.
+func marshalRequestBody(req interface{}) ([]byte, error) {
+	msg, ok := req.(proto.Message)
+	if !ok || msg == nil {
+		return nil, nil
+	}
+	return protoV2.MarshalOptions{Deterministic: true}.Marshal(
+		proto.MessageV2(msg))
+}
+
+// usesRequestMAC returns true if requests to the Host are authenticated
+// with a MAC instead of the raw token. Only gRPC connections support it, so
+// EnableRequestMAC is silently ignored for Web hosts, even if the server
+// requires request MACs.
+func (h *Host) usesRequestMAC() bool {
+	return h.params.EnableRequestMAC && h.params.ConnectionType != Web
+}
+
+// packRequestMAC adds the MAC of the request and its counter to the outgoing
+// context. The context is returned unchanged if the Host does not use
+// request MACs or there is no transmission token.
+func (h *Host) packRequestMAC(ctx context.Context, method string,
+	req interface{}) (context.Context, error) {
+	if !h.usesRequestMAC() {
+		return ctx, nil
+	}
+	t, ok := h.transmissionToken.Get()
+	if !ok {
+		return ctx, nil
+	}
+
+	body, err := marshalRequestBody(req)
+	if err != nil {
+		return nil, errors.Errorf("Failed to marshal request for MAC: %+v",
+			err)
+	}
+	counter := atomic.AddUint64(&h.requestCounter, 1)
+	mac := computeRequestMAC(t, method, body, counter)
+	return metadata.AppendToOutgoingContext(ctx,
+		requestMACHeader, base64.StdEncoding.EncodeToString(mac),
+		requestCounterHeader, strconv.FormatUint(counter, 10)), nil
+}
+
+// macUnaryClientInterceptor MACs the unary requests sent to the Host.
+func (h *Host) macUnaryClientInterceptor(ctx context.Context, method string,
+	req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption) error {
+	ctx, err := h.packRequestMAC(ctx, method, req)
+	if err != nil {
+		return err
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// macStreamClientInterceptor MACs the opening of the streams to the Host.
+// The MAC is computed with an empty body, so the stream messages are not
+// covered.
+func (h *Host) macStreamClientInterceptor(ctx context.Context,
+	desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+	streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream,
+	error) {
+	ctx, err := h.packRequestMAC(ctx, method, nil)
+	if err != nil {
+		return nil, err
+	}
+	return streamer(ctx, desc, cc, method, opts...)
+}
+
+// unpackRequestMAC returns the MAC and counter of an incoming request.
+// Returns false if the request carries no MAC.
+func unpackRequestMAC(ctx context.Context) ([]byte, uint64, bool, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, 0, false, nil
+	}
+	macStr := md.Get(requestMACHeader)
+	if len(macStr) == 0 {
+		return nil, 0, false, nil
+	}
+	mac, err := base64.StdEncoding.DecodeString(macStr[0])
+	if err != nil {
+		return nil, 0, true, errors.WithMessage(err, "could not decode MAC")
+	}
+	counterStr := md.Get(requestCounterHeader)
+	if len(counterStr) == 0 {
+		return nil, 0, true, errors.New("request MAC counter missing " +
+			"from header")
+	}
+	counter, err := strconv.ParseUint(counterStr[0], 10, 64)
+	if err != nil {
+		return nil, 0, true, errors.WithMessage(err,
+			"could not decode MAC counter")
+	}
+	return mac, counter, true, nil
+}
+
+// verifyRequestMAC checks the MAC of an incoming request against the
+// reception token of the Host and accepts its counter. The body is the
+// request for unary calls and nil for streams.
+func (h *Host) verifyRequestMAC(ctx context.Context, receptionToken token.Token,
+	req interface{}, mac []byte, counter uint64) error {
+	method, _ := grpc.Method(ctx)
+	body, err := marshalRequestBody(req)
+	if err != nil {
+		return errors.Errorf("could not marshal request: %+v", err)
+	}
+
+	expected := computeRequestMAC(receptionToken, method, body, counter)
+	if !hmac.Equal(mac, expected) {
+		return errors.Errorf("invalid MAC for %s", method)
+	}
+	if !h.requestCounters.accept(counter) {
+		return errors.Errorf("MAC counter %d was already used or is too old",
+			counter)
+	}
+	return nil
+}
+
+// counterWindow tracks the request counters received under a reception
+// token. A counter is accepted once, as long as it is within
+// requestCounterWindow of the highest counter received. The zero value is
+// ready to use.
+type counterWindow struct {
+	max  uint64
+	seen map[uint64]struct{}
+	mux  sync.Mutex
+}
+
+// accept records the counter and returns true if it was not received
+// before and is within the window.
+func (cw *counterWindow) accept(counter uint64) bool {
+	cw.mux.Lock()
+	defer cw.mux.Unlock()
+
+	if counter == 0 || counter+requestCounterWindow <= cw.max {
+		return false
+	}
+	if _, ok := cw.seen[counter]; ok {
+		return false
+	}
+	if cw.seen == nil {
+		cw.seen = make(map[uint64]struct{})
+	}
+	cw.seen[counter] = struct{}{}
+
+	if counter > cw.max {
+		cw.max = counter
+	}
+
+	// Drop counters which fell out of the window once they dominate the set
+	if len(cw.seen) > 2*requestCounterWindow {
+		for c := range cw.seen {
+			if c+requestCounterWindow <= cw.max {
+				delete(cw.seen, c)
+			}
+		}
+	}
+	return true
+}
+
+// reset forgets all counters, for use when the reception token is replaced.
+func (cw *counterWindow) reset() {
+	cw.mux.Lock()
+	defer cw.mux.Unlock()
+	cw.max = 0
+	cw.seen = nil
+}
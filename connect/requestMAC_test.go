@@ -0,0 +1,226 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"context"
+	"gitlab.com/xx_network/comms/connect/token"
+	pb "gitlab.com/xx_network/comms/messages"
+	"gitlab.com/xx_network/primitives/id"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+// methodStream is a grpc.ServerTransportStream reporting a method name, so
+// that grpc.Method works on test contexts.
+type methodStream struct {
+	method string
+}
+
+func (s *methodStream) Method() string               { return s.method }
+func (s *methodStream) SetHeader(metadata.MD) error  { return nil }
+func (s *methodStream) SendHeader(metadata.MD) error { return nil }
+func (s *methodStream) SetTrailer(metadata.MD) error { return nil }
+
+// macTestContext packs the MAC of the request sent by the client Host into
+// an incoming context for the method.
+func macTestContext(t *testing.T, h *Host, method string,
+	req interface{}) context.Context {
+	ctx, err := h.packRequestMAC(context.Background(), method, req)
+	if err != nil {
+		t.Fatalf("Failed to pack request MAC: %+v", err)
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return grpc.NewContextWithServerTransportStream(
+		metadata.NewIncomingContext(context.Background(), md),
+		&methodStream{method: method})
+}
+
+// Tests that counterWindow accepts each counter once and rejects counters
+// which fell out of the window.
+func TestCounterWindow_accept(t *testing.T) {
+	cw := &counterWindow{}
+	if cw.accept(0) {
+		t.Errorf("Accepted counter 0")
+	}
+	for _, c := range []uint64{2, 1, 3} {
+		if !cw.accept(c) {
+			t.Errorf("Failed to accept counter %d", c)
+		}
+		if cw.accept(c) {
+			t.Errorf("Accepted counter %d twice", c)
+		}
+	}
+
+	if !cw.accept(3 + requestCounterWindow) {
+		t.Errorf("Failed to accept counter at the end of the window")
+	}
+	if cw.accept(3) || !cw.accept(4) {
+		t.Errorf("Window did not move with the highest counter")
+	}
+
+	cw.reset()
+	if !cw.accept(1) {
+		t.Errorf("Failed to accept counter after reset")
+	}
+}
+
+// Tests that verifyRequestMAC accepts a request MACed with the token once and
+// rejects altered requests.
+func TestHost_verifyRequestMAC(t *testing.T) {
+	tkn := token.Token{}
+	copy(tkn[:], "requestMACToken")
+	params := GetDefaultHostParams()
+	params.EnableRequestMAC = true
+	client, err := NewHost(id.NewIdFromString("server", id.Node, t), "", nil,
+		params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.transmissionToken.Set(tkn)
+	server, err := NewHost(id.NewIdFromString("client", id.Node, t), "", nil,
+		GetDefaultHostParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const method = "/messages.Generic/AuthenticateToken"
+
+	verify := func(ctx context.Context, req interface{}) error {
+		mac, counter, ok, err := unpackRequestMAC(ctx)
+		if err != nil || !ok {
+			t.Fatalf("Failed to unpack request MAC: %v %+v", ok, err)
+		}
+		return server.verifyRequestMAC(ctx, tkn, req, mac, counter)
+	}
+
+	req := &pb.Ack{Error: "body"}
+	ctx := macTestContext(t, client, method, req)
+	if err = verify(ctx, req); err != nil {
+		t.Fatalf("Failed to verify request MAC: %+v", err)
+	}
+	if err = verify(ctx, req); err == nil {
+		t.Errorf("Verified a replayed request")
+	}
+
+	ctx = macTestContext(t, client, method, req)
+	if err = verify(ctx, &pb.Ack{Error: "altered"}); err == nil {
+		t.Errorf("Verified a request with an altered body")
+	}
+
+	ctx = macTestContext(t, client, method, req)
+	ctx = grpc.NewContextWithServerTransportStream(ctx,
+		&methodStream{method: "/messages.Generic/RequestToken"})
+	if err = verify(ctx, req); err == nil {
+		t.Errorf("Verified a request to another method")
+	}
+
+	ctx = macTestContext(t, client, method, nil)
+	other := token.Token{}
+	copy(other[:], "otherToken")
+	mac, counter, _, _ := unpackRequestMAC(ctx)
+	if err = server.verifyRequestMAC(ctx, other, nil, mac, counter); err == nil {
+		t.Errorf("Verified a request MACed with another token")
+	}
+}
+
+// Tests that requests from a Host with EnableRequestMAC are authenticated by
+// their MAC without sending the token, and that a server requiring request
+// MACs rejects requests carrying only the token.
+func TestProtoComms_RequestMAC(t *testing.T) {
+	serverID := id.NewIdFromString("server", id.Node, t)
+	opts := GetDefaultServerOptions()
+	opts.RequireRequestMAC = true
+	pc, err := StartCommServerWithOptions(serverID, "127.0.0.1:11438", nil,
+		nil, nil, opts)
+	if err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	defer pc.Shutdown(context.Background())
+	pc.RequireAuthentication("/messages.Generic/RequestToken",
+		"/messages.Generic/AuthenticateToken")
+	pb.RegisterGenericServer(pc.GetServer(), &authCheckServer{})
+	pc.Serve()
+
+	clientID := id.NewIdFromString("client", id.Node, t)
+	client, err := CreateCommClient(clientID, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := GetDefaultHostParams()
+	params.AuthEnabled = false
+	params.EnableRequestMAC = true
+	serverHost, err := client.AddHost(serverID, "127.0.0.1:11438", nil, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverHost.Disconnect()
+	clientHost, err := pc.AddHost(clientID, "", nil, GetDefaultHostParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tkn := token.Token{}
+	copy(tkn[:], "requestMACToken")
+	clientHost.receptionToken.Set(tkn)
+
+	var msg *pb.AuthenticatedMessage
+	_, err = client.transmit(serverHost, func(conn Connection) (interface{}, error) {
+		// The token is cleared when connecting
+		serverHost.transmissionToken.Set(tkn)
+		msg, err = client.PackAuthenticatedMessage(&pb.Ping{}, serverHost,
+			false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Token != nil {
+			t.Errorf("Token was sent with a MACed request")
+		}
+
+		ctx, cancel := serverHost.GetMessagingContext()
+		defer cancel()
+		gc := pb.NewGenericClient(conn.GetGrpcConn())
+
+		resp, err := gc.RequestToken(
+			client.PackAuthenticatedContext(serverHost, ctx), &pb.Ping{})
+		if err != nil {
+			t.Errorf("MACed context call failed: %+v", err)
+		} else if !clientID.Cmp(id.NewIdFromBytes(resp.Token, t)) {
+			t.Errorf("Handler did not receive the sender in its Auth")
+		}
+
+		ack, err := gc.AuthenticateToken(ctx, msg)
+		if err != nil {
+			t.Errorf("MACed message call failed: %+v", err)
+		} else if ack.Error != "authenticated" {
+			t.Errorf("Handler did not receive the Auth: %s", ack.Error)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A request carrying only the token is rejected
+	conn, err := grpc.Dial("127.0.0.1:11438",
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg.Token = tkn.Marshal()
+	_, err = pb.NewGenericClient(conn).AuthenticateToken(ctx, msg)
+	if status.Code(err) != codes.Unauthenticated || !IsAuthError(err) {
+		t.Errorf("Expected an Unauthenticated auth error, received: %+v", err)
+	}
+}
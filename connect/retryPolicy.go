@@ -0,0 +1,240 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// Contains the policies deciding how connection attempts and sends are retried
+
+package connect
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Bounds of the connection attempt timeouts of the legacy policy
+const (
+	legacyAttemptTimeout    = 2 * time.Second
+	legacyMaxAttemptTimeout = 15 * time.Second
+)
+
+// RetryPolicy decides whether and when a failed attempt is retried. Hosts use
+// one policy for connection attempts and one for send attempts, set in
+// HostParams.
+type RetryPolicy interface {
+	// Retry is called after attempt number attempt, counting from zero,
+	// failed with err, elapsed after the first attempt started. It returns
+	// whether to make another attempt and how long to wait before it.
+	Retry(attempt uint32, elapsed time.Duration, err error) (time.Duration,
+		bool)
+
+	// AttemptTimeout returns how long attempt number attempt may take. Zero
+	// leaves the attempt unbounded.
+	AttemptTimeout(attempt uint32) time.Duration
+}
+
+// ExponentialBackoff is a RetryPolicy which waits exponentially longer
+// between attempts, with random jitter to spread out retries from many
+// clients.
+type ExponentialBackoff struct {
+	// Wait before the first retry
+	InitialInterval time.Duration
+
+	// Maximum wait between attempts, before jitter
+	MaxInterval time.Duration
+
+	// Factor the wait grows by after each attempt. Values below 1 are
+	// treated as 1.
+	Multiplier float64
+
+	// Fraction of the wait which is randomized, between 0 and 1. A wait w
+	// becomes a random value in [w*(1-Jitter), w*(1+Jitter)].
+	Jitter float64
+
+	// Maximum number of attempts, including the first. Zero is unlimited.
+	MaxAttempts uint32
+
+	// Time after the first attempt after which no more attempts are made.
+	// Zero is unlimited.
+	MaxElapsed time.Duration
+
+	// Time each attempt may take. Zero leaves attempts unbounded.
+	PerAttemptTimeout time.Duration
+
+	// Returns true for errors which are retried. Nil retries every error.
+	Retryable func(err error) bool
+}
+
+// GetDefaultConnectRetryPolicy returns an ExponentialBackoff suited to
+// connection attempts. It retries every error for up to two minutes. It is
+// not used unless set as the HostParams.ConnectRetryPolicy.
+func GetDefaultConnectRetryPolicy() ExponentialBackoff {
+	return ExponentialBackoff{
+		InitialInterval:   250 * time.Millisecond,
+		MaxInterval:       15 * time.Second,
+		Multiplier:        2,
+		Jitter:            0.2,
+		MaxAttempts:       0,
+		MaxElapsed:        2 * time.Minute,
+		PerAttemptTimeout: legacyMaxAttemptTimeout,
+	}
+}
+
+// GetDefaultSendRetryPolicy returns an ExponentialBackoff suited to sends. It
+// makes up to three attempts, retrying only the errors IsRetryableSendError
+// accepts. It is not used unless set as the HostParams.SendRetryPolicy.
+func GetDefaultSendRetryPolicy() ExponentialBackoff {
+	return ExponentialBackoff{
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     5 * time.Second,
+		Multiplier:      2,
+		Jitter:          0.2,
+		MaxAttempts:     3,
+		MaxElapsed:      0,
+		Retryable:       IsRetryableSendError,
+	}
+}
+
+// Retry returns whether to retry after the attempt and the jittered wait
+// before the next one.
+func (eb ExponentialBackoff) Retry(attempt uint32, elapsed time.Duration,
+	err error) (time.Duration, bool) {
+	if eb.Retryable != nil && !eb.Retryable(err) {
+		return 0, false
+	}
+	if eb.MaxAttempts > 0 && attempt+1 >= eb.MaxAttempts {
+		return 0, false
+	}
+
+	wait := eb.backoff(attempt)
+	if eb.MaxElapsed > 0 && elapsed+wait >= eb.MaxElapsed {
+		return 0, false
+	}
+	return wait, true
+}
+
+// AttemptTimeout returns the PerAttemptTimeout.
+func (eb ExponentialBackoff) AttemptTimeout(uint32) time.Duration {
+	return eb.PerAttemptTimeout
+}
+
+// backoff returns the jittered wait after the attempt.
+func (eb ExponentialBackoff) backoff(attempt uint32) time.Duration {
+	multiplier := math.Max(eb.Multiplier, 1)
+	wait := float64(eb.InitialInterval) * math.Pow(multiplier, float64(attempt))
+	if eb.MaxInterval > 0 && wait > float64(eb.MaxInterval) {
+		wait = float64(eb.MaxInterval)
+	}
+
+	jitter := math.Min(math.Max(eb.Jitter, 0), 1)
+	wait *= 1 + jitter*(2*rand.Float64()-1)
+	return time.Duration(wait)
+}
+
+// legacyRetryPolicy retries immediately up to a number of attempts. It is
+// the policy used when HostParams sets none, which GetDefaultHostParams does
+// not, so that hosts keep retrying MaxRetries times.
+type legacyRetryPolicy struct {
+	maxAttempts     uint32
+	attemptTimeouts bool
+	retryable       func(err error) bool
+}
+
+// NewLegacyConnectRetryPolicy returns the RetryPolicy connection attempts
+// used before RetryPolicy was added. It retries every error immediately, up
+// to maxAttempts attempts, allowing attempt n 2s*(n/16+1), capped at 15s.
+func NewLegacyConnectRetryPolicy(maxAttempts uint32) RetryPolicy {
+	return &legacyRetryPolicy{
+		maxAttempts:     maxAttempts,
+		attemptTimeouts: true,
+	}
+}
+
+// NewLegacySendRetryPolicy returns the RetryPolicy sends used before
+// RetryPolicy was added. It retries the errors IsRetryableSendError accepts
+// immediately, up to maxAttempts attempts, without bounding the attempts.
+func NewLegacySendRetryPolicy(maxAttempts uint32) RetryPolicy {
+	return &legacyRetryPolicy{
+		maxAttempts: maxAttempts,
+		retryable:   IsRetryableSendError,
+	}
+}
+
+// Retry returns true without a wait if attempts remain and the error is
+// retryable.
+func (lrp *legacyRetryPolicy) Retry(attempt uint32, _ time.Duration,
+	err error) (time.Duration, bool) {
+	if lrp.retryable != nil && !lrp.retryable(err) {
+		return 0, false
+	}
+	return 0, attempt+1 < lrp.maxAttempts
+}
+
+// AttemptTimeout returns the stepped timeout of connection attempts, or zero
+// for sends.
+func (lrp *legacyRetryPolicy) AttemptTimeout(attempt uint32) time.Duration {
+	if !lrp.attemptTimeouts {
+		return 0
+	}
+	timeout := legacyAttemptTimeout * time.Duration(attempt/16+1)
+	if timeout > legacyMaxAttemptTimeout {
+		timeout = legacyMaxAttemptTimeout
+	}
+	return timeout
+}
+
+// getConnectRetryPolicy returns the ConnectRetryPolicy of the Host, or the
+// legacy policy if none is set.
+func (h *Host) getConnectRetryPolicy() RetryPolicy {
+	if h.params.ConnectRetryPolicy == nil {
+		return NewLegacyConnectRetryPolicy(h.params.MaxRetries)
+	}
+	return h.params.ConnectRetryPolicy
+}
+
+// getSendRetryPolicy returns the SendRetryPolicy of the Host, or the legacy
+// policy if none is set.
+func (h *Host) getSendRetryPolicy() RetryPolicy {
+	if h.params.SendRetryPolicy == nil {
+		return NewLegacySendRetryPolicy(h.params.MaxRetries)
+	}
+	return h.params.SendRetryPolicy
+}
+
+// IsRetryableSendError returns true if a send which failed with the error
+// may succeed when retried after reconnecting and authenticating again. It
+// is the classification used by the default send policies.
+func IsRetryableSendError(err error) bool {
+	return isRetryable(err) || errors.Is(err, ErrHandshakeFailed)
+}
+
+// waitRetry waits before the next attempt. Returns false if the context ends
+// first.
+func waitRetry(ctx context.Context, wait time.Duration) bool {
+	if wait <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// attemptContext returns the context of an attempt, bounded by the
+// AttemptTimeout of the policy.
+func attemptContext(ctx context.Context, policy RetryPolicy,
+	attempt uint32) (context.Context, context.CancelFunc) {
+	if timeout := policy.AttemptTimeout(attempt); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
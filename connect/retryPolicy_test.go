@@ -0,0 +1,167 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"github.com/pkg/errors"
+	"gitlab.com/xx_network/primitives/id"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+// Tests that ExponentialBackoff stops retrying at its limits and keeps its
+// waits within the jitter bounds.
+func TestExponentialBackoff_Retry(t *testing.T) {
+	eb := ExponentialBackoff{
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     time.Second,
+		Multiplier:      2,
+		Jitter:          0.5,
+		MaxAttempts:     6,
+		MaxElapsed:      time.Minute,
+	}
+	err := errors.New("attempt failed")
+
+	expected := []time.Duration{100 * time.Millisecond,
+		200 * time.Millisecond, 400 * time.Millisecond,
+		800 * time.Millisecond, time.Second}
+	for attempt, base := range expected {
+		wait, retry := eb.Retry(uint32(attempt), 0, err)
+		if !retry {
+			t.Fatalf("Did not retry after attempt %d", attempt)
+		}
+		if wait < base/2 || wait > base*3/2 {
+			t.Errorf("Wait after attempt %d out of bounds."+
+				"\nexpected: %s ± 50%%\nreceived: %s", attempt, base, wait)
+		}
+	}
+
+	if _, retry := eb.Retry(5, 0, err); retry {
+		t.Errorf("Retried after MaxAttempts attempts")
+	}
+	if _, retry := eb.Retry(0, time.Minute, err); retry {
+		t.Errorf("Retried after MaxElapsed")
+	}
+
+	eb.Retryable = func(error) bool { return false }
+	if _, retry := eb.Retry(0, 0, err); retry {
+		t.Errorf("Retried an error Retryable rejected")
+	}
+}
+
+// Tests that the legacy policies keep the retry limits and connection
+// attempt timeouts used before RetryPolicy was added.
+func TestLegacyRetryPolicy(t *testing.T) {
+	connect := NewLegacyConnectRetryPolicy(3)
+	err := errors.New("attempt failed")
+	for attempt := uint32(0); attempt < 3; attempt++ {
+		wait, retry := connect.Retry(attempt, 0, err)
+		if wait != 0 || retry != (attempt < 2) {
+			t.Errorf("Unexpected retry after attempt %d: %s %t", attempt,
+				wait, retry)
+		}
+	}
+
+	timeouts := map[uint32]time.Duration{
+		0:   2 * time.Second,
+		15:  2 * time.Second,
+		16:  4 * time.Second,
+		200: 15 * time.Second,
+	}
+	for attempt, timeout := range timeouts {
+		if received := connect.AttemptTimeout(attempt); received != timeout {
+			t.Errorf("Unexpected timeout of attempt %d."+
+				"\nexpected: %s\nreceived: %s", attempt, timeout, received)
+		}
+	}
+
+	send := NewLegacySendRetryPolicy(3)
+	if send.AttemptTimeout(0) != 0 {
+		t.Errorf("Legacy send attempts are bounded")
+	}
+	if _, retry := send.Retry(0, 0, err); retry {
+		t.Errorf("Retried an unclassified send error")
+	}
+	if _, retry := send.Retry(0, 0, FromGrpcStatus(status.Error(
+		codes.Unavailable, "transport is closing"))); !retry {
+		t.Errorf("Did not retry a disconnected send")
+	}
+	if _, retry := send.Retry(0, 0, &handshakeError{err}); !retry {
+		t.Errorf("Did not retry a failed handshake")
+	}
+}
+
+// Tests that handshakeError is in the ErrHandshakeFailed class and keeps the
+// cause.
+func TestHandshakeError(t *testing.T) {
+	err := errors.WithMessage(&handshakeError{ErrCoolOff}, "connect")
+	if !errors.Is(err, ErrHandshakeFailed) {
+		t.Errorf("Error is not in the %q class: %+v", ErrHandshakeFailed, err)
+	}
+	if !errors.Is(err, ErrCoolOff) {
+		t.Errorf("Error did not keep its cause: %+v", err)
+	}
+}
+
+// Tests that hosts created with the default params keep the legacy policies,
+// so that overriding MaxRetries still bounds their attempts.
+func TestHost_getRetryPolicy_Default(t *testing.T) {
+	params := GetDefaultHostParams()
+	params.MaxRetries = 2
+	h := &Host{params: params}
+
+	if _, ok := h.getConnectRetryPolicy().(*legacyRetryPolicy); !ok {
+		t.Errorf("Default connect policy is not the legacy policy: %T",
+			h.getConnectRetryPolicy())
+	}
+	policy := h.getSendRetryPolicy()
+	if _, ok := policy.(*legacyRetryPolicy); !ok {
+		t.Fatalf("Default send policy is not the legacy policy: %T", policy)
+	}
+	err := FromGrpcStatus(status.Error(codes.Unavailable,
+		"transport is closing"))
+	if _, retry := policy.Retry(0, 0, err); !retry {
+		t.Errorf("First attempt was not retried")
+	}
+	if _, retry := policy.Retry(1, 0, err); retry {
+		t.Errorf("Attempt beyond MaxRetries was retried")
+	}
+}
+
+// Tests that sends follow the SendRetryPolicy of the Host.
+func TestProtoComms_transmit_SendRetryPolicy(t *testing.T) {
+	c := &ProtoComms{Manager: newManager()}
+	policy := GetDefaultSendRetryPolicy()
+	policy.InitialInterval = time.Millisecond
+	policy.MaxAttempts = 2
+	params := GetDefaultHostParams()
+	params.AuthEnabled = false
+	params.MaxRetries = 5
+	params.SendRetryPolicy = policy
+	host, err := c.AddHost(id.NewIdFromString("retryPolicy", id.Node, t),
+		ServerAddress, nil, params)
+	if err != nil {
+		t.Fatalf("Failed to add host: %+v", err)
+	}
+	defer host.Disconnect()
+
+	var tries uint32
+	_, err = c.transmit(host, func(Connection) (interface{}, error) {
+		tries++
+		return nil, status.Error(codes.Unavailable, "transport is closing")
+	})
+	if !errors.Is(err, ErrHostDisconnected) {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+	if tries != policy.MaxAttempts {
+		t.Errorf("Unexpected number of tries."+
+			"\nexpected: %d\nreceived: %d", policy.MaxAttempts, tries)
+	}
+}
@@ -0,0 +1,179 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"gitlab.com/xx_network/comms/connect/token"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"math"
+	"time"
+)
+
+// ServerOptions is the configuration object for the server side of a
+// ProtoComms. It is passed at construction, stored on the ProtoComms and
+// reused when the server is rebuilt by Restart.
+type ServerOptions struct {
+	// Keepalive options for accepted connections
+	KaOpts keepalive.ServerParameters
+
+	// Keepalive enforcement options for accepted connections
+	KaEnforcement keepalive.EnforcementPolicy
+
+	// Number of server-side streams to allow open per connection. Zero
+	// leaves the number unbounded.
+	MaxConcurrentStreams uint32
+
+	// Maximum size of a message the server can receive, in bytes. Zero uses
+	// the gRPC default.
+	MaxRecvMsgSize int
+
+	// Maximum size of a message the server can send, in bytes. Zero uses the
+	// gRPC default.
+	MaxSendMsgSize int
+
+	// If set, a server started without a certificate serves without TLS
+	// instead of failing. For testing only. Defaults to
+	// TestingOnlyDisableTLS.
+	DisableTLS bool
+
+	// Network the server listens on, either "tcp" or "unix". Empty defaults
+	// to "tcp".
+	Network string
+
+	// Retry policy used when the listening address is already in use
+	BindRetry BindRetryParams
+
+	// Lifetime and size bound of the tokens handed out to clients which have
+	// not completed the handshake
+	TokenParams token.MapParams
+
+	// How long the reception token of an authenticated Host stays valid
+	// before the Host must authenticate again. Zero never expires it.
+	ReceptionTokenTTL time.Duration
+
+	// If set, handshakes whose token is not bound to the TLS session they
+	// were made on are rejected. Otherwise unbound tokens, such as those of
+	// grpc-web clients, are accepted and can be used on any connection.
+	RequireChannelBinding bool
+
+	// Signed messages carrying a timestamp and nonce are rejected if the
+	// timestamp is further than this from the local time, or if the nonce
+	// was already received from the sender. Zero disables the check.
+	ReplayWindow time.Duration
+
+	// Maximum number of nonces kept to detect replays. When exceeded, the
+	// oldest are dropped and messages sent before them are rejected. Zero
+	// leaves the number unbounded.
+	ReplayCacheSize int
+
+	// If set, signed messages without a timestamp and nonce are rejected
+	RequireReplayProtection bool
+
+	// If set, authenticated requests without a request MAC are rejected.
+	// Otherwise requests carrying only the raw token are accepted. The MAC
+	// of a stream covers its method name and counter, not its messages.
+	// grpc-web clients cannot send request MACs, so their authenticated
+	// requests are rejected if this is set.
+	RequireRequestMAC bool
+
+	/* TCP tuning, zero values use the gRPC and net package defaults */
+
+	// Period of TCP keepalive probes on accepted connections. A negative
+	// value disables TCP keepalive.
+	TcpKeepAlive time.Duration
+
+	// Initial per-stream and per-connection flow control window sizes
+	InitialWindowSize     int32
+	InitialConnWindowSize int32
+
+	// Size of the per-connection read and write buffers
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// Timeout for connection establishment, including the TLS handshake
+	ConnectionTimeout time.Duration
+}
+
+// GetDefaultServerOptions returns the default set of server options.
+func GetDefaultServerOptions() ServerOptions {
+	return ServerOptions{
+		KaOpts:               KaOpts,
+		KaEnforcement:        KaEnforcement,
+		MaxConcurrentStreams: MaxConcurrentStreams,
+		MaxRecvMsgSize:       math.MaxInt32,
+		DisableTLS:           TestingOnlyDisableTLS,
+		Network:              "tcp",
+		BindRetry: BindRetryParams{
+			MaxAttempts: 10,
+			Delay:       30 * time.Second,
+		},
+		TokenParams:       token.GetDefaultMapParams(),
+		ReceptionTokenTTL: 24 * time.Hour,
+		ReplayWindow:      5 * time.Minute,
+		ReplayCacheSize:   100000,
+	}
+}
+
+// BindRetryParams is the retry policy for binding a listening address that
+// is already in use.
+type BindRetryParams struct {
+	// Total number of attempts to bind the address. Zero is treated as a
+	// single attempt.
+	MaxAttempts uint32
+
+	// Amount of time to wait between attempts
+	Delay time.Duration
+}
+
+// tlsDisabled returns true if the options request a server without TLS.
+func (so ServerOptions) tlsDisabled() bool {
+	return so.DisableTLS
+}
+
+// newGrpcServer builds a grpc.Server configured by the options. If creds is
+// nil, the server is built without transport security. Any extra options are
+// appended to the ones built from the ServerOptions.
+func (so ServerOptions) newGrpcServer(creds credentials.TransportCredentials,
+	extra ...grpc.ServerOption) *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.KeepaliveParams(so.KaOpts),
+		grpc.KeepaliveEnforcementPolicy(so.KaEnforcement),
+	}
+	if creds != nil {
+		opts = append(opts, grpc.Creds(creds))
+	}
+	if so.MaxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(so.MaxConcurrentStreams))
+	}
+	if so.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(so.MaxRecvMsgSize))
+	}
+	if so.MaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(so.MaxSendMsgSize))
+	}
+	if so.InitialWindowSize > 0 {
+		opts = append(opts, grpc.InitialWindowSize(so.InitialWindowSize))
+	}
+	if so.InitialConnWindowSize > 0 {
+		opts = append(opts,
+			grpc.InitialConnWindowSize(so.InitialConnWindowSize))
+	}
+	if so.ReadBufferSize > 0 {
+		opts = append(opts, grpc.ReadBufferSize(so.ReadBufferSize))
+	}
+	if so.WriteBufferSize > 0 {
+		opts = append(opts, grpc.WriteBufferSize(so.WriteBufferSize))
+	}
+	if so.ConnectionTimeout > 0 {
+		opts = append(opts, grpc.ConnectionTimeout(so.ConnectionTimeout))
+	}
+
+	return grpc.NewServer(append(opts, extra...)...)
+}
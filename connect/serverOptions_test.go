@@ -0,0 +1,143 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2024 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package connect
+
+import (
+	"context"
+	"gitlab.com/xx_network/comms/testkeys"
+	"gitlab.com/xx_network/primitives/id"
+	"testing"
+	"time"
+)
+
+// Tests that GetDefaultServerOptions carries the TLS testing switch.
+func TestGetDefaultServerOptions(t *testing.T) {
+	opts := GetDefaultServerOptions()
+	if opts.DisableTLS != TestingOnlyDisableTLS {
+		t.Errorf("DisableTLS does not match TestingOnlyDisableTLS."+
+			"\nexpected: %t\nreceived: %t", TestingOnlyDisableTLS, opts.DisableTLS)
+	}
+	if opts.MaxConcurrentStreams == 0 || opts.MaxRecvMsgSize == 0 {
+		t.Errorf("Default options do not bound streams or message size: %+v",
+			opts)
+	}
+}
+
+// Tests that GetDefaultServerOptions is seeded from the deprecated globals.
+func TestGetDefaultServerOptions_Globals(t *testing.T) {
+	defer func(streams uint32) { MaxConcurrentStreams = streams }(
+		MaxConcurrentStreams)
+	MaxConcurrentStreams = 42
+
+	opts := GetDefaultServerOptions()
+	if opts.MaxConcurrentStreams != 42 {
+		t.Errorf("MaxConcurrentStreams was not seeded from the global."+
+			"\nexpected: %d\nreceived: %d", 42, opts.MaxConcurrentStreams)
+	}
+	if opts.KaOpts != KaOpts || opts.KaEnforcement != KaEnforcement {
+		t.Errorf("Keepalive options were not seeded from the globals: %+v",
+			opts)
+	}
+}
+
+// Tests that DisableTLS alone decides the TLS mode of a server, so that
+// servers with and without TLS can run in one process.
+func TestStartCommServerWithOptions_DisableTLS(t *testing.T) {
+	defer func(disable bool) { TestingOnlyDisableTLS = disable }(
+		TestingOnlyDisableTLS)
+	TestingOnlyDisableTLS = false
+
+	plain := GetDefaultServerOptions()
+	plain.DisableTLS = true
+	pcPlain, err := StartCommServerWithOptions(
+		id.NewIdFromString("plain", id.Node, t), "127.0.0.1:11448",
+		nil, nil, nil, plain)
+	if err != nil {
+		t.Fatalf("Failed to start server without TLS: %+v", err)
+	}
+	defer pcPlain.Shutdown(context.Background())
+
+	pcTls, err := StartCommServerWithOptions(
+		id.NewIdFromString("secure", id.Node, t), "127.0.0.1:11449",
+		testkeys.LoadFromPath(testkeys.GetNodeCertPath()),
+		testkeys.LoadFromPath(testkeys.GetNodeKeyPath()), nil,
+		GetDefaultServerOptions())
+	if err != nil {
+		t.Fatalf("Failed to start server with TLS: %+v", err)
+	}
+	defer pcTls.Shutdown(context.Background())
+
+	if !pcPlain.GetServerOptions().tlsDisabled() {
+		t.Errorf("Server without TLS reports TLS enabled")
+	}
+	if pcTls.GetServerOptions().tlsDisabled() {
+		t.Errorf("Server with TLS reports TLS disabled")
+	}
+
+	// The TLS mode is kept across a Restart regardless of the global
+	pcPlain.Shutdown(context.Background())
+	TestingOnlyDisableTLS = false
+	if err = pcPlain.Restart(); err != nil {
+		t.Fatalf("Failed to restart server without TLS: %+v", err)
+	}
+	if !pcPlain.GetServerOptions().tlsDisabled() {
+		t.Errorf("Restart changed the TLS mode")
+	}
+}
+
+// Tests that two servers with different options can run in one process and
+// that each keeps its own options across a Restart.
+func TestStartCommServerWithOptions(t *testing.T) {
+	optsA := GetDefaultServerOptions()
+	optsA.MaxConcurrentStreams = 10
+	optsA.TcpKeepAlive = 30 * time.Second
+
+	optsB := GetDefaultServerOptions()
+	optsB.MaxRecvMsgSize = 1024
+	optsB.WriteBufferSize = 64 * 1024
+	optsB.ConnectionTimeout = 5 * time.Second
+
+	pcA, err := StartCommServerWithOptions(
+		id.NewIdFromString("serverA", id.Node, t), "0.0.0.0:11430",
+		nil, nil, nil, optsA)
+	if err != nil {
+		t.Fatalf("Failed to start server A: %+v", err)
+	}
+	pcB, err := StartCommServerWithOptions(
+		id.NewIdFromString("serverB", id.Node, t), "0.0.0.0:11431",
+		nil, nil, nil, optsB)
+	if err != nil {
+		t.Fatalf("Failed to start server B: %+v", err)
+	}
+
+	pcA.Serve()
+	pcB.Serve()
+	time.Sleep(time.Second)
+
+	if pcA.GetServerOptions().MaxConcurrentStreams != 10 {
+		t.Errorf("Server A did not keep its options: %+v",
+			pcA.GetServerOptions())
+	}
+	if pcB.GetServerOptions().MaxRecvMsgSize != 1024 {
+		t.Errorf("Server B did not keep its options: %+v",
+			pcB.GetServerOptions())
+	}
+
+	pcA.Shutdown(context.Background())
+	err = pcA.Restart()
+	if err != nil {
+		t.Fatalf("Failed to restart server A: %+v", err)
+	}
+	if pcA.GetServerOptions() != optsA {
+		t.Errorf("Restart did not reuse the server options."+
+			"\nexpected: %+v\nreceived: %+v", optsA, pcA.GetServerOptions())
+	}
+
+	pcA.Shutdown(context.Background())
+	pcB.Shutdown(context.Background())
+}
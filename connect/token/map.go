@@ -11,22 +11,60 @@ import (
 	jww "github.com/spf13/jwalterweatherman"
 	"gitlab.com/xx_network/crypto/nonce"
 	"sync"
+	"time"
 )
 
+// MapParams is the configuration object for a Map of pending tokens
+type MapParams struct {
+	// How long a generated token waits for the client to complete the
+	// handshake before it expires. Rounded up to whole seconds.
+	TTL time.Duration
+
+	// Maximum number of pending tokens. When exceeded, the oldest tokens are
+	// dropped. Zero leaves the number unbounded.
+	MaxSize int
+}
+
+// GetDefaultMapParams returns the default set of map params
+func GetDefaultMapParams() MapParams {
+	return MapParams{
+		TTL:     nonce.RegistrationTTL * time.Second,
+		MaxSize: 100000,
+	}
+}
+
+// Map stores the tokens handed out to clients until they are validated or
+// expire. Expired tokens are cleaned up as new tokens are generated and by
+// Cleanup, which servers call every TTL.
 type Map struct {
-	m   map[Token]nonce.Nonce
-	mux sync.Mutex
+	m map[Token]nonce.Nonce
+	// Tokens in the order they were generated, which is also the order in
+	// which they expire. May include tokens which were already validated.
+	queue  []Token
+	params MapParams
+	mux    sync.Mutex
 }
 
+// NewMap creates a Map using the default params.
 func NewMap() *Map {
+	return NewMapWithParams(GetDefaultMapParams())
+}
+
+// NewMapWithParams creates a Map using the given params.
+func NewMapWithParams(params MapParams) *Map {
 	return &Map{
-		m:   make(map[Token]nonce.Nonce),
-		mux: sync.Mutex{},
+		m:      make(map[Token]nonce.Nonce),
+		params: params,
+		mux:    sync.Mutex{},
 	}
 }
 
 func (m *Map) Generate() Token {
-	newNonce, err := nonce.NewNonce(nonce.RegistrationTTL)
+	ttl := uint((m.params.TTL + time.Second - 1) / time.Second)
+	if ttl == 0 {
+		ttl = nonce.RegistrationTTL
+	}
+	newNonce, err := nonce.NewNonce(ttl)
 	if err != nil {
 		jww.FATAL.Panicf("Failed to generate new Token/Nonce pair: %s", err)
 	}
@@ -35,7 +73,9 @@ func (m *Map) Generate() Token {
 
 	m.mux.Lock()
 
+	m.cleanup(true)
 	m.m[newToken] = newNonce
+	m.queue = append(m.queue, newToken)
 
 	m.mux.Unlock()
 
@@ -57,3 +97,48 @@ func (m *Map) Validate(token Token) bool {
 	return retrievedNonce.IsValid()
 
 }
+
+// Len returns the number of outstanding tokens.
+func (m *Map) Len() int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return len(m.m)
+}
+
+// Cleanup removes all expired tokens.
+func (m *Map) Cleanup() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.cleanup(false)
+}
+
+// cleanup removes expired tokens and, if making room for a new token in a
+// full map, the oldest tokens. Must be called under the lock.
+func (m *Map) cleanup(makeRoom bool) {
+	now := time.Now()
+	i := 0
+	for ; i < len(m.queue); i++ {
+		t := m.queue[i]
+		n, ok := m.m[t]
+		full := makeRoom && m.params.MaxSize > 0 &&
+			len(m.m) >= m.params.MaxSize
+		if ok && !full && now.Before(n.ExpiryTime) {
+			break
+		}
+		if ok {
+			delete(m.m, t)
+		}
+	}
+	m.queue = m.queue[i:]
+
+	// Drop validated tokens from the queue once they dominate it
+	if len(m.queue) > 2*len(m.m)+64 {
+		queue := make([]Token, 0, len(m.m))
+		for _, t := range m.queue {
+			if _, ok := m.m[t]; ok {
+				queue = append(queue, t)
+			}
+		}
+		m.queue = queue
+	}
+}
@@ -10,6 +10,7 @@ package token
 import (
 	nonce2 "gitlab.com/xx_network/crypto/nonce"
 	"testing"
+	"time"
 )
 
 // Unit test for NewMap
@@ -53,3 +54,82 @@ func TestMap_ValidateError(t *testing.T) {
 		t.Error("Token should not have validated")
 	}
 }
+
+// Unit test for Map.Len()
+func TestMap_Len(t *testing.T) {
+	m := NewMap()
+	token := m.Generate()
+	_ = m.Generate()
+	if m.Len() != 2 {
+		t.Errorf("Unexpected length.\nexpected: %d\nreceived: %d", 2, m.Len())
+	}
+
+	m.Validate(token)
+	if m.Len() != 1 {
+		t.Errorf("Unexpected length.\nexpected: %d\nreceived: %d", 1, m.Len())
+	}
+}
+
+// Tests that expired tokens are removed when new tokens are generated.
+func TestMap_Generate_CleansExpired(t *testing.T) {
+	m := NewMapWithParams(MapParams{TTL: time.Second})
+	expired := m.Generate()
+	time.Sleep(1100 * time.Millisecond)
+
+	m.Generate()
+	if m.Len() != 1 {
+		t.Errorf("Expired token was not removed: %d tokens", m.Len())
+	}
+	if m.Validate(expired) {
+		t.Errorf("Expired token validated")
+	}
+}
+
+// Tests that the oldest tokens are dropped when the map is full.
+func TestMap_Generate_MaxSize(t *testing.T) {
+	m := NewMapWithParams(MapParams{TTL: time.Minute, MaxSize: 3})
+	tokens := make([]Token, 5)
+	for i := range tokens {
+		tokens[i] = m.Generate()
+	}
+
+	if m.Len() != 3 {
+		t.Errorf("Unexpected length.\nexpected: %d\nreceived: %d", 3, m.Len())
+	}
+	if m.Validate(tokens[0]) || m.Validate(tokens[1]) {
+		t.Errorf("Oldest tokens were not dropped")
+	}
+	if !m.Validate(tokens[4]) {
+		t.Errorf("Newest token was dropped")
+	}
+}
+
+// Tests that Cleanup removes expired tokens but keeps live tokens, even when
+// the map is full.
+func TestMap_Cleanup(t *testing.T) {
+	m := NewMapWithParams(MapParams{TTL: time.Second, MaxSize: 2})
+	m.Generate()
+	time.Sleep(1100 * time.Millisecond)
+	m.Generate()
+	live := m.Generate()
+
+	m.Cleanup()
+	if m.Len() != 2 {
+		t.Errorf("Unexpected length.\nexpected: %d\nreceived: %d", 2, m.Len())
+	}
+	m.Cleanup()
+	if !m.Validate(live) {
+		t.Errorf("Live token was removed")
+	}
+}
+
+// Tests that validated tokens do not accumulate in the generation queue.
+func TestMap_cleanup_CompactsQueue(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 1000; i++ {
+		m.Validate(m.Generate())
+	}
+	if len(m.queue) > 100 {
+		t.Errorf("Queue was not compacted: %d entries", len(m.queue))
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/pkg/errors"
 	"gitlab.com/xx_network/crypto/nonce"
 	"sync"
+	"time"
 )
 
 type Token [nonce.NonceLen]byte
@@ -44,6 +45,11 @@ type Live struct {
 	mux sync.RWMutex
 	t   Token
 	has bool
+	// Time after which the token is no longer valid, zero if it never expires
+	expiry time.Time
+	// Keying material of the TLS session the token is bound to, nil if the
+	// token is not bound
+	binding []byte
 }
 
 // Constructor which initializes a token for
@@ -60,7 +66,7 @@ func (l *Live) Get() (Token, bool) {
 	defer l.mux.RUnlock()
 	var tCopy Token
 	copy(tCopy[:], l.t[:])
-	return tCopy, l.has
+	return tCopy, l.valid()
 }
 
 // Get reads and returns the token
@@ -77,14 +83,58 @@ func (l *Live) GetBytes() []byte {
 func (l *Live) Has() bool {
 	l.mux.RLock()
 	defer l.mux.RUnlock()
-	return l.has
+	return l.valid()
+}
+
+// GetExpiry returns the time after which the token expires. Returns false if
+// there is no token or it never expires.
+func (l *Live) GetExpiry() (time.Time, bool) {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+	return l.expiry, l.has && !l.expiry.IsZero()
+}
+
+// GetBinding returns the keying material of the TLS session the token is
+// bound to. Returns nil if there is no token or it is not bound.
+func (l *Live) GetBinding() []byte {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+	if !l.has {
+		return nil
+	}
+	return append([]byte(nil), l.binding...)
+}
+
+// valid returns true if a token is present and has not expired. Must be
+// called under the lock.
+func (l *Live) valid() bool {
+	return l.has && (l.expiry.IsZero() || time.Now().Before(l.expiry))
 }
 
 // Set rewrites the token for negotiation or renegotiation
 func (l *Live) Set(newToken Token) {
+	l.SetWithExpiry(newToken, time.Time{})
+}
+
+// SetWithExpiry rewrites the token and sets the time after which it is no
+// longer present. A zero expiry means the token never expires.
+func (l *Live) SetWithExpiry(newToken Token, expiry time.Time) {
+	l.SetBound(newToken, expiry, nil)
+}
+
+// SetBound rewrites the token, sets its expiry and binds it to the TLS
+// session with the given keying material. A nil binding leaves the token
+// unbound.
+func (l *Live) SetBound(newToken Token, expiry time.Time, binding []byte) {
 	l.mux.Lock()
 	copy(l.t[:], newToken[:])
 	l.has = true
+	l.expiry = expiry
+	if binding == nil {
+		l.binding = nil
+	} else {
+		l.binding = append([]byte(nil), binding...)
+	}
 	l.mux.Unlock()
 }
 
@@ -96,5 +146,7 @@ func (l *Live) Clear() {
 		l.t[i] = 0
 	}
 	l.has = false
+	l.expiry = time.Time{}
+	l.binding = nil
 	l.mux.Unlock()
 }
@@ -12,6 +12,7 @@ import (
 	nonce2 "gitlab.com/xx_network/crypto/nonce"
 	"reflect"
 	"testing"
+	"time"
 )
 
 // ##############
@@ -256,3 +257,60 @@ func TestToken_Equals(t *testing.T) {
 		t.Errorf("Tokens made with different data were equal")
 	}
 }
+
+// Tests that a token set with an expiry is no longer present after it.
+func TestLive_SetWithExpiry(t *testing.T) {
+	l := NewLive()
+	var tkn Token
+	copy(tkn[:], "expiring")
+
+	expiry := time.Now().Add(time.Hour)
+	l.SetWithExpiry(tkn, expiry)
+	if !l.Has() {
+		t.Errorf("Token with future expiry is not present")
+	}
+	if received, ok := l.GetExpiry(); !ok || !received.Equal(expiry) {
+		t.Errorf("Unexpected expiry.\nexpected: %s\nreceived: %s",
+			expiry, received)
+	}
+
+	l.SetWithExpiry(tkn, time.Now().Add(-time.Second))
+	if _, ok := l.Get(); ok || l.Has() || l.GetBytes() != nil {
+		t.Errorf("Expired token is present")
+	}
+
+	l.Set(tkn)
+	if _, ok := l.GetExpiry(); ok || !l.Has() {
+		t.Errorf("Set did not clear the expiry")
+	}
+}
+
+// Tests that SetBound stores a copy of the binding and that setting or
+// clearing the token unbinds it.
+func TestLive_SetBound(t *testing.T) {
+	l := NewLive()
+	var tkn Token
+	copy(tkn[:], "bound")
+
+	binding := []byte("keying material")
+	l.SetBound(tkn, time.Time{}, binding)
+	if !bytes.Equal(l.GetBinding(), binding) {
+		t.Errorf("Unexpected binding.\nexpected: %v\nreceived: %v",
+			binding, l.GetBinding())
+	}
+	binding[0] = 0
+	if bytes.Equal(l.GetBinding(), binding) {
+		t.Errorf("Binding was not copied")
+	}
+
+	l.Set(tkn)
+	if l.GetBinding() != nil {
+		t.Errorf("Set did not clear the binding")
+	}
+
+	l.SetBound(tkn, time.Time{}, binding)
+	l.Clear()
+	if l.GetBinding() != nil {
+		t.Errorf("Clear did not clear the binding")
+	}
+}
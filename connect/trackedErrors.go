@@ -7,6 +7,11 @@
 
 package connect
 
+import (
+	"github.com/pkg/errors"
+	"strings"
+)
+
 // This file contains errors that are tracked by or returned by the host
 // depending on their tracked metrics.
 
@@ -18,3 +23,19 @@ const ProxyError = "unable to connect to target host"
 // TooManyProxyError is the error returned instead of ProxyError, when it occurs
 // too many times.
 const TooManyProxyError = "too many proxy failures to target host"
+
+// ErrProxyError is the class of errors reported by gateway when the host
+// cannot be reached.
+var ErrProxyError = errors.New(ProxyError)
+
+// ErrTooManyProxyErrors is returned by a Host instead of a proxy error when
+// they occur too many times.
+var ErrTooManyProxyErrors = errors.New(TooManyProxyError)
+
+// isProxyError returns true if the error is a proxy error. Gateways report
+// proxy errors in their message, so errors without the class are matched by
+// their text.
+func isProxyError(err error) bool {
+	return errors.Is(err, ErrProxyError) ||
+		strings.Contains(err.Error(), ProxyError)
+}
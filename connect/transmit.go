@@ -8,14 +8,12 @@
 package connect
 
 import (
+	"context"
 	"github.com/pkg/errors"
 	jww "github.com/spf13/jwalterweatherman"
-	"strings"
+	"time"
 )
 
-const inCoolDownErr = "Host is in cool down. Cannot connect."
-const lastTryErr = "Last try to connect to"
-
 // transmit sets up or recovers the Host's connection
 // Then runs the given Send function
 // This has a bug where if a disconnect happens after "host.transmit(f)"
@@ -25,13 +23,37 @@ const lastTryErr = "Last try to connect to"
 // Given that connections have timeouts, this is a minor issue
 func (c *ProtoComms) transmit(host *Host, f func(conn Connection) (interface{},
 	error)) (result interface{}, err error) {
+	return c.transmitWithContext(context.Background(), host,
+		func(_ context.Context, conn Connection) (interface{}, error) {
+			return f(conn)
+		})
+}
+
+// transmitWithContext runs transmit, giving up on connecting, authenticating
+// and retrying once the context is done. The context is passed on to f.
+func (c *ProtoComms) transmitWithContext(ctx context.Context, host *Host,
+	f func(ctx context.Context, conn Connection) (interface{}, error)) (
+	result interface{}, err error) {
 
 	if host.GetAddress() == "" {
-		return nil, errors.New("Host address is blank, host might be receive only.")
+		return nil, ErrBlankAddress
 	}
 
-	for numRetries := uint32(0); numRetries < host.params.MaxRetries; numRetries++ {
-		err = nil
+	policy := host.getSendRetryPolicy()
+	start := time.Now()
+	for attempt := uint32(0); ; attempt++ {
+		if ctx.Err() != nil {
+			return nil, errors.WithMessagef(ctx.Err(), "Gave up sending to "+
+				"host %s after %d attempts", host.id, attempt)
+		}
+
+		// do not dial a host whose circuit breaker is open
+		var probe bool
+		probe, err = host.allowSend()
+		if err != nil {
+			return nil, err
+		}
+
 		//reconnect if necessary
 		host.connectionMux.RLock()
 		connected, connectionCount := host.connectedUnsafe()
@@ -42,19 +64,25 @@ func (c *ProtoComms) transmit(host *Host, f func(conn Connection) (interface{},
 			// we cannot connect and we cannot send to a disconnected
 			// host
 			if host.params.DisableAutoConnect {
+				host.abandonSend(probe)
 				return nil, errors.Errorf("Cannot send to a disconnected" +
 					"host when AutoConnect is disabled")
 			}
 			host.connectionMux.Lock()
-			connectionCount, err = c.connect(host, connectionCount)
+			connectionCount, err = c.connect(ctx, host, connectionCount)
 			host.connectionMux.Unlock()
 			if err != nil {
-				if strings.Contains(err.Error(), inCoolDownErr) ||
-					strings.Contains(err.Error(), lastTryErr) {
+				host.recordSend(probe, err)
+				if errors.Is(err, ErrConnectFailed) || ctx.Err() != nil {
 					return nil, err
 				}
 				jww.WARN.Printf("Failed to connect to Host on attempt "+
-					"%v/%v : %s", numRetries+1, host.params.MaxSendRetries, err)
+					"%v: %s", attempt+1, err)
+				wait, retry := policy.Retry(attempt, time.Since(start), err)
+				if !retry {
+					return nil, err
+				}
+				waitRetry(ctx, wait)
 				continue
 			}
 			host.connectionMux.RLock()
@@ -66,47 +94,46 @@ func (c *ProtoComms) transmit(host *Host, f func(conn Connection) (interface{},
 			err = errors.New("Cannot send; connection is nil")
 		} else {
 			//transmit
-			result, err = host.transmit(f)
+			attemptCtx, cancel := attemptContext(ctx, policy, attempt)
+			result, err = host.transmit(func(conn Connection) (interface{},
+				error) {
+				return f(attemptCtx, conn)
+			})
+			cancel()
 		}
 		host.connectionMux.RUnlock()
+		host.recordSend(probe, err)
 
-		// if the transmission goes well or if it is a domain specific error, return
-		if err == nil || !(isConnError(err) || IsAuthError(err)) {
+		// if the transmission goes well or if the caller gave up, return
+		if err == nil || ctx.Err() != nil {
+			return result, err
+		}
+
+		// if it is a domain specific error or no attempts remain, return
+		wait, retry := policy.Retry(attempt, time.Since(start), err)
+		if !retry {
 			return result, err
 		}
 		host.connectionMux.Lock()
-		host.conditionalDisconnect(connectionCount)
+		host.conditionalDisconnect(connectionCount, err)
 		host.connectionMux.Unlock()
-		jww.WARN.Printf("Failed to send to Host on attempt %v/%v: %+v",
-			numRetries+1, host.params.MaxSendRetries, err)
+		jww.WARN.Printf("Failed to send to Host on attempt %v: %+v",
+			attempt+1, err)
+		waitRetry(ctx, wait)
 	}
-
-	return nil, err
 }
 
-func (c *ProtoComms) connect(host *Host, count uint64) (uint64, error) {
-	if host.coolOffBucket != nil {
-		if host.inCoolOff {
-			if host.coolOffBucket.IsEmpty() {
-				host.inCoolOff = false
-			} else {
-				return 0, errors.New(inCoolDownErr)
-			}
-		}
-		good, _ := host.coolOffBucket.Add(1)
-		host.inCoolOff = !good
-		if host.inCoolOff {
-			return 0, errors.New(inCoolDownErr)
-		}
-	}
-
+// connect connects to and authenticates with the host if needed, giving up
+// once the context is done
+func (c *ProtoComms) connect(ctx context.Context, host *Host,
+	count uint64) (uint64, error) {
 	//if the connection is alive return, it is possible for another transmission
 	//to connect between releasing the read lock and taking the write lock
 	if !host.isAlive() {
 		//connect to host
 		jww.INFO.Printf("Host %s not connected, attempting to connect...",
 			host.id)
-		err := host.connect()
+		err := host.connectWithContext(ctx)
 
 		count = host.connectionCount
 
@@ -122,13 +149,17 @@ func (c *ProtoComms) connect(host *Host, count uint64) (uint64, error) {
 	if host.authenticationRequired() {
 		jww.INFO.Printf("Attempting to establish authentication with host %s",
 			host.id)
-		err := c.clientHandshake(host)
+		start := time.Now()
+		err := c.clientHandshake(ctx, host)
+		c.handshakeLatency.observe(time.Since(start))
+		host.stats.observeHandshake(time.Since(start))
 
 		//if authentication cannot be made, do not retry
 		if err != nil {
-			host.disconnect()
-			return count, errors.WithMessagef(err, "Failed to authenticate with "+
-				"host: %s", host.id)
+			host.notify(HostHandshakeFailed, err)
+			host.disconnectWithCause(err)
+			return count, errors.WithMessagef(&handshakeError{err},
+				"Failed to authenticate with host: %s", host.id)
 		}
 	}
 
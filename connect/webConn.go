@@ -1,6 +1,7 @@
 package connect
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"net/http"
@@ -58,7 +59,13 @@ func (wc *webConn) GetGrpcConn() *grpc.ClientConn {
 
 // Connect initializes the appropriate connection using helper functions.
 func (wc *webConn) Connect() error {
-	return wc.connectWebHelper()
+	return wc.connectWebHelper(context.Background())
+}
+
+// connectWithContext initializes the connection, giving up once the context
+// is done.
+func (wc *webConn) connectWithContext(ctx context.Context) error {
+	return wc.connectWebHelper(ctx)
 }
 
 // IsWeb returns true if the connection is configured for web connections
@@ -74,7 +81,7 @@ func (wc *webConn) GetRemoteCertificate() (*x509.Certificate, error) {
 // connectWebHelper initializes the grpcweb ClientConn object
 // Note that until the downstream repo is fixed, this doesn't actually
 // establish a connection past creating the http object.
-func (wc *webConn) connectWebHelper() (err error) {
+func (wc *webConn) connectWebHelper(ctx context.Context) (err error) {
 	// Configure TLS options
 	var securityDial []grpcweb.DialOption
 
@@ -96,21 +103,16 @@ func (wc *webConn) connectWebHelper() (err error) {
 	jww.DEBUG.Printf("Attempting to establish connection to %s using "+
 		"credentials: %v", wc.h.GetAddress(), securityDial)
 
-	// Attempt to establish a new connection
-	var numRetries uint32
-	for numRetries = 0; numRetries < wc.h.params.MaxRetries && !wc.isAlive(); numRetries++ {
+	// Attempt to establish a new connection, retrying according to the
+	// ConnectRetryPolicy
+	policy := wc.h.getConnectRetryPolicy()
+	start := time.Now()
+	for attempt := uint32(0); !wc.isAlive() && ctx.Err() == nil; attempt++ {
 		wc.h.disconnect()
 
-		jww.DEBUG.Printf("Connecting to %s Attempt number %d of %d",
-			wc.h.GetAddress(), numRetries, wc.h.params.MaxRetries)
+		jww.DEBUG.Printf("Connecting to %s Attempt number %d",
+			wc.h.GetAddress(), attempt)
 
-		// If timeout is enabled, the max wait time becomes
-		// ~14 seconds (with maxRetries=100)
-		backoffTime := 2000 * (numRetries/16 + 1)
-		if backoffTime > 15000 {
-			backoffTime = 15000
-		}
-		// ctx, cancel := newContext(time.Duration(backoffTime) * time.Millisecond)
 		md := &metadata.MD{}
 		md.Set("Cache-Control", "no-store, must-revalidate")
 		noCache := grpcweb.Header(md)
@@ -132,18 +134,28 @@ func (wc *webConn) connectWebHelper() (err error) {
 
 		// Create the connection
 		wc.connection, err = grpcweb.DialContext(wc.h.GetAddress(), dialOpts...)
+		if wc.isAlive() {
+			break
+		} else if err == nil {
+			err = errors.New("connection is not alive")
+		}
 
-		if err != nil {
-			jww.DEBUG.Printf("Attempt number %d to connect to %s failed",
-				numRetries, wc.h.GetAddress())
+		jww.DEBUG.Printf("Attempt number %d to connect to %s failed: %s",
+			attempt, wc.h.GetAddress(), err)
+		wait, retry := policy.Retry(attempt, time.Since(start), err)
+		if !retry || !waitRetry(ctx, wait) {
+			break
 		}
-		// cancel()
 	}
 
 	// Verify that the connection was established successfully
 	if !wc.isAlive() {
 		wc.h.disconnect()
-		return errors.Errorf(
+		if ctx.Err() != nil {
+			return errors.WithMessagef(ctx.Err(),
+				"Gave up connecting to %s", wc.h.GetAddress())
+		}
+		return errors.WithMessagef(ErrConnectFailed,
 			"Last try to connect to %s failed. Giving up", wc.h.GetAddress())
 	}
 
@@ -13,6 +13,7 @@ require (
 	gitlab.com/xx_network/primitives v0.0.5
 	golang.org/x/crypto v0.16.0
 	golang.org/x/net v0.10.0
+	google.golang.org/genproto v0.0.0-20220822174746-9e6da59bd2fc
 	google.golang.org/grpc v1.49.0
 	google.golang.org/protobuf v1.28.1
 	src.agwa.name/tlshacks v0.0.0-20220518131152-d2c6f4e2b780
@@ -30,6 +31,5 @@ require (
 	go.uber.org/atomic v1.10.0 // indirect
 	golang.org/x/sys v0.15.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
-	google.golang.org/genproto v0.0.0-20220822174746-9e6da59bd2fc // indirect
 	nhooyr.io/websocket v1.8.6 // indirect
 )
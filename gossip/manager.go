@@ -15,10 +15,15 @@ import (
 	"gitlab.com/xx_network/comms/connect"
 	"gitlab.com/xx_network/crypto/csprng"
 	"gitlab.com/xx_network/primitives/id"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Name of the collector the Manager registers on the comms MetricsRegistry
+const metricsCollector = "gossip"
+
 // Structure holding messages for a given tag, if the tag does not yet exist
 // If the tag is not created in 5 minutes, the record should be deleted
 type MessageRecord struct {
@@ -68,6 +73,9 @@ func NewManager(comms *connect.ProtoComms, flags ManagerFlags) *Manager {
 		flags:     flags,
 	}
 	_ = m.bufferMonitor()
+	if comms != nil {
+		comms.GetMetricsRegistry().Register(metricsCollector, m.collectMetrics)
+	}
 	return m
 }
 
@@ -143,6 +151,34 @@ func (m *Manager) Delete(tag string) {
 	delete(m.protocols, tag)
 }
 
+// collectMetrics writes the receive, duplicate and send failure counts of
+// every Protocol, labeled by tag.
+func (m *Manager) collectMetrics(w *connect.MetricsWriter) {
+	m.protocolLock.RLock()
+	tags := make([]string, 0, len(m.protocols))
+	for tag := range m.protocols {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	protocols := make([]*Protocol, len(tags))
+	for i, tag := range tags {
+		protocols[i] = m.protocols[tag]
+	}
+	m.protocolLock.RUnlock()
+
+	for i, p := range protocols {
+		w.Counter("xx_gossip_received", "Number of new gossip messages "+
+			"received", float64(atomic.LoadUint64(&p.receivedCount)),
+			"tag", tags[i])
+		w.Counter("xx_gossip_duplicates", "Number of gossip messages "+
+			"received more than once", float64(atomic.LoadUint64(
+			&p.duplicateCount)), "tag", tags[i])
+		w.Counter("xx_gossip_send_failures", "Number of failed gossip "+
+			"sends to peers", float64(atomic.LoadUint64(&p.sendFailureCount)),
+			"tag", tags[i])
+	}
+}
+
 // Long-running thread to delete any messages in buffer older than 5m
 func (m *Manager) bufferMonitor() chan bool {
 	killChan := make(chan bool, 0)
@@ -8,8 +8,10 @@
 package gossip
 
 import (
+	"bytes"
 	"gitlab.com/xx_network/comms/connect"
 	"gitlab.com/xx_network/primitives/id"
+	"strings"
 	"testing"
 	"time"
 )
@@ -133,3 +135,38 @@ func TestManager_BufferMonitor(t *testing.T) {
 		t.Errorf("Failed to clear buffer after duration expired")
 	}
 }
+
+// Tests that the Manager exports the receive and duplicate counts of its
+// protocols on the comms metrics registry.
+func TestManager_collectMetrics(t *testing.T) {
+	pc := &connect.ProtoComms{
+		Manager: connect.NewManagerTesting(t),
+	}
+	m := NewManager(pc, DefaultManagerFlags())
+	m.NewGossip("test", DefaultProtocolFlags(),
+		func(*GossipMsg) error { return nil },
+		func(*GossipMsg, []byte) error { return nil }, []*id.ID{})
+	p, _ := m.Get("test")
+
+	msg := &GossipMsg{Tag: "test", Origin: []byte("origin"),
+		Payload: []byte("payload"), Signature: []byte("signature")}
+	for i := 0; i < 3; i++ {
+		if err := p.receive(msg); err != nil {
+			t.Fatalf("Failed to receive message: %+v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := pc.GetMetricsRegistry().Write(&buf); err != nil {
+		t.Fatalf("Failed to write metrics: %+v", err)
+	}
+	for _, line := range []string{
+		"xx_gossip_received_total{tag=\"test\"} 1\n",
+		"xx_gossip_duplicates_total{tag=\"test\"} 2\n",
+		"xx_gossip_send_failures_total{tag=\"test\"} 0\n",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("Output missing %q:\n%s", line, buf.String())
+		}
+	}
+}
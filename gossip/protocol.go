@@ -119,6 +119,12 @@ type Protocol struct {
 
 	// worker pool channel for sending
 	sendWorkers chan sendInstructions
+
+	// Counts of received, duplicate and failed-to-send messages, used for
+	// exported metrics
+	receivedCount    uint64
+	duplicateCount   uint64
+	sendFailureCount uint64
 }
 
 type sendInstructions struct {
@@ -199,11 +205,16 @@ func (p *Protocol) receive(msg *GossipMsg) error {
 
 		numSendsPrt, ok = p.setFingerprint(fingerprint)
 		if ok {
+			atomic.AddUint64(&p.receivedCount, 1)
 			err = p.receiver(msg)
 			if err != nil {
 				return errors.WithMessage(err, "Failed to receive gossip message")
 			}
+		} else {
+			atomic.AddUint64(&p.duplicateCount, 1)
 		}
+	} else {
+		atomic.AddUint64(&p.duplicateCount, 1)
 	}
 
 	// If the gossip is too old, then don't re-gossip it
@@ -343,6 +354,7 @@ func (p *Protocol) Gossip(msg *GossipMsg) (int, []error) {
 	}
 
 	if len(errs) > 0 {
+		atomic.AddUint64(&p.sendFailureCount, uint64(len(errs)))
 		return len(peers), errs
 	} else {
 		return len(peers), nil
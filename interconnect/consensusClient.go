@@ -10,7 +10,6 @@
 package interconnect
 
 import (
-	"errors"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/any"
 	jww "github.com/spf13/jwalterweatherman"
@@ -32,7 +31,7 @@ func (c *CMixServer) GetNdf(host *connect.Host,
 
 		resultMsg, err := NewInterconnectClient(conn.GetGrpcConn()).GetNDF(ctx, message)
 		if err != nil {
-			return nil, errors.New(err.Error())
+			return nil, err
 		}
 		return ptypes.MarshalAny(resultMsg)
 	}
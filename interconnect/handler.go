@@ -8,13 +8,19 @@
 package interconnect
 
 import (
+	"context"
 	jww "github.com/spf13/jwalterweatherman"
 	"gitlab.com/xx_network/comms/connect"
 	"gitlab.com/xx_network/primitives/id"
 	"net"
 	"runtime/debug"
+	"time"
 )
 
+// Amount of time in-flight requests are given to finish when the
+// interconnect server is closed
+const shutdownTimeout = 5 * time.Second
+
 // Close listener is a function which is returned by the interconnect constructor
 // This closes the listener and bound port
 type closeListener func() error
@@ -42,8 +48,10 @@ func StartCMixInterconnect(id *id.ID, port string, handler ServerHandler,
 	// TODO-2022: This should really be removed. It is handled by pc.Shutdown()
 	closeFunc := func() error {
 		jww.INFO.Printf("Closing listening port for CMix's interconnect service!")
-		CMixInterconnect.Shutdown()
-		return nil
+		ctx, cancel := context.WithTimeout(context.Background(),
+			shutdownTimeout)
+		defer cancel()
+		return CMixInterconnect.Shutdown(ctx)
 	}
 
 	pc.Serve()